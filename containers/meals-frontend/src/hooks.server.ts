@@ -0,0 +1,54 @@
+import type { Handle, HandleFetch } from '@sveltejs/kit';
+import { env } from '$env/dynamic/private';
+import { setSessionCookies, type SessionResponse } from '$lib/token-utils';
+
+// Refreshes in flight by refresh token, so a page's parallel requests share one refresh
+// rather than each rotating the same token.
+const refreshing = new Map<string, Promise<SessionResponse | null>>();
+
+async function refreshSession(
+	refreshToken: string,
+	clientAddress: string
+): Promise<SessionResponse | null> {
+	const res = await fetch(`${env.API_BASE_URL}/api/refresh`, {
+		method: 'POST',
+		headers: { Cookie: `refresh_token=${refreshToken}`, 'X-Forwarded-For': clientAddress }
+	});
+	return res.ok ? await res.json() : null;
+}
+
+// Access tokens are short-lived, so when one has expired, exchange the refresh token
+// for a new pair before the page talks to the backend.
+export const handle: Handle = async ({ event, resolve }) => {
+	const refreshToken = event.cookies.get('refresh_token');
+	if (!event.cookies.get('token') && refreshToken) {
+		let pending = refreshing.get(refreshToken);
+		if (!pending) {
+			pending = refreshSession(refreshToken, event.getClientAddress()).finally(() =>
+				refreshing.delete(refreshToken)
+			);
+			refreshing.set(refreshToken, pending);
+		}
+
+		try {
+			const session = await pending;
+			if (session) {
+				setSessionCookies(event.cookies, session);
+			} else {
+				event.cookies.delete('refresh_token', { path: '/' });
+			}
+		} catch (error) {
+			console.error(`Failed to refresh session: ${error}`);
+		}
+	}
+
+	return resolve(event);
+};
+
+// Passes the browser's IP on to the backend, which rate limits and audits by it.
+export const handleFetch: HandleFetch = async ({ event, request, fetch }) => {
+	if (request.url.startsWith(env.API_BASE_URL)) {
+		request.headers.set('X-Forwarded-For', event.getClientAddress());
+	}
+	return fetch(request);
+};
@@ -9,3 +9,27 @@ export function getTokenHeaders(cookies: Cookies): Record<string, string> {
 		Cookie: `token=${token ?? ''}`
 	};
 }
+
+export type SessionResponse = {
+	token: string;
+	refresh_token: string;
+};
+
+// Matches the backend's access token and refresh token lifetimes.
+const TOKEN_MAX_AGE = 15 * 60; // 15 minutes
+const REFRESH_TOKEN_MAX_AGE = 2.628e6; // 1 month
+
+export function setSessionCookies(cookies: Cookies, session: SessionResponse) {
+	cookies.set('token', session.token, {
+		secure: false,
+		httpOnly: true,
+		path: '/',
+		maxAge: TOKEN_MAX_AGE
+	});
+	cookies.set('refresh_token', session.refresh_token, {
+		secure: false,
+		httpOnly: true,
+		path: '/',
+		maxAge: REFRESH_TOKEN_MAX_AGE
+	});
+}
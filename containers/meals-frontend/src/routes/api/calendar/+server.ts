@@ -2,7 +2,7 @@ import type { RequestHandler } from './$types';
 import { env } from '$env/dynamic/private';
 import { getTokenHeaders } from '$lib/token-utils';
 
-export const GET: RequestHandler = async ({ url, cookies }) => {
+export const GET: RequestHandler = async ({ url, cookies, fetch }) => {
 	try {
 		// Retrieve query parameters and parse them as numbers.
 		const yearParam = url.searchParams.get('year');
@@ -3,7 +3,7 @@ import { env } from '$env/dynamic/private';
 import { getTokenHeaders } from '$lib/token-utils';
 import type { ExtraItemUpdate } from '$lib/types';
 
-export const POST: RequestHandler = async ({ request, cookies }) => {
+export const POST: RequestHandler = async ({ request, cookies, fetch }) => {
 	try {
 		const updatedOrNewItems: ExtraItemUpdate[] = await request.json();
 
@@ -1,7 +1,8 @@
 import type { RequestHandler } from '@sveltejs/kit';
 import { env } from '$env/dynamic/private';
+import { setSessionCookies } from '$lib/token-utils';
 
-export const POST: RequestHandler = async ({ request, cookies }) => {
+export const POST: RequestHandler = async ({ request, cookies, fetch }) => {
 	try {
 		const login = await request.json();
 
@@ -17,42 +18,16 @@ export const POST: RequestHandler = async ({ request, cookies }) => {
 		if (!res.ok) {
 			const message = data.error ? data.error : 'An error occurred while logging in.';
 			return new Response(JSON.stringify({ message }), {
-				status: 401,
+				status: res.status === 429 ? 429 : 401,
 				headers: { 'Content-Type': 'application/json' }
 			});
 		}
 
-		const setCookieHeader = res.headers.get('set-cookie');
-		if (!setCookieHeader) {
-			return new Response(JSON.stringify({ message: 'Something went wrong getting cookie' }), {
-				status: 401,
-				headers: { 'Content-Type': 'application/json' }
-			});
-		}
-
-		const [cookieNameValue] = setCookieHeader.split(';');
-		const [cookieName, cookieValue] = cookieNameValue.split('=');
-
-		if (!cookieName || !cookieValue) {
-			return new Response(JSON.stringify({ message: 'Something went wrong setting cookie' }), {
-				status: 401,
-				headers: { 'Content-Type': 'application/json' }
-			});
-		}
-
-		cookies.set(cookieName, cookieValue, {
-			secure: false,
-			httpOnly: true,
-			path: '/',
-			maxAge: 2.628e6 // 1 month
-		});
+		setSessionCookies(cookies, data);
 
-		return new Response(JSON.stringify(data), {
+		return new Response(JSON.stringify({ status: 'success' }), {
 			status: res.status,
-			headers: {
-				'Content-Type': 'application/json',
-				'Set-Cookie': res.headers.get('set-cookie') || ''
-			}
+			headers: { 'Content-Type': 'application/json' }
 		});
 	} catch (error) {
 		return new Response(JSON.stringify({ error: `Request failed: ${error}` }), {
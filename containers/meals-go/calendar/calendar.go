@@ -70,6 +70,15 @@ func (c *Calendar) DaysInMonth() int {
 	return lastOfMonth.Day()
 }
 
+// Dates returns every date of the month.
+func (c *Calendar) Dates() []time.Time {
+	var dates []time.Time
+	for d := time.Date(c.Year, c.Month, 1, 0, 0, 0, 0, time.UTC); d.Month() == c.Month; d = d.AddDate(0, 0, 1) {
+		dates = append(dates, d)
+	}
+	return dates
+}
+
 func (c *Calendar) FirstWeekdayOfMonth() time.Weekday {
 	firstOfMonth := time.Date(c.Year, c.Month, 1, 0, 0, 0, 0, time.UTC)
 	return firstOfMonth.Weekday()
@@ -12,6 +12,19 @@ func TestDaysInMonth(t *testing.T) {
 	}
 }
 
+func TestDates(t *testing.T) {
+	dates := NewCalendar(2024, time.February).Dates()
+	if len(dates) != 29 {
+		t.Fatalf("Expected 29 dates in February 2024, got %d", len(dates))
+	}
+	if first := time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC); !dates[0].Equal(first) {
+		t.Errorf("Expected the first date to be %v, got %v", first, dates[0])
+	}
+	if last := time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC); !dates[28].Equal(last) {
+		t.Errorf("Expected the last date to be %v, got %v", last, dates[28])
+	}
+}
+
 func TestFirstWeekdayOfMonth(t *testing.T) {
 	calendar := NewCalendar(2024, time.October)
 	if weekday := calendar.FirstWeekdayOfMonth(); weekday != time.Tuesday {
@@ -14,14 +14,75 @@ var Cfg Config
 // k is the internal koanf instance
 var k = koanf.New(".")
 
+// DayTemplate configures a single weekday of app.weekly_template. Leaving both fields
+// empty generates a meal from the whole collection.
+type DayTemplate struct {
+	Meal     string `koanf:"meal"`
+	Category string `koanf:"category"`
+	// MaxPrepMinutes limits how long generated meals on this weekday take; 0 means no limit
+	MaxPrepMinutes int `koanf:"max_prep_minutes"`
+}
+
+// WeekConstraint configures one entry of app.generation.constraints, bounding how many
+// meals tagged Tag are served each week. Leaving Max unset means no upper limit.
+type WeekConstraint struct {
+	Tag      string `koanf:"tag"`
+	Min      int    `koanf:"min"`
+	Max      *int   `koanf:"max"`
+	Weekdays bool   `koanf:"weekdays"`
+}
+
+// IngredientUnit configures how quantities of an ingredient are merged on grocery lists.
+type IngredientUnit struct {
+	Preferred   string  `koanf:"preferred"`
+	GramsPerCup float64 `koanf:"grams_per_cup"`
+}
+
+// Store configures a store profile under app.stores: the order its aisles are walked in,
+// what it calls the recipe aisles, and where it shelves ingredients that differ from the
+// recipe's aisle.
+type Store struct {
+	Name      string   `koanf:"name"`
+	Aisles    []string `koanf:"aisles"`
+	PdfLayout []int    `koanf:"pdf_layout"`
+
+	// AisleRenames is keyed by recipe aisle, e.g. "Produce", and applies before AisleMap
+	AisleRenames map[string]string `koanf:"aisle_renames"`
+
+	// AisleMap is keyed by ingredient name, e.g. "tortillas"
+	AisleMap map[string]string `koanf:"aisle_map"`
+}
+
 type Config struct {
 	App struct {
 		Aisles    []string `koanf:"aisles"`
 		PdfLayout []int    `koanf:"pdf_layout"`
+
+		// HouseholdSize is how many servings to shop for; 0 keeps recipe quantities as-is
+		HouseholdSize int `koanf:"household_size"`
+
+		Generation struct {
+			Mode        string `koanf:"mode"`
+			CategoryGap int    `koanf:"category_gap"`
+			Stable      bool   `koanf:"stable"`
+
+			Constraints []WeekConstraint `koanf:"constraints"`
+		} `koanf:"generation"`
+
+		// WeeklyTemplate is keyed by weekday name, e.g. "thursday"
+		WeeklyTemplate map[string]DayTemplate `koanf:"weekly_template"`
+
+		// IngredientUnits is keyed by ingredient name, e.g. "rice"
+		IngredientUnits map[string]IngredientUnit `koanf:"ingredient_units"`
+
+		// Stores are optional; Aisles and PdfLayout above are used when none is chosen
+		Stores []Store `koanf:"stores"`
 	} `koanf:"app"`
 
 	Server struct {
 		AllowedOrigins []string `koanf:"allowed_origins"`
+		// TrustedProxies may set X-Forwarded-For, e.g. the frontend. Others can't spoof it
+		TrustedProxies []string `koanf:"trusted_proxies"`
 	} `koanf:"server"`
 
 	AWS struct {
@@ -34,6 +95,7 @@ type Config struct {
 	Email struct {
 		Sender    string   `koanf:"sender"`
 		Receivers []string `koanf:"receivers"`
+		Store     string   `koanf:"store"`
 	} `koanf:"email"`
 
 	Database struct {
@@ -4,10 +4,12 @@ import (
 	"flag"
 	"log"
 	"os"
+	"time"
 
 	"github.com/andrewpollack/pi-infrastructure/containers/meals-go/config"
 	"github.com/andrewpollack/pi-infrastructure/containers/meals-go/meal_backend"
 	"github.com/andrewpollack/pi-infrastructure/containers/meals-go/meal_calendar"
+	"github.com/andrewpollack/pi-infrastructure/containers/meals-go/meal_collection"
 	"github.com/andrewpollack/pi-infrastructure/containers/meals-go/meal_db_sync"
 	"github.com/andrewpollack/pi-infrastructure/containers/meals-go/meal_email"
 )
@@ -18,20 +20,34 @@ type Config struct {
 	DeploymentPassword string
 
 	// CORS configuration
-	JWTSigningKey []byte
+	JWTSigningKey  []byte
+	JWTSigningKeys string
 
 	// DBSync configuration
 	SyncCleanTable bool
 	SyncLongLive   bool
+
+	// Export configuration
+	ExportPath   string
+	ExportUpload bool
 }
 
+const (
+	// The backend refuses to start with these, as they're in the source
+	DEFAULT_JWT_SIGNING_KEY     = "my-secret-key"
+	DEFAULT_DEPLOYMENT_PASSWORD = "temp"
+)
+
 var (
 	conf               = flag.String("conf", envString("CONF", "/app/conf.yaml"), "Path to the config file")
-	runMode            = flag.String("run_mode", envString("RUN_MODE", ""), "Application run mode: backend, email, db_sync, legacy")
+	runMode            = flag.String("run_mode", envString("RUN_MODE", ""), "Application run mode: backend, email, db_sync, export, legacy")
 	syncCleanTable     = flag.Bool("clean_table", envBool("CLEAN_TABLE", false), "Remove any unseen keys from database on sync")
 	syncLongLive       = flag.Bool("long_live", envBool("LONG_LIVE", false), "Whether sync job should run indefinitely")
-	JWTSigningKey      = flag.String("jwt_signing_key", envString("JWT_SIGNING_KEY", "my-secret-key"), "JWT signing key for authentication")
-	deploymentPassword = flag.String("deployment_password", envString("DEPLOYMENT_PASSWORD", "temp"), "Password for deployment")
+	exportPath         = flag.String("export_path", envString("EXPORT_PATH", ""), "File to export recipes to, stdout if empty")
+	exportUpload       = flag.Bool("export_upload", envBool("EXPORT_UPLOAD", false), "Whether to upload exported recipes to the configured bucket")
+	JWTSigningKey      = flag.String("jwt_signing_key", envString("JWT_SIGNING_KEY", DEFAULT_JWT_SIGNING_KEY), "JWT signing key for authentication")
+	JWTSigningKeys     = flag.String("jwt_signing_keys", envString("JWT_SIGNING_KEYS", ""), "JWT signing keys as id1:key1,id2:key2, the first signing new tokens. Overrides jwt_signing_key")
+	deploymentPassword = flag.String("deployment_password", envString("DEPLOYMENT_PASSWORD", DEFAULT_DEPLOYMENT_PASSWORD), "Password of the initial admin user, created when there are no users")
 )
 
 func envString(key, fallback string) string {
@@ -55,9 +71,98 @@ func parseFlags() Config {
 		RunMode:            *runMode,
 		SyncCleanTable:     *syncCleanTable,
 		SyncLongLive:       *syncLongLive,
+		ExportPath:         *exportPath,
+		ExportUpload:       *exportUpload,
 		DeploymentPassword: *deploymentPassword,
 		JWTSigningKey:      []byte(*JWTSigningKey),
+		JWTSigningKeys:     *JWTSigningKeys,
+	}
+}
+
+// signingKeys returns the backend's JWT signing keys, refusing to use the default key.
+// A lone jwt_signing_key gets the id "default".
+func signingKeys(c Config) []meal_backend.SigningKey {
+	keys := []meal_backend.SigningKey{{ID: "default", Key: c.JWTSigningKey}}
+	if c.JWTSigningKeys != "" {
+		var err error
+		keys, err = meal_backend.ParseSigningKeys(c.JWTSigningKeys)
+		if err != nil {
+			log.Fatalf("Invalid jwt_signing_keys: %v", err)
+		}
+	}
+
+	for _, key := range keys {
+		if string(key.Key) == DEFAULT_JWT_SIGNING_KEY {
+			log.Fatalf("Refusing to run the backend with the default JWT signing key, set JWT_SIGNING_KEY or JWT_SIGNING_KEYS")
+		}
+	}
+	return keys
+}
+
+// checkTemplateCategories warns about rules of the weekly template whose category no meal
+// is in. It's checked once at startup, as generation silently serves whatever meal is left
+// on those days.
+func checkTemplateCategories(template meal_collection.WeeklyTemplate) {
+	if config.Cfg.Database.Postgres.URL == "" {
+		return
+	}
+
+	collection, err := meal_collection.ReadMealCollectionFromDB(config.Cfg.Database.Postgres.URL, time.Now().Unix())
+	if err != nil {
+		log.Printf("Warning: could not check app.weekly_template categories: %v\n", err)
+		return
+	}
+	for _, day := range template.UnknownCategories(collection) {
+		log.Printf("Warning: no meal is in category '%s' of the %s rule in app.weekly_template\n", template[day].Category, day)
+	}
+}
+
+// generateOptions builds the meal generation options from the loaded config.
+func generateOptions() meal_collection.GenerateOptions {
+	opts := meal_collection.GenerateOptions{
+		Mode:        meal_collection.GenerationMode(config.Cfg.App.Generation.Mode),
+		CategoryGap: config.Cfg.App.Generation.CategoryGap,
+		Stable:      config.Cfg.App.Generation.Stable,
+	}
+	if err := opts.Mode.IsValid(); err != nil {
+		log.Fatalf("Invalid app.generation.mode: %v", err)
+	}
+
+	if len(config.Cfg.App.Generation.Constraints) > meal_collection.MAX_WEEK_CONSTRAINTS {
+		log.Fatalf("Invalid app.generation.constraints: at most %d constraints are supported, got %d", meal_collection.MAX_WEEK_CONSTRAINTS, len(config.Cfg.App.Generation.Constraints))
+	}
+	for _, constraint := range config.Cfg.App.Generation.Constraints {
+		weekConstraint := meal_collection.WeekConstraint{
+			Tag:      constraint.Tag,
+			Min:      constraint.Min,
+			Max:      constraint.Max,
+			Weekdays: constraint.Weekdays,
+		}
+		if err := weekConstraint.IsValid(); err != nil {
+			log.Fatalf("Invalid app.generation.constraints: %v", err)
+		}
+		opts.Constraints = append(opts.Constraints, weekConstraint)
 	}
+
+	if len(config.Cfg.App.WeeklyTemplate) > 0 {
+		rules := map[string]meal_collection.DayRule{}
+		for day, dayTemplate := range config.Cfg.App.WeeklyTemplate {
+			rules[day] = meal_collection.DayRule{
+				Meal:           dayTemplate.Meal,
+				Category:       dayTemplate.Category,
+				MaxPrepMinutes: dayTemplate.MaxPrepMinutes,
+			}
+		}
+
+		template, err := meal_collection.NewWeeklyTemplate(rules)
+		if err != nil {
+			log.Fatalf("Invalid app.weekly_template: %v", err)
+		}
+		checkTemplateCategories(template)
+		opts.Template = template
+	}
+
+	return opts
 }
 
 func main() {
@@ -67,23 +172,35 @@ func main() {
 
 	switch c.RunMode {
 	case "backend":
+		if c.DeploymentPassword == DEFAULT_DEPLOYMENT_PASSWORD {
+			log.Fatalf("Refusing to run the backend with the default deployment password, set DEPLOYMENT_PASSWORD")
+		}
+		if len(c.DeploymentPassword) < meal_backend.MIN_PASSWORD_LENGTH {
+			// It's the initial admin's password, so it has to be a valid password
+			log.Fatalf("DEPLOYMENT_PASSWORD must be at least %d characters, as the initial admin user logs in with it", meal_backend.MIN_PASSWORD_LENGTH)
+		}
+
 		mealBackendConfig := meal_backend.Config{
 			PostgresURL:          config.Cfg.Database.Postgres.URL,
 			PostgresMigrationDir: "file://migrations",
 			EmailSender:          config.Cfg.Email.Sender,
 			EmailReceivers:       config.Cfg.Email.Receivers,
 			AllowOrigins:         config.Cfg.Server.AllowedOrigins,
-			JWTSigningKey:        c.JWTSigningKey,
+			TrustedProxies:       config.Cfg.Server.TrustedProxies,
+			JWTSigningKeys:       signingKeys(c),
 			DeploymentPassword:   c.DeploymentPassword,
+			GenerateOptions:      generateOptions(),
 		}
 
 		mealBackendConfig.RunBackend()
 	case "email":
 		mealEmailConfig := meal_email.Config{
-			PostgresURL:  config.Cfg.Database.Postgres.URL,
-			EmailService: meal_email.SES,
-			Sender:       config.Cfg.Email.Sender,
-			Receivers:    config.Cfg.Email.Receivers,
+			PostgresURL:     config.Cfg.Database.Postgres.URL,
+			EmailService:    meal_email.SES,
+			Sender:          config.Cfg.Email.Sender,
+			Receivers:       config.Cfg.Email.Receivers,
+			GenerateOptions: generateOptions(),
+			Store:           config.Cfg.Email.Store,
 		}
 
 		err := mealEmailConfig.CreateAndSendEmail()
@@ -103,12 +220,27 @@ func main() {
 		if err != nil {
 			log.Printf("Error: %s\n", err)
 		}
+	case "export":
+		mealDbSyncConfig := meal_db_sync.Config{
+			PostgresURL:  config.Cfg.Database.Postgres.URL,
+			BucketName:   config.Cfg.AWS.Bucket.Name,
+			BucketKey:    config.Cfg.AWS.Bucket.Key,
+			ExportPath:   c.ExportPath,
+			ExportUpload: c.ExportUpload,
+		}
+
+		err := mealDbSyncConfig.ExportMeals()
+		if err != nil {
+			log.Printf("Error: %s\n", err)
+		}
 	case "legacy":
 		// Legacy frontend+backend combined in one service
 		mealsLegacyCalendarConfig := meal_calendar.Config{
-			BucketName: config.Cfg.AWS.Bucket.Name,
-			BucketKey:  config.Cfg.AWS.Bucket.Key,
-			Port:       8001,
+			BucketName:      config.Cfg.AWS.Bucket.Name,
+			BucketKey:       config.Cfg.AWS.Bucket.Key,
+			Port:            8001,
+			PostgresURL:     config.Cfg.Database.Postgres.URL,
+			GenerateOptions: generateOptions(),
 		}
 
 		mealsLegacyCalendarConfig.RunServer()
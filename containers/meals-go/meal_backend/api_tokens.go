@@ -0,0 +1,416 @@
+package meal_backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// Scope is what an API token can be used for. Each route accepts one scope.
+type Scope string
+
+const (
+	ScopeCalendarRead  Scope = "calendar:read"
+	ScopeCalendarWrite Scope = "calendar:write"
+	ScopeMealsRead     Scope = "meals:read"
+	ScopeMealsWrite    Scope = "meals:write"
+	ScopeItemsRead     Scope = "items:read"
+	ScopeItemsWrite    Scope = "items:write"
+	ScopeEmailSend     Scope = "email:send"
+	// ScopeSession routes only accept a logged in session, never an API token
+	ScopeSession Scope = ""
+)
+
+// scopeRoles are the roles needed for each scope, so a token can't be given a scope
+// its user couldn't use anyway.
+var scopeRoles = map[Scope]Role{
+	ScopeCalendarRead:  RoleViewer,
+	ScopeCalendarWrite: RoleEditor,
+	ScopeMealsRead:     RoleViewer,
+	ScopeMealsWrite:    RoleEditor,
+	ScopeItemsRead:     RoleViewer,
+	ScopeItemsWrite:    RoleEditor,
+	ScopeEmailSend:     RoleEditor,
+}
+
+func (s Scope) IsValid() error {
+	if _, ok := scopeRoles[s]; !ok {
+		return errors.New("invalid scope: " + string(s))
+	}
+	return nil
+}
+
+// API_TOKEN_PREFIX starts every API token, telling them apart from access tokens.
+const API_TOKEN_PREFIX = "mgo_"
+
+// API_TOKEN_CONTEXT_KEY is where authenticateMiddleware stores the APIToken used, if any.
+const API_TOKEN_CONTEXT_KEY = "api_token"
+
+var (
+	ErrAPITokenExists       = errors.New("API token already exists")
+	ErrAPITokenNotFound     = errors.New("API token not found")
+	ErrAPITokenRevoked      = errors.New("API token has been revoked")
+	ErrAPITokenMissingScope = errors.New("API token is missing scope")
+)
+
+// APIToken is a named token letting scripts call the API as its user, limited to its
+// scopes. Only its hash is stored, so the token itself is shown once when created.
+// Like a session, it's revoked when its user's session version is bumped.
+type APIToken struct {
+	Name           string
+	Scopes         []Scope
+	Created        time.Time
+	LastUsed       *time.Time
+	SessionVersion int `json:"-"`
+}
+
+// Allows reports whether the token has scope.
+func (t APIToken) Allows(scope Scope) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAPIToken checks that token, belonging to user, hasn't been revoked and can be
+// used for scope.
+func checkAPIToken(token APIToken, user User, scope Scope) error {
+	if token.SessionVersion != user.SessionVersion {
+		return ErrAPITokenRevoked
+	}
+	if !token.Allows(scope) {
+		return fmt.Errorf("%w %s", ErrAPITokenMissingScope, scope)
+	}
+	return nil
+}
+
+// validateAPIToken checks that a token is named, and has scopes its user's role allows.
+func validateAPIToken(token APIToken, role Role) error {
+	if strings.TrimSpace(token.Name) == "" {
+		return errors.New("token name cannot be empty")
+	}
+	if len(token.Scopes) == 0 {
+		return errors.New("token needs at least one scope")
+	}
+	for _, scope := range token.Scopes {
+		if err := scope.IsValid(); err != nil {
+			return err
+		}
+		if !role.Allows(scopeRoles[scope]) {
+			return fmt.Errorf("scope %s requires the %s role", scope, scopeRoles[scope])
+		}
+	}
+	return nil
+}
+
+// ReadAPITokensFromDB returns the API tokens of username that haven't been revoked,
+// sorted by name.
+func ReadAPITokensFromDB(postgresURL string, username string) ([]APIToken, error) {
+	if postgresURL == "" {
+		return nil, fmt.Errorf("POSTGRES_URL is not set")
+	}
+
+	conn, err := pgx.Connect(context.Background(), postgresURL)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to database: %v", err)
+	}
+	defer func() {
+		if err := conn.Close(context.Background()); err != nil {
+			fmt.Printf("error closing connection: %v\n", err)
+		}
+	}()
+
+	rows, err := conn.Query(context.Background(), `
+		SELECT t.name, t.scopes, t.date_created, t.last_used, t.session_version
+		FROM api_tokens t
+		JOIN users u ON u.username = t.username
+		WHERE t.username = $1 AND t.session_version = u.session_version
+		ORDER BY t.name
+	`, username)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %v", err)
+	}
+	defer rows.Close()
+
+	var tokens []APIToken
+	for rows.Next() {
+		var t APIToken
+		if err := rows.Scan(&t.Name, &t.Scopes, &t.Created, &t.LastUsed, &t.SessionVersion); err != nil {
+			return nil, fmt.Errorf("scan failed: %v", err)
+		}
+		tokens = append(tokens, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %v", err)
+	}
+
+	return tokens, nil
+}
+
+// ReadAPITokenUserFromDB returns the API token hashed as tokenHash and its user,
+// recording that it was used, or ErrAPITokenNotFound.
+func ReadAPITokenUserFromDB(postgresURL string, tokenHash string) (APIToken, User, error) {
+	if postgresURL == "" {
+		return APIToken{}, User{}, fmt.Errorf("POSTGRES_URL is not set")
+	}
+
+	conn, err := pgx.Connect(context.Background(), postgresURL)
+	if err != nil {
+		return APIToken{}, User{}, fmt.Errorf("unable to connect to database: %v", err)
+	}
+	defer func() {
+		if err := conn.Close(context.Background()); err != nil {
+			fmt.Printf("error closing connection: %v\n", err)
+		}
+	}()
+
+	var (
+		token APIToken
+		user  User
+	)
+	err = conn.QueryRow(context.Background(), `
+		UPDATE api_tokens t
+		SET last_used = now()
+		FROM users u
+		WHERE t.token_hash = $1 AND u.username = t.username
+		RETURNING t.name, t.scopes, t.date_created, t.last_used, t.session_version,
+		          u.username, u.role, u.password_hash, u.session_version
+	`, tokenHash).Scan(&token.Name, &token.Scopes, &token.Created, &token.LastUsed, &token.SessionVersion,
+		&user.Username, &user.Role, &user.PasswordHash, &user.SessionVersion)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return APIToken{}, User{}, ErrAPITokenNotFound
+	}
+	if err != nil {
+		return APIToken{}, User{}, fmt.Errorf("query failed: %v", err)
+	}
+
+	return token, user, nil
+}
+
+// CreateAPITokenInDB stores the API token hashed as tokenHash for username, valid for
+// their current session version. It replaces a revoked token of the same name.
+func CreateAPITokenInDB(postgresURL string, username string, token APIToken, tokenHash string) error {
+	if postgresURL == "" {
+		return fmt.Errorf("POSTGRES_URL is not set")
+	}
+
+	conn, err := pgx.Connect(context.Background(), postgresURL)
+	if err != nil {
+		return fmt.Errorf("unable to connect to database: %v", err)
+	}
+	defer func() {
+		if err := conn.Close(context.Background()); err != nil {
+			fmt.Printf("error closing connection: %v\n", err)
+		}
+	}()
+
+	res, err := conn.Exec(context.Background(), `
+		INSERT INTO api_tokens (username, name, token_hash, scopes, session_version)
+		SELECT username, $2, $3, $4, session_version
+		FROM users
+		WHERE username = $1
+		ON CONFLICT (username, name) DO UPDATE
+		SET date_created = now(),
+		    token_hash = EXCLUDED.token_hash,
+		    scopes = EXCLUDED.scopes,
+		    last_used = NULL,
+		    session_version = EXCLUDED.session_version
+		WHERE api_tokens.session_version <> EXCLUDED.session_version
+	`, username, token.Name, tokenHash, token.Scopes)
+	if err != nil {
+		return fmt.Errorf("query failed: %v", err)
+	}
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("%w: %s", ErrAPITokenExists, token.Name)
+	}
+
+	return nil
+}
+
+// DeleteAPITokenFromDB revokes the API token of username named name.
+func DeleteAPITokenFromDB(postgresURL string, username string, name string) error {
+	if postgresURL == "" {
+		return fmt.Errorf("POSTGRES_URL is not set")
+	}
+
+	conn, err := pgx.Connect(context.Background(), postgresURL)
+	if err != nil {
+		return fmt.Errorf("unable to connect to database: %v", err)
+	}
+	defer func() {
+		if err := conn.Close(context.Background()); err != nil {
+			fmt.Printf("error closing connection: %v\n", err)
+		}
+	}()
+
+	res, err := conn.Exec(context.Background(), `
+		DELETE FROM api_tokens
+		WHERE username = $1 AND name = $2
+	`, username, name)
+	if err != nil {
+		return fmt.Errorf("query failed: %v", err)
+	}
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("%w: %s", ErrAPITokenNotFound, name)
+	}
+
+	return nil
+}
+
+// currentAPIToken returns the API token the request was authenticated with, if any.
+func currentAPIToken(ctx *gin.Context) (APIToken, bool) {
+	token, ok := ctx.Get(API_TOKEN_CONTEXT_KEY)
+	if !ok {
+		return APIToken{}, false
+	}
+	t, ok := token.(APIToken)
+	return t, ok
+}
+
+// currentActor names who made the request for the audit log, including the API token
+// used, if any.
+func currentActor(ctx *gin.Context) string {
+	username := currentUser(ctx).Username
+	if token, ok := currentAPIToken(ctx); ok {
+		return fmt.Sprintf("%s (token %s)", username, token.Name)
+	}
+	return username
+}
+
+// authenticateAPIToken returns the user of an API token, storing the token in the
+// context under API_TOKEN_CONTEXT_KEY. It writes the error response and returns false
+// when the token isn't valid, has been revoked or doesn't have scope.
+func (c Config) authenticateAPIToken(ctx *gin.Context, secret string, scope Scope) (User, bool) {
+	if scope == ScopeSession {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "API tokens cannot be used here"})
+		return User{}, false
+	}
+
+	token, user, err := ReadAPITokenUserFromDB(c.PostgresURL, hashToken(secret))
+	if errors.Is(err, ErrAPITokenNotFound) {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid API token"})
+		return User{}, false
+	}
+	if err != nil {
+		log.Println("Error in authenticateAPIToken while fetching API token:", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return User{}, false
+	}
+
+	switch err := checkAPIToken(token, user, scope); {
+	case errors.Is(err, ErrAPITokenRevoked):
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "API token has been revoked"})
+		return User{}, false
+	case errors.Is(err, ErrAPITokenMissingScope):
+		ctx.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("API token needs the %s scope", scope)})
+		return User{}, false
+	}
+
+	ctx.Set(API_TOKEN_CONTEXT_KEY, token)
+	return user, true
+}
+
+// apiTokenErrorStatus maps an error from writing an API token to its HTTP status.
+func apiTokenErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrAPITokenExists):
+		return http.StatusConflict
+	case errors.Is(err, ErrAPITokenNotFound):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// APITokenRequest represents the payload for creating an API token.
+type APITokenRequest struct {
+	Name   string  `json:"name"`
+	Scopes []Scope `json:"scopes"`
+}
+
+// GetAPITokens handles the GET /tokens endpoint, returning the logged in user's API
+// tokens without the tokens themselves.
+func (c Config) GetAPITokens(ctx *gin.Context) {
+	tokens, err := ReadAPITokensFromDB(c.PostgresURL, currentUser(ctx).Username)
+	if err != nil {
+		log.Println("Error in GetAPITokens while fetching API tokens:", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err,
+		})
+		return
+	}
+
+	if tokens == nil {
+		tokens = []APIToken{}
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"tokens": tokens,
+	})
+}
+
+// CreateAPIToken handles the POST /tokens endpoint, returning the new token. This is
+// the only time it's shown.
+func (c Config) CreateAPIToken(ctx *gin.Context) {
+	var tokenRequest APITokenRequest
+	if err := ctx.BindJSON(&tokenRequest); err != nil {
+		log.Println("Error in CreateAPIToken while binding JSON:", err)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err,
+		})
+		return
+	}
+
+	user := currentUser(ctx)
+	token := APIToken{Name: tokenRequest.Name, Scopes: tokenRequest.Scopes}
+	if err := validateAPIToken(token, user.Role); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	secret, hash, err := newSecretToken(API_TOKEN_PREFIX)
+	if err != nil {
+		log.Println("Error in CreateAPIToken while creating token:", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create token"})
+		return
+	}
+
+	if err := CreateAPITokenInDB(c.PostgresURL, user.Username, token, hash); err != nil {
+		log.Println("Error in CreateAPIToken while creating token in DB:", err)
+		ctx.JSON(apiTokenErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"token":  secret,
+	})
+}
+
+// DeleteAPIToken handles the DELETE /tokens/:name endpoint, revoking the logged in
+// user's API token.
+func (c Config) DeleteAPIToken(ctx *gin.Context) {
+	name := ctx.Param("name")
+	if err := DeleteAPITokenFromDB(c.PostgresURL, currentUser(ctx).Username, name); err != nil {
+		log.Println("Error in DeleteAPIToken while deleting token from DB:", err)
+		ctx.JSON(apiTokenErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+	})
+}
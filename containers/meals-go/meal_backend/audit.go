@@ -0,0 +1,200 @@
+package meal_backend
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andrewpollack/pi-infrastructure/containers/meals-go/meal_collection"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// AuditAction is what an audit log entry records.
+type AuditAction string
+
+const (
+	AuditLogin        AuditAction = "login"
+	AuditLoginFailed  AuditAction = "login_failed"
+	AuditLoginLocked  AuditAction = "login_locked"
+	AuditEmailSent    AuditAction = "email_sent"
+	AuditMealsEnabled AuditAction = "meals_enabled"
+	AuditItemsUpdated AuditAction = "items_updated"
+)
+
+const (
+	// AUDIT_DEFAULT_LIMIT is how many entries GET /audit returns by default
+	AUDIT_DEFAULT_LIMIT = 100
+	// AUDIT_MAX_LIMIT is the most entries GET /audit returns
+	AUDIT_MAX_LIMIT = 1000
+)
+
+// AuditEntry records who did what, from where and when.
+type AuditEntry struct {
+	ID      int
+	Time    time.Time
+	Actor   string
+	IP      string
+	Action  AuditAction
+	Details string
+}
+
+// AddAuditLogInDB records an entry in the audit log.
+func AddAuditLogInDB(postgresURL string, entry AuditEntry) error {
+	if postgresURL == "" {
+		return fmt.Errorf("POSTGRES_URL is not set")
+	}
+
+	conn, err := pgx.Connect(context.Background(), postgresURL)
+	if err != nil {
+		return fmt.Errorf("unable to connect to database: %v", err)
+	}
+	defer func() {
+		if err := conn.Close(context.Background()); err != nil {
+			fmt.Printf("error closing connection: %v\n", err)
+		}
+	}()
+
+	_, err = conn.Exec(context.Background(), `
+		INSERT INTO audit_log (actor, ip, action, details)
+		VALUES ($1, $2, $3, $4)
+	`, entry.Actor, entry.IP, entry.Action, entry.Details)
+	if err != nil {
+		return fmt.Errorf("query failed: %v", err)
+	}
+
+	return nil
+}
+
+// ReadAuditLogFromDB returns the most recent limit entries of the audit log, newest first.
+func ReadAuditLogFromDB(postgresURL string, limit int) ([]AuditEntry, error) {
+	if postgresURL == "" {
+		return nil, fmt.Errorf("POSTGRES_URL is not set")
+	}
+
+	conn, err := pgx.Connect(context.Background(), postgresURL)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to database: %v", err)
+	}
+	defer func() {
+		if err := conn.Close(context.Background()); err != nil {
+			fmt.Printf("error closing connection: %v\n", err)
+		}
+	}()
+
+	rows, err := conn.Query(context.Background(), `
+		SELECT id, date_created, actor, ip, action, details
+		FROM audit_log
+		ORDER BY date_created DESC, id DESC
+		LIMIT $1
+	`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %v", err)
+	}
+	defer rows.Close()
+
+	var entries []AuditEntry
+	for rows.Next() {
+		var e AuditEntry
+		if err := rows.Scan(&e.ID, &e.Time, &e.Actor, &e.IP, &e.Action, &e.Details); err != nil {
+			return nil, fmt.Errorf("scan failed: %v", err)
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %v", err)
+	}
+
+	return entries, nil
+}
+
+// audit records action by actor from the request's IP. Failing to record it is logged,
+// but doesn't fail the request.
+func (c Config) audit(ctx *gin.Context, actor string, action AuditAction, details string) {
+	entry := AuditEntry{
+		Actor:   actor,
+		IP:      ctx.ClientIP(),
+		Action:  action,
+		Details: details,
+	}
+	if err := AddAuditLogInDB(c.PostgresURL, entry); err != nil {
+		log.Printf("Error recording %s by %s in audit log: %v\n", action, actor, err)
+	}
+}
+
+// describeMealUpdates summarizes meal enable/disable updates for the audit log, like
+// "enabled Chili; disabled Tacos".
+func describeMealUpdates(updates []meal_collection.MealUpdate) string {
+	var enabled, disabled []string
+	for _, update := range updates {
+		if update.Disabled {
+			disabled = append(disabled, update.Name)
+		} else {
+			enabled = append(enabled, update.Name)
+		}
+	}
+
+	var parts []string
+	if len(enabled) > 0 {
+		parts = append(parts, "enabled "+strings.Join(enabled, ", "))
+	}
+	if len(disabled) > 0 {
+		parts = append(parts, "disabled "+strings.Join(disabled, ", "))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// describeItemUpdates summarizes extra item edits for the audit log, like
+// "Add Milk; Update Eggs -> Large Eggs; Delete Bread".
+func describeItemUpdates(updates []meal_collection.FEExtraItem) string {
+	var parts []string
+	for _, update := range updates {
+		switch {
+		case update.Action == meal_collection.Add:
+			parts = append(parts, fmt.Sprintf("%s %s", update.Action, update.New.Name))
+		case update.Action == meal_collection.Update && update.Old.Name != update.New.Name:
+			parts = append(parts, fmt.Sprintf("%s %s -> %s", update.Action, update.Old.Name, update.New.Name))
+		default:
+			parts = append(parts, fmt.Sprintf("%s %s", update.Action, update.Old.Name))
+		}
+	}
+	return strings.Join(parts, "; ")
+}
+
+// GetAuditLog handles the GET /audit endpoint, returning the most recent entries of the
+// audit log. The optional limit query parameter defaults to AUDIT_DEFAULT_LIMIT.
+func (c Config) GetAuditLog(ctx *gin.Context) {
+	limit := AUDIT_DEFAULT_LIMIT
+	if limitStr := ctx.Query("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > AUDIT_MAX_LIMIT {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("limit must be between 1 and %d", AUDIT_MAX_LIMIT),
+			})
+			return
+		}
+	}
+
+	entries, err := ReadAuditLogFromDB(c.PostgresURL, limit)
+	if err != nil {
+		log.Println("Error in GetAuditLog while fetching audit log:", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err,
+		})
+		return
+	}
+
+	if entries == nil {
+		entries = []AuditEntry{}
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"entries": entries,
+	})
+}
@@ -1,31 +1,106 @@
 package meal_backend
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 )
 
-const EXPIRES_IN = 730 * time.Hour // 1 month
+// EXPIRES_IN is how long an access token lasts. Sessions outlive it by refreshing.
+const EXPIRES_IN = 15 * time.Minute
+
 var signingMethod = jwt.SigningMethodHS256
 
-func createToken(signingKey []byte) (string, error) {
-	// TODO: Replace with actual username
-	const USERNAME = "admin"
-	claims := &jwt.RegisteredClaims{
-		ExpiresAt: jwt.NewNumericDate(time.Now().Add(EXPIRES_IN)),
-		IssuedAt:  jwt.NewNumericDate(time.Now()),
-		Issuer:    "meals-go",
-		Subject:   USERNAME,
-		Audience:  getRole(USERNAME),
+const (
+	// TOKEN_ISSUER is the issuer of every token, which must match when verifying
+	TOKEN_ISSUER = "meals-go"
+	// TOKEN_AUDIENCE is who access tokens are for, which must match when verifying
+	TOKEN_AUDIENCE = "meals-go-backend"
+)
+
+// USER_CONTEXT_KEY is where authenticateMiddleware stores the authenticated User.
+const USER_CONTEXT_KEY = "user"
+
+// TOKEN_COOKIE holds the access token.
+const TOKEN_COOKIE = "token"
+
+var (
+	ErrInvalidToken   = errors.New("invalid token")
+	ErrSessionRevoked = errors.New("session has been revoked")
+)
+
+// SigningKey is a key tokens are signed with, identified in their kid header.
+type SigningKey struct {
+	ID  string
+	Key []byte
+}
+
+// ParseSigningKeys parses keys in the format "id1:key1,id2:key2". The first key signs
+// new tokens, and every key verifies them, so a key can be rotated by adding a new one
+// first and dropping the old one once its tokens have expired.
+func ParseSigningKeys(keys string) ([]SigningKey, error) {
+	var signingKeys []SigningKey
+	seen := map[string]bool{}
+	for i, entry := range strings.Split(keys, ",") {
+		id, key, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok || id == "" || key == "" {
+			// The entry isn't included, as it may be a key
+			return nil, fmt.Errorf("invalid signing key #%d, expected id:key", i+1)
+		}
+		if seen[id] {
+			return nil, fmt.Errorf("duplicate signing key id '%s'", id)
+		}
+		seen[id] = true
+		signingKeys = append(signingKeys, SigningKey{ID: id, Key: []byte(key)})
+	}
+	return signingKeys, nil
+}
+
+// findSigningKey returns the key whose ID is in the token's kid header.
+func (c Config) findSigningKey(token *jwt.Token) (interface{}, error) {
+	kid, ok := token.Header["kid"].(string)
+	if !ok {
+		return nil, errors.New("token has no key id")
+	}
+	for _, key := range c.JWTSigningKeys {
+		if key.ID == kid {
+			return key.Key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown key id '%s'", kid)
+}
+
+// tokenClaims are the claims of an access token.
+type tokenClaims struct {
+	jwt.RegisteredClaims
+	// SessionVersion must match the user's for the token to be accepted
+	SessionVersion int `json:"session_version"`
+}
+
+// createToken issues an access token for user, signed with signingKey. The user's role
+// isn't in the token, it's looked up on every request so role changes and deletions
+// apply immediately.
+func createToken(signingKey SigningKey, user User) (string, error) {
+	claims := &tokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(EXPIRES_IN)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			Issuer:    TOKEN_ISSUER,
+			Subject:   user.Username,
+			Audience:  jwt.ClaimStrings{TOKEN_AUDIENCE},
+		},
+		SessionVersion: user.SessionVersion,
 	}
 
 	token := jwt.NewWithClaims(signingMethod, claims)
-	ss, err := token.SignedString(signingKey)
+	token.Header["kid"] = signingKey.ID
+	ss, err := token.SignedString(signingKey.Key)
 	if err != nil {
 		log.Printf("Error signing token: %v", err)
 		return "", fmt.Errorf("failed to sign token: %w", err)
@@ -34,44 +109,119 @@ func createToken(signingKey []byte) (string, error) {
 	return ss, err
 }
 
-func getRole(username string) []string {
-	return []string{"user"}
-}
-
-func verifyToken(tokenString string, signingKey []byte) (*jwt.Token, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return signingKey, nil
-	})
+// parseToken checks the access token's signature and claims, returning them. Only
+// signingMethod is accepted, so a token can't pick a weaker algorithm.
+func (c Config) parseToken(tokenString string) (*tokenClaims, error) {
+	claims := &tokenClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, c.findSigningKey,
+		jwt.WithValidMethods([]string{signingMethod.Alg()}),
+		jwt.WithIssuer(TOKEN_ISSUER),
+		jwt.WithAudience(TOKEN_AUDIENCE),
+		jwt.WithExpirationRequired(),
+	)
 
 	// Check for verification errors
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
 	}
 
 	// Check if the token is valid
 	if !token.Valid {
-		return nil, fmt.Errorf("invalid token")
+		return nil, ErrInvalidToken
 	}
 
-	return token, nil
+	return claims, nil
 }
 
-func (c Config) authenticateMiddleware(ctx *gin.Context) {
-	tokenString, err := ctx.Cookie("token")
+// verifyToken checks the access token and returns the user it was issued to. Tokens
+// from before the user's sessions were revoked fail with ErrSessionRevoked.
+func (c Config) verifyToken(tokenString string) (User, error) {
+	claims, err := c.parseToken(tokenString)
 	if err != nil {
-		fmt.Println("Token missing in cookie")
-		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Token missing in cookie"})
-		ctx.Abort()
-		return
+		return User{}, err
 	}
 
-	_, err = verifyToken(tokenString, c.JWTSigningKey)
+	user, err := ReadUserFromDB(c.PostgresURL, claims.Subject)
 	if err != nil {
-		fmt.Printf("Token verification failed: %v\\n", err)
+		return User{}, err
+	}
+	if claims.SessionVersion != user.SessionVersion {
+		return User{}, ErrSessionRevoked
+	}
+
+	return user, nil
+}
+
+// authenticateMiddleware only lets through requests from a user whose role allows role.
+// Requests are authenticated by an access token, in the token cookie or an
+// "Authorization: Bearer" header, or by an API token with scope in that header. The user
+// is stored in the context under USER_CONTEXT_KEY.
+func (c Config) authenticateMiddleware(role Role, scope Scope) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		bearer, hasBearer := strings.CutPrefix(ctx.GetHeader("Authorization"), "Bearer ")
+
+		var (
+			user User
+			ok   bool
+		)
+		switch {
+		case hasBearer && strings.HasPrefix(bearer, API_TOKEN_PREFIX):
+			user, ok = c.authenticateAPIToken(ctx, bearer, scope)
+		case hasBearer:
+			user, ok = c.authenticateSession(ctx, bearer)
+		default:
+			tokenString, err := ctx.Cookie(TOKEN_COOKIE)
+			if err != nil {
+				fmt.Println("Token missing in cookie")
+				ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Token missing in cookie"})
+				ctx.Abort()
+				return
+			}
+			user, ok = c.authenticateSession(ctx, tokenString)
+		}
+		if !ok {
+			ctx.Abort()
+			return
+		}
+
+		if !user.Role.Allows(role) {
+			ctx.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Requires the %s role", role)})
+			ctx.Abort()
+			return
+		}
+
+		ctx.Set(USER_CONTEXT_KEY, user)
+		ctx.Next()
+	}
+}
+
+// authenticateSession returns the user of an access token, writing the error response
+// and returning false when it isn't valid.
+func (c Config) authenticateSession(ctx *gin.Context, tokenString string) (User, bool) {
+	user, err := c.verifyToken(tokenString)
+	switch {
+	case errors.Is(err, ErrInvalidToken):
+		fmt.Printf("Token verification failed: %v\n", err)
 		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Token verification failed"})
-		ctx.Abort()
-		return
+		return User{}, false
+	case errors.Is(err, ErrSessionRevoked):
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Session has been revoked"})
+		return User{}, false
+	case errors.Is(err, ErrUserNotFound):
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User no longer exists"})
+		return User{}, false
+	case err != nil:
+		log.Println("Error in authenticateSession while fetching user:", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return User{}, false
 	}
 
-	ctx.Next()
+	return user, true
+}
+
+// currentUser returns the user authenticated by authenticateMiddleware.
+func currentUser(ctx *gin.Context) User {
+	user, _ := ctx.Get(USER_CONTEXT_KEY)
+	u, _ := user.(User)
+	return u
 }
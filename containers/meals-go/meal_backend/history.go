@@ -0,0 +1,184 @@
+package meal_backend
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/andrewpollack/pi-infrastructure/containers/meals-go/meal_collection"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HistoryEntryRequest represents the payload for adding or updating a history entry.
+// Rating is optional; 0 means unrated.
+type HistoryEntryRequest struct {
+	Date   string `json:"date"`
+	Meal   string `json:"meal"`
+	Status string `json:"status"`
+	Rating int    `json:"rating"`
+	Notes  string `json:"notes"`
+}
+
+// HistoryEntryResponse represents a meal history entry.
+type HistoryEntryResponse struct {
+	ID     int
+	Date   string
+	Meal   string
+	Status string
+	Rating int
+	Notes  string
+}
+
+// historyErrorStatus maps an error from writing a history entry to its HTTP status.
+func historyErrorStatus(err error) int {
+	if errors.Is(err, meal_collection.ErrHistoryNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+// bindHistoryEntry binds and validates the history entry in the request body, writing
+// the error response and returning false when it isn't valid.
+func bindHistoryEntry(ctx *gin.Context, handler string) (meal_collection.HistoryEntry, bool) {
+	var request HistoryEntryRequest
+	if err := ctx.BindJSON(&request); err != nil {
+		log.Println("Error in "+handler+" while binding JSON:", err)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err,
+		})
+		return meal_collection.HistoryEntry{}, false
+	}
+
+	date, err := parseDate(request.Date)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return meal_collection.HistoryEntry{}, false
+	}
+
+	entry := meal_collection.HistoryEntry{
+		Date:   date,
+		Meal:   request.Meal,
+		Status: meal_collection.HistoryStatus(request.Status),
+		Rating: request.Rating,
+		Notes:  request.Notes,
+	}
+	if err := entry.Validate(); err != nil {
+		log.Println("Error in "+handler+":", err)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return entry, false
+	}
+
+	return entry, true
+}
+
+// historyID parses the :id path parameter, writing the error response and returning
+// false when it isn't a number.
+func historyID(ctx *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid history id"})
+		return 0, false
+	}
+	return id, true
+}
+
+// GetMealHistory handles the GET /history endpoint, returning every history entry.
+func (c Config) GetMealHistory(ctx *gin.Context) {
+	history, err := meal_collection.ReadMealHistoryFromDB(c.PostgresURL)
+	if err != nil {
+		log.Println("Error in GetMealHistory while fetching meal history:", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err,
+		})
+		return
+	}
+
+	historyResponse := make([]HistoryEntryResponse, 0, len(history))
+	for _, entry := range history {
+		historyResponse = append(historyResponse, HistoryEntryResponse{
+			ID:     entry.ID,
+			Date:   entry.Date.Format(time.DateOnly),
+			Meal:   entry.Meal,
+			Status: string(entry.Status),
+			Rating: entry.Rating,
+			Notes:  entry.Notes,
+		})
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"history": historyResponse,
+	})
+}
+
+// AddMealHistory handles the POST /history endpoint.
+func (c Config) AddMealHistory(ctx *gin.Context) {
+	entry, ok := bindHistoryEntry(ctx, "AddMealHistory")
+	if !ok {
+		return
+	}
+
+	id, err := meal_collection.AddMealHistoryInDB(c.PostgresURL, entry)
+	if err != nil {
+		log.Println("Error in AddMealHistory while adding history in DB:", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"id":     id,
+	})
+}
+
+// UpdateMealHistory handles the PUT /history/:id endpoint, replacing the entry.
+func (c Config) UpdateMealHistory(ctx *gin.Context) {
+	id, ok := historyID(ctx)
+	if !ok {
+		return
+	}
+
+	entry, ok := bindHistoryEntry(ctx, "UpdateMealHistory")
+	if !ok {
+		return
+	}
+	entry.ID = id
+
+	if err := meal_collection.UpdateMealHistoryInDB(c.PostgresURL, entry); err != nil {
+		log.Println("Error in UpdateMealHistory while updating history in DB:", err)
+		ctx.JSON(historyErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+	})
+}
+
+// DeleteMealHistory handles the DELETE /history/:id endpoint.
+func (c Config) DeleteMealHistory(ctx *gin.Context) {
+	id, ok := historyID(ctx)
+	if !ok {
+		return
+	}
+
+	if err := meal_collection.DeleteMealHistoryFromDB(c.PostgresURL, id); err != nil {
+		log.Println("Error in DeleteMealHistory while deleting history from DB:", err)
+		ctx.JSON(historyErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+	})
+}
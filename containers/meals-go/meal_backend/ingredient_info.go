@@ -0,0 +1,64 @@
+package meal_backend
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/andrewpollack/pi-infrastructure/containers/meals-go/meal_collection"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetIngredientInfo handles the GET /ingredient-info endpoint, returning the price and
+// nutrition data of every ingredient.
+func (c Config) GetIngredientInfo(ctx *gin.Context) {
+	infos, err := meal_collection.ReadIngredientInfoFromDB(c.PostgresURL)
+	if err != nil {
+		log.Println("Error in GetIngredientInfo while fetching ingredient info:", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err,
+		})
+		return
+	}
+
+	if infos == nil {
+		infos = meal_collection.IngredientInfos{}
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"ingredient_info": infos,
+	})
+}
+
+// UpdateIngredientInfo handles the POST /ingredient-info/update endpoint, applying a list of
+// Add/Update/Delete actions.
+func (c Config) UpdateIngredientInfo(ctx *gin.Context) {
+	var infoUpdate []meal_collection.FEIngredientInfo
+	if err := ctx.BindJSON(&infoUpdate); err != nil {
+		log.Println("Error in UpdateIngredientInfo while binding JSON:", err)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err,
+		})
+		return
+	}
+
+	if err := meal_collection.ValidateIngredientInfoUpdates(infoUpdate); err != nil {
+		log.Println("Error in UpdateIngredientInfo:", err)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if err := meal_collection.UpdateIngredientInfoInDB(c.PostgresURL, infoUpdate); err != nil {
+		log.Println("Error in UpdateIngredientInfo while updating ingredient info in DB:", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err,
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+	})
+}
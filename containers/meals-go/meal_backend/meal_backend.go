@@ -1,6 +1,7 @@
 package meal_backend
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -26,9 +27,13 @@ type Config struct {
 	EmailSender          string
 	EmailReceivers       []string
 	AllowOrigins         []string
-	DomainName           string
-	JWTSigningKey        []byte
-	DeploymentPassword   string
+	// TrustedProxies are the addresses or CIDRs whose X-Forwarded-For is believed
+	TrustedProxies []string
+	DomainName     string
+	// JWTSigningKeys verify tokens, and the first also signs them
+	JWTSigningKeys     []SigningKey
+	DeploymentPassword string
+	GenerateOptions    meal_collection.GenerateOptions
 }
 
 // DayResponse represents a meal for a given day.
@@ -37,6 +42,15 @@ type DayResponse struct {
 	Meal    string
 	URL     *string
 	Enabled bool
+	Pinned  bool
+	Tags    []string `json:",omitempty"`
+	// DisplayName is set when it differs from Meal, like "Leftovers: Chili (from Mon)"
+	DisplayName string `json:",omitempty"`
+	// PrepMinutes and ActiveMinutes are 0 when unknown
+	PrepMinutes   int `json:",omitempty"`
+	ActiveMinutes int `json:",omitempty"`
+	// Estimate is the meal's cost and nutrition, when ingredient data is available
+	Estimate *meal_collection.Estimate `json:",omitempty"`
 }
 
 // ExtraItemResponse represents an extra item response.
@@ -49,10 +63,14 @@ type BackendCalendarResponse struct {
 	Year          int
 	Month         string
 	MealsEachWeek [][]DayResponse
+	// EstimateEachWeek totals the meal estimates of each week, when ingredient data is available
+	EstimateEachWeek []meal_collection.Estimate `json:",omitempty"`
 }
 
-// CreateBackendCalendarResponse creates a calendar response.
-func CreateBackendCalendarResponse(collection meal_collection.MealCollection, year int, month time.Month) BackendCalendarResponse {
+// CreateBackendCalendarResponse creates a calendar response, with the stored schedule
+// applied over the generated meals. When infos is non-empty, each meal and week also
+// gets a cost and nutrition estimate at the planned servings.
+func CreateBackendCalendarResponse(collection meal_collection.MealCollection, year int, month time.Month, opts meal_collection.GenerateOptions, schedule meal_collection.Schedule, infos meal_collection.IngredientInfos, preferences meal_collection.UnitPreferences) BackendCalendarResponse {
 	resp := BackendCalendarResponse{
 		Year:          year,
 		Month:         month.String(),
@@ -64,10 +82,25 @@ func CreateBackendCalendarResponse(collection meal_collection.MealCollection, ye
 		MealCollection: collection,
 	}
 
-	items := mc.MealCollection.GenerateMealsWholeYearNoCategories(mc.Calendar)
+	dates := mc.Calendar.Dates()
+	items := mc.MealCollection.MealsForDates(dates, opts, schedule)
+
+	var estimates []meal_collection.Estimate
+	if len(infos) > 0 {
+		servings := meal_collection.ServingsForDates(schedule.Plan, dates, config.Cfg.App.HouseholdSize)
+		estimates = infos.EstimateMeals(meal_collection.ScaleMeals(items, servings), preferences)
+	}
+
+	pinnedDays := map[int]bool{}
+	for _, planned := range schedule.Plan {
+		if planned.IsPinned() && planned.Date.Year() == year && planned.Date.Month() == month {
+			pinnedDays[planned.Date.Day()] = true
+		}
+	}
 
 	for _, week := range mc.Calendar.Weeks {
 		var weekMeals []DayResponse
+		var weekEstimate meal_collection.Estimate
 		for _, day := range week {
 			var item meal_collection.Meal
 			switch day.Number {
@@ -80,14 +113,29 @@ func CreateBackendCalendarResponse(collection meal_collection.MealCollection, ye
 			}
 
 			itemResp := DayResponse{
-				Day:     day.Number,
-				Meal:    item.Name,
-				URL:     item.URL,
-				Enabled: !item.Disabled,
+				Day:           day.Number,
+				Meal:          item.Name,
+				URL:           item.URL,
+				Enabled:       !item.Disabled,
+				Pinned:        pinnedDays[day.Number],
+				Tags:          item.Tags,
+				PrepMinutes:   item.PrepMinutes,
+				ActiveMinutes: item.ActiveMinutes,
+			}
+			if item.LeftoversOf != nil {
+				itemResp.DisplayName = item.DisplayName()
+			}
+			if estimates != nil && day.Number != 0 {
+				estimate := estimates[day.Number-1]
+				itemResp.Estimate = &estimate
+				weekEstimate = weekEstimate.Add(estimate)
 			}
 			weekMeals = append(weekMeals, itemResp)
 		}
 		resp.MealsEachWeek = append(resp.MealsEachWeek, weekMeals)
+		if estimates != nil {
+			resp.EstimateEachWeek = append(resp.EstimateEachWeek, weekEstimate)
+		}
 	}
 
 	return resp
@@ -133,14 +181,52 @@ func (c Config) GetCalendar(ctx *gin.Context) {
 		return
 	}
 
-	monthResponse := CreateBackendCalendarResponse(collection, year, month)
+	schedule, err := meal_collection.LoadScheduleFromDB(c.PostgresURL, calendar.NewCalendar(year, month).Dates(), c.GenerateOptions)
+	if err != nil {
+		log.Println("Error in GetCalendar while fetching schedule:", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err,
+		})
+		return
+	}
+
+	infos, err := meal_collection.ReadIngredientInfoFromDB(c.PostgresURL)
+	if err != nil {
+		log.Println("Error in GetCalendar while fetching ingredient info:", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err,
+		})
+		return
+	}
+
+	preferences, err := meal_email.UnitPreferences()
+	if err != nil {
+		log.Println("Error in GetCalendar while reading unit preferences:", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err,
+		})
+		return
+	}
+
+	monthResponse := CreateBackendCalendarResponse(collection, year, month, c.GenerateOptions, schedule, infos, preferences)
 
 	ctx.JSON(http.StatusOK, gin.H{
 		"currMonthResponse": monthResponse,
 	})
 }
 
-// GetMeals handles the GET /meals endpoint.
+// hasAllTags reports whether meal has every one of tags.
+func hasAllTags(meal meal_collection.Meal, tags []string) bool {
+	for _, tag := range tags {
+		if !meal.HasTag(tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// GetMeals handles the GET /meals endpoint. Each tag query parameter, e.g.
+// ?tag=vegetarian&tag=quick, only keeps meals with that tag.
 func (c Config) GetMeals(ctx *gin.Context) {
 	mealCollection, err := meal_collection.ReadMealCollectionFromDB(c.PostgresURL, time.Now().Unix())
 	if err != nil {
@@ -157,13 +243,21 @@ func (c Config) GetMeals(ctx *gin.Context) {
 			strings.ToLower(mealCollection[j].Name)
 	})
 
+	tags := ctx.QueryArray("tag")
+
 	allMeals := make([]DayResponse, 0, len(mealCollection))
 	for _, item := range mealCollection {
+		if !hasAllTags(item, tags) {
+			continue
+		}
 		allMeals = append(allMeals, DayResponse{
-			Day:     0,
-			Meal:    item.Name,
-			URL:     item.URL,
-			Enabled: !item.Disabled,
+			Day:           0,
+			Meal:          item.Name,
+			URL:           item.URL,
+			Enabled:       !item.Disabled,
+			Tags:          item.Tags,
+			PrepMinutes:   item.PrepMinutes,
+			ActiveMinutes: item.ActiveMinutes,
 		})
 	}
 
@@ -216,9 +310,12 @@ type SendEmailRequest struct {
 	Meals      []string `json:"meals"`
 	Emails     []string `json:"emails"`
 	ExtraItems []string `json:"extraItems"`
+	Store      string   `json:"store"`
 }
 
-// SendEmail handles the POST /email endpoint.
+// SendEmail handles the POST /email endpoint. With GenerateOptions.Stable, the emailed
+// meals are snapshotted rather than pinned, so the calendar keeps showing what was
+// shopped for while pins stay manual.
 func (c Config) SendEmail(ctx *gin.Context) {
 	var emailRequest SendEmailRequest
 	if err := ctx.BindJSON(&emailRequest); err != nil {
@@ -276,6 +373,14 @@ func (c Config) SendEmail(ctx *gin.Context) {
 		return
 	}
 
+	if _, err := meal_email.FindStore(emailRequest.Store); err != nil {
+		log.Println("Error in SendEmail:", err)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
 	mealCollection, err := meal_collection.ReadMealCollectionFromDB(c.PostgresURL, time.Now().Unix())
 	if err != nil {
 		log.Println("Error in SendEmail while fetching meal collection:", err)
@@ -294,7 +399,8 @@ func (c Config) SendEmail(ctx *gin.Context) {
 		return
 	}
 
-	mealMap := mealCollection.MapNameToMeal()
+	// Meals fixed by the template, like "Pizza night", are on the calendar too
+	mealMap := mealCollection.MapNameToMealWithTemplate(c.GenerateOptions.Template)
 	var currMealNames []string
 	for _, meal := range meals {
 		// If the meal isn't found in the map, return an error.
@@ -330,12 +436,14 @@ func (c Config) SendEmail(ctx *gin.Context) {
 	}
 
 	mealEmailConfig := meal_email.Config{
-		PostgresURL:    c.PostgresURL,
-		EmailService:   meal_email.SES,
-		HardcodedMeals: currMealNames,
-		Sender:         c.EmailSender,
-		Receivers:      emails,
-		ExtraItems:     extraItemNames,
+		PostgresURL:     c.PostgresURL,
+		EmailService:    meal_email.SES,
+		HardcodedMeals:  currMealNames,
+		Sender:          c.EmailSender,
+		Receivers:       emails,
+		ExtraItems:      extraItemNames,
+		GenerateOptions: c.GenerateOptions,
+		Store:           emailRequest.Store,
 	}
 	err = mealEmailConfig.CreateAndSendEmail()
 	if err != nil {
@@ -345,6 +453,8 @@ func (c Config) SendEmail(ctx *gin.Context) {
 		})
 		return
 	}
+	c.audit(ctx, currentActor(ctx), AuditEmailSent,
+		fmt.Sprintf("to %s from %s: %s", strings.Join(emails, ", "), emailRequest.Store, strings.Join(currMealNames, ", ")))
 
 	ctx.JSON(http.StatusOK, gin.H{
 		"status": "success",
@@ -406,6 +516,7 @@ func (c Config) EnableMeals(ctx *gin.Context) {
 		})
 		return
 	}
+	c.audit(ctx, currentActor(ctx), AuditMealsEnabled, describeMealUpdates(updatesToApply))
 
 	ctx.JSON(http.StatusOK, gin.H{
 		"status": "success",
@@ -423,6 +534,14 @@ func (c Config) UpdateItems(ctx *gin.Context) {
 		return
 	}
 
+	if err := meal_collection.ValidateExtraItemUpdates(extraItemsUpdate); err != nil {
+		log.Println("Error in UpdateItems:", err)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
 	err := meal_collection.UpdateExtraItemsInDB(c.PostgresURL, extraItemsUpdate)
 	if err != nil {
 		log.Println("Error in UpdateItems while updating items in DB:", err)
@@ -431,6 +550,7 @@ func (c Config) UpdateItems(ctx *gin.Context) {
 		})
 		return
 	}
+	c.audit(ctx, currentActor(ctx), AuditItemsUpdated, describeItemUpdates(extraItemsUpdate))
 
 	ctx.JSON(http.StatusOK, gin.H{
 		"status": "success",
@@ -447,6 +567,7 @@ func HealthCheck(c *gin.Context) {
 
 // PostLoginRequest represents the login request payload.
 type PostLoginRequest struct {
+	Username string `json:"username"`
 	Password string `json:"password"`
 }
 
@@ -461,24 +582,63 @@ func (c Config) Login(ctx *gin.Context) {
 		return
 	}
 
-	if loginRequest.Password == c.DeploymentPassword {
-		tokenString, err := createToken(c.JWTSigningKey)
+	ip := ctx.ClientIP()
+	if wait := loginLimiter.attempt(ip, loginRequest.Username); wait > 0 {
+		if loginLimiter.reportLockout(ip, loginRequest.Username) {
+			c.audit(ctx, loginRequest.Username, AuditLoginLocked, "")
+		}
+		ctx.Header("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		ctx.JSON(http.StatusTooManyRequests, gin.H{
+			"error": fmt.Sprintf("Too many failed logins, try again in %s", wait.Round(time.Second)),
+		})
+		return
+	}
+
+	user, err := ReadUserFromDB(c.PostgresURL, loginRequest.Username)
+	if err != nil && !errors.Is(err, ErrUserNotFound) {
+		log.Println("Error in Login while fetching user:", err)
+		loginLimiter.release(ip, loginRequest.Username)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log in"})
+		return
+	}
+	found := err == nil
+	if !found {
+		user = User{PasswordHash: dummyPasswordHash}
+	}
+
+	if user.CheckPassword(loginRequest.Password) && found {
+		refreshToken, refreshHash, err := newSecretToken("")
+		if err == nil {
+			err = AddRefreshTokenInDB(c.PostgresURL, user, refreshHash)
+		}
 		if err != nil {
+			log.Println("Error in Login while creating refresh token:", err)
+			loginLimiter.release(ip, loginRequest.Username)
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log in"})
+			return
+		}
+
+		if err := c.startSession(ctx, user, refreshToken); err != nil {
+			loginLimiter.release(ip, loginRequest.Username)
 			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create token"})
 			return
 		}
-		ctx.SetCookie("token", tokenString, 2.628e+6, "/", "", false, true)
-		ctx.JSON(http.StatusOK, gin.H{"token": tokenString})
+		loginLimiter.succeed(ip, user.Username)
+		c.audit(ctx, user.Username, AuditLogin, "")
 	} else {
+		c.audit(ctx, loginRequest.Username, AuditLoginFailed, "")
 		time.Sleep(2 * time.Second)
 		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 	}
 }
 
-// Auth verifies the authentication token.
+// Auth verifies the authentication token, returning who is logged in.
 func (c Config) Auth(ctx *gin.Context) {
+	user := currentUser(ctx)
 	ctx.JSON(http.StatusOK, gin.H{
-		"status": "success",
+		"status":   "success",
+		"username": user.Username,
+		"role":     user.Role,
 	})
 }
 
@@ -496,14 +656,30 @@ func (c Config) GetEmails(ctx *gin.Context) {
 	})
 }
 
+// GetStores handles the GET /stores endpoint to return the names of configured stores.
+func (c Config) GetStores(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{
+		"stores": meal_email.StoreNames(),
+	})
+}
+
 // RunBackend initializes migrations and starts the Gin router.
 func (c Config) RunBackend() {
 	// TODO: At some point, it would be nice to run migrations not in this
 	// server, but in a separate one. This would allow us to run multiple
 	// copies of the backend without worrying about migration conflicts.
 	c.runMigrations()
+	c.ensureInitialAdmin()
+	if c.GenerateOptions.Stable {
+		go c.snapshotMeals()
+	}
 
 	router := gin.Default()
+	// Without this, gin believes any client's X-Forwarded-For, letting it dodge the login
+	// rate limit and forge the audit log's IPs
+	if err := router.SetTrustedProxies(c.TrustedProxies); err != nil {
+		log.Fatalf("Invalid server.trusted_proxies: %v", err)
+	}
 	router.Use(cors.New(cors.Config{
 		AllowOrigins:     c.AllowOrigins,
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
@@ -512,20 +688,50 @@ func (c Config) RunBackend() {
 	}))
 
 	router.GET("/health", HealthCheck)
-	router.GET("/auth", c.authenticateMiddleware, c.Auth)
+	router.GET("/auth", c.authenticateMiddleware(RoleViewer, ScopeSession), c.Auth)
 
 	api := router.Group("/api")
 	api.POST("/login", c.Login)
+	api.POST("/refresh", c.Refresh)
+	api.POST("/logout", c.Logout)
 
 	// Require authentication for all other routes
-	api.GET("/calendar", c.authenticateMiddleware, c.GetCalendar)
-	api.GET("/items", c.authenticateMiddleware, c.GetItems)
-	api.POST("/items/update", c.authenticateMiddleware, c.UpdateItems)
-	api.POST("/email", c.authenticateMiddleware, c.SendEmail)
-	api.GET("/meals", c.authenticateMiddleware, c.GetMeals)
-	api.POST("/meals/enable", c.authenticateMiddleware, c.EnableMeals)
-	api.GET("/aisles", c.authenticateMiddleware, c.GetAisles)
-	api.GET("/emails", c.authenticateMiddleware, c.GetEmails)
+	api.GET("/calendar", c.authenticateMiddleware(RoleViewer, ScopeCalendarRead), c.GetCalendar)
+	api.GET("/items", c.authenticateMiddleware(RoleViewer, ScopeItemsRead), c.GetItems)
+	api.POST("/items/update", c.authenticateMiddleware(RoleEditor, ScopeItemsWrite), c.UpdateItems)
+	api.POST("/email", c.authenticateMiddleware(RoleEditor, ScopeEmailSend), c.SendEmail)
+	api.GET("/meals", c.authenticateMiddleware(RoleViewer, ScopeMealsRead), c.GetMeals)
+	api.POST("/meals/enable", c.authenticateMiddleware(RoleEditor, ScopeMealsWrite), c.EnableMeals)
+	api.GET("/aisles", c.authenticateMiddleware(RoleViewer, ScopeItemsRead), c.GetAisles)
+	api.GET("/emails", c.authenticateMiddleware(RoleViewer, ScopeEmailSend), c.GetEmails)
+	api.GET("/stores", c.authenticateMiddleware(RoleViewer, ScopeItemsRead), c.GetStores)
+	api.GET("/plan", c.authenticateMiddleware(RoleViewer, ScopeCalendarRead), c.GetMealPlan)
+	api.POST("/plan/pin", c.authenticateMiddleware(RoleEditor, ScopeCalendarWrite), c.PinMeal)
+	api.POST("/plan/swap", c.authenticateMiddleware(RoleEditor, ScopeCalendarWrite), c.SwapMeals)
+	api.POST("/plan/clear", c.authenticateMiddleware(RoleEditor, ScopeCalendarWrite), c.ClearMeal)
+	api.GET("/pantry", c.authenticateMiddleware(RoleViewer, ScopeItemsRead), c.GetPantry)
+	api.POST("/pantry/update", c.authenticateMiddleware(RoleEditor, ScopeItemsWrite), c.UpdatePantry)
+	api.GET("/history", c.authenticateMiddleware(RoleViewer, ScopeMealsRead), c.GetMealHistory)
+	api.POST("/history", c.authenticateMiddleware(RoleEditor, ScopeMealsWrite), c.AddMealHistory)
+	api.PUT("/history/:id", c.authenticateMiddleware(RoleEditor, ScopeMealsWrite), c.UpdateMealHistory)
+	api.DELETE("/history/:id", c.authenticateMiddleware(RoleEditor, ScopeMealsWrite), c.DeleteMealHistory)
+	api.GET("/ingredient-info", c.authenticateMiddleware(RoleViewer, ScopeMealsRead), c.GetIngredientInfo)
+	api.POST("/ingredient-info/update", c.authenticateMiddleware(RoleEditor, ScopeMealsWrite), c.UpdateIngredientInfo)
+	api.GET("/recipes/export", c.authenticateMiddleware(RoleViewer, ScopeMealsRead), c.ExportRecipes)
+	api.POST("/recipes/import", c.authenticateMiddleware(RoleEditor, ScopeMealsWrite), c.ImportRecipe)
+	api.POST("/recipes", c.authenticateMiddleware(RoleEditor, ScopeMealsWrite), c.CreateRecipe)
+	api.PUT("/recipes/:name", c.authenticateMiddleware(RoleEditor, ScopeMealsWrite), c.UpdateRecipe)
+	api.DELETE("/recipes/:name", c.authenticateMiddleware(RoleEditor, ScopeMealsWrite), c.DeleteRecipe)
+	api.GET("/users", c.authenticateMiddleware(RoleAdmin, ScopeSession), c.GetUsers)
+	api.POST("/users", c.authenticateMiddleware(RoleAdmin, ScopeSession), c.CreateUser)
+	api.PUT("/users/:username", c.authenticateMiddleware(RoleAdmin, ScopeSession), c.UpdateUser)
+	api.DELETE("/users/:username", c.authenticateMiddleware(RoleAdmin, ScopeSession), c.DeleteUser)
+	api.POST("/users/:username/revoke", c.authenticateMiddleware(RoleAdmin, ScopeSession), c.RevokeUserSessions)
+	api.POST("/sessions/revoke", c.authenticateMiddleware(RoleAdmin, ScopeSession), c.RevokeAllSessions)
+	api.GET("/audit", c.authenticateMiddleware(RoleAdmin, ScopeSession), c.GetAuditLog)
+	api.GET("/tokens", c.authenticateMiddleware(RoleViewer, ScopeSession), c.GetAPITokens)
+	api.POST("/tokens", c.authenticateMiddleware(RoleViewer, ScopeSession), c.CreateAPIToken)
+	api.DELETE("/tokens/:name", c.authenticateMiddleware(RoleViewer, ScopeSession), c.DeleteAPIToken)
 
 	err := router.Run()
 	if err != nil {
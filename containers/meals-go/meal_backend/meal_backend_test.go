@@ -0,0 +1,544 @@
+package meal_backend
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestRoleAllows(t *testing.T) {
+	tests := []struct {
+		role     Role
+		required Role
+		expected bool
+	}{
+		{RoleViewer, RoleViewer, true},
+		{RoleViewer, RoleEditor, false},
+		{RoleViewer, RoleAdmin, false},
+		{RoleEditor, RoleViewer, true},
+		{RoleEditor, RoleEditor, true},
+		{RoleEditor, RoleAdmin, false},
+		{RoleAdmin, RoleViewer, true},
+		{RoleAdmin, RoleEditor, true},
+		{RoleAdmin, RoleAdmin, true},
+		{"", RoleViewer, false},
+		{"owner", RoleViewer, false},
+	}
+	for _, tt := range tests {
+		if got := tt.role.Allows(tt.required); got != tt.expected {
+			t.Errorf("%q.Allows(%q): expected %v, got %v", tt.role, tt.required, tt.expected, got)
+		}
+	}
+}
+
+func TestValidateUsername(t *testing.T) {
+	tests := []struct {
+		username string
+		wantErr  bool
+	}{
+		{"alice", false},
+		{"alice smith", false},
+		{"", true},
+		{" alice", true},
+		{"alice\t", true},
+	}
+	for _, tt := range tests {
+		err := validateUsername(tt.username)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("validateUsername(%q): expected error %v, got %v", tt.username, tt.wantErr, err)
+		}
+	}
+}
+
+func TestValidatePassword(t *testing.T) {
+	tests := []struct {
+		password string
+		wantErr  bool
+	}{
+		{"", true},
+		{"short", true},
+		{"1234567", true},
+		{"12345678", false},
+		{"correct horse battery staple", false},
+	}
+	for _, tt := range tests {
+		err := validatePassword(tt.password)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("validatePassword(%q): expected error %v, got %v", tt.password, tt.wantErr, err)
+		}
+	}
+}
+
+func TestDummyPasswordHash(t *testing.T) {
+	// Checking against it must cost as much as checking a real password
+	cost, err := bcrypt.Cost([]byte(dummyPasswordHash))
+	if err != nil {
+		t.Fatalf("Expected a valid bcrypt hash, got %v", err)
+	}
+	if cost != bcrypt.DefaultCost {
+		t.Errorf("Expected cost %d, got %d", bcrypt.DefaultCost, cost)
+	}
+
+	user := User{PasswordHash: dummyPasswordHash}
+	for _, password := range []string{"", "temp", "password"} {
+		if user.CheckPassword(password) {
+			t.Errorf("Expected %q not to match the dummy hash", password)
+		}
+	}
+}
+
+func TestCheckRefreshTokenBackToBackRotations(t *testing.T) {
+	user := User{Username: "alice", SessionVersion: 2}
+	now := time.Now()
+	state := refreshTokenState{SessionVersion: 2, ExpiresAt: now.Add(REFRESH_EXPIRES_IN)}
+
+	if err := checkRefreshToken(state, user, now); err != nil {
+		t.Fatalf("First rotation: expected no error, got %v", err)
+	}
+	state.RotatedAt = &now
+
+	// A parallel request refreshing with the same token just after
+	if err := checkRefreshToken(state, user, now.Add(time.Second)); err != nil {
+		t.Errorf("Second rotation within grace: expected no error, got %v", err)
+	}
+
+	later := now.Add(REFRESH_REUSE_GRACE + time.Second)
+	if err := checkRefreshToken(state, user, later); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Errorf("Rotation after grace: expected %v, got %v", ErrRefreshTokenReused, err)
+	}
+}
+
+func TestCheckRefreshTokenInvalid(t *testing.T) {
+	user := User{Username: "alice", SessionVersion: 2}
+	now := time.Now()
+
+	tests := []struct {
+		name  string
+		state refreshTokenState
+	}{
+		{"revoked", refreshTokenState{SessionVersion: 1, ExpiresAt: now.Add(time.Hour)}},
+		{"expired", refreshTokenState{SessionVersion: 2, ExpiresAt: now.Add(-time.Second)}},
+	}
+	for _, tt := range tests {
+		if err := checkRefreshToken(tt.state, user, now); !errors.Is(err, ErrRefreshTokenInvalid) {
+			t.Errorf("%s: expected %v, got %v", tt.name, ErrRefreshTokenInvalid, err)
+		}
+	}
+}
+
+// newTestLoginRateLimiter returns a limiter whose clock is *now.
+func newTestLoginRateLimiter(now *time.Time) *loginRateLimiter {
+	l := newLoginRateLimiter()
+	l.now = func() time.Time { return *now }
+	return l
+}
+
+func TestLoginRateLimiterLockout(t *testing.T) {
+	now := time.Now()
+	l := newTestLoginRateLimiter(&now)
+
+	for i := 0; i < LOGIN_FREE_ATTEMPTS; i++ {
+		if wait := l.attempt("1.2.3.4", "alice"); wait != 0 {
+			t.Fatalf("Attempt %d: expected no lockout, got %v", i+1, wait)
+		}
+	}
+	// The attempt after the free ones is still let through, then locks out
+	if wait := l.attempt("1.2.3.4", "alice"); wait != 0 {
+		t.Fatalf("Attempt %d: expected no lockout, got %v", LOGIN_FREE_ATTEMPTS+1, wait)
+	}
+	if wait := l.attempt("1.2.3.4", "alice"); wait != LOGIN_BASE_LOCKOUT {
+		t.Errorf("Expected lockout of %v, got %v", LOGIN_BASE_LOCKOUT, wait)
+	}
+
+	// The account is locked from other IPs, and the IP for other accounts
+	if wait := l.attempt("5.6.7.8", "alice"); wait != LOGIN_BASE_LOCKOUT {
+		t.Errorf("Other IP: expected lockout of %v, got %v", LOGIN_BASE_LOCKOUT, wait)
+	}
+	if wait := l.attempt("1.2.3.4", "bob"); wait != LOGIN_BASE_LOCKOUT {
+		t.Errorf("Other account: expected lockout of %v, got %v", LOGIN_BASE_LOCKOUT, wait)
+	}
+}
+
+func TestLoginRateLimiterBackoff(t *testing.T) {
+	now := time.Now()
+	l := newTestLoginRateLimiter(&now)
+
+	for i := 0; i <= LOGIN_FREE_ATTEMPTS; i++ {
+		l.attempt("1.2.3.4", "alice")
+	}
+
+	expected := LOGIN_BASE_LOCKOUT
+	for i := 0; i < 10; i++ {
+		if wait := l.attempt("1.2.3.4", "alice"); wait != expected {
+			t.Fatalf("Lockout %d: expected %v, got %v", i+1, expected, wait)
+		}
+		now = now.Add(expected)
+		if wait := l.attempt("1.2.3.4", "alice"); wait != 0 {
+			t.Fatalf("Lockout %d: expected attempt after it, got %v", i+1, wait)
+		}
+		expected = min(expected*2, LOGIN_MAX_LOCKOUT)
+	}
+}
+
+func TestLoginRateLimiterWindowExpiry(t *testing.T) {
+	now := time.Now()
+	l := newTestLoginRateLimiter(&now)
+
+	for i := 0; i < LOGIN_FREE_ATTEMPTS+1; i++ {
+		l.attempt("1.2.3.4", "alice")
+	}
+	now = now.Add(LOGIN_MAX_LOCKOUT)
+	l.attempt("1.2.3.4", "alice")
+
+	now = now.Add(LOGIN_FAILURE_WINDOW + time.Second)
+	if len(l.failures) != 2 {
+		t.Fatalf("Expected 2 tracked entries, got %d", len(l.failures))
+	}
+	if wait := l.attempt("1.2.3.4", "alice"); wait != 0 {
+		t.Errorf("Expected failures to be forgotten, got lockout of %v", wait)
+	}
+	if count := l.failures[userKey("alice")].count; count != 1 {
+		t.Errorf("Expected count to restart at 1, got %d", count)
+	}
+}
+
+func TestLoginRateLimiterSucceed(t *testing.T) {
+	now := time.Now()
+	l := newTestLoginRateLimiter(&now)
+
+	for i := 0; i < LOGIN_FREE_ATTEMPTS; i++ {
+		l.attempt("1.2.3.4", "alice")
+	}
+	l.attempt("1.2.3.4", "alice")
+	l.succeed("1.2.3.4", "alice")
+
+	if _, ok := l.failures[userKey("alice")]; ok {
+		t.Error("Expected the account's failures to be forgotten")
+	}
+	// The successful attempt is given back, but the IP's failures are kept
+	if count := l.failures[ipKey("1.2.3.4")].count; count != LOGIN_FREE_ATTEMPTS {
+		t.Errorf("Expected IP count %d, got %d", LOGIN_FREE_ATTEMPTS, count)
+	}
+	if wait := l.attempt("1.2.3.4", "bob"); wait != 0 {
+		t.Errorf("Expected IP not to be locked, got %v", wait)
+	}
+	if wait := l.attempt("1.2.3.4", "bob"); wait != LOGIN_BASE_LOCKOUT {
+		t.Errorf("Expected IP lockout of %v, got %v", LOGIN_BASE_LOCKOUT, wait)
+	}
+}
+
+func TestLoginRateLimiterRelease(t *testing.T) {
+	now := time.Now()
+	l := newTestLoginRateLimiter(&now)
+
+	l.attempt("1.2.3.4", "alice")
+	l.release("1.2.3.4", "alice")
+
+	if len(l.failures) != 0 {
+		t.Errorf("Expected nothing tracked, got %d entries", len(l.failures))
+	}
+}
+
+func TestLoginRateLimiterReportLockout(t *testing.T) {
+	now := time.Now()
+	l := newTestLoginRateLimiter(&now)
+
+	for i := 0; i <= LOGIN_FREE_ATTEMPTS; i++ {
+		l.attempt("1.2.3.4", "alice")
+	}
+	if !l.reportLockout("1.2.3.4", "alice") {
+		t.Errorf("Expected the lockout to be reported")
+	}
+	for i := 0; i < 3; i++ {
+		l.attempt("1.2.3.4", "alice")
+		if l.reportLockout("1.2.3.4", "alice") {
+			t.Errorf("Rejected attempt %d: expected the lockout to be reported only once", i+1)
+		}
+	}
+	if l.reportLockout("5.6.7.8", "alice") {
+		t.Errorf("Expected the account's lockout not to be reported again from another IP")
+	}
+
+	// Failing again once it's over starts a new lockout
+	now = now.Add(LOGIN_BASE_LOCKOUT)
+	l.attempt("1.2.3.4", "alice")
+	if !l.reportLockout("1.2.3.4", "alice") {
+		t.Errorf("Expected the next lockout to be reported")
+	}
+}
+
+func TestLoginRateLimiterConcurrentAttempts(t *testing.T) {
+	now := time.Now()
+	l := newTestLoginRateLimiter(&now)
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		allowed int
+	)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if l.attempt("1.2.3.4", "alice") == 0 {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if allowed != LOGIN_FREE_ATTEMPTS+1 {
+		t.Errorf("Expected %d attempts allowed, got %d", LOGIN_FREE_ATTEMPTS+1, allowed)
+	}
+}
+
+func TestLoginRateLimiterEviction(t *testing.T) {
+	now := time.Now()
+	l := newTestLoginRateLimiter(&now)
+	l.maxTracked = 100
+
+	// A locked out IP survives guessing many unknown accounts from elsewhere
+	for i := 0; i <= LOGIN_FREE_ATTEMPTS; i++ {
+		l.attempt("1.2.3.4", "alice")
+	}
+	for i := 0; i < 1000; i++ {
+		now = now.Add(time.Millisecond)
+		l.attempt(fmt.Sprintf("10.0.%d.%d", i/256, i%256), fmt.Sprintf("user%d", i))
+		if len(l.failures) > l.maxTracked {
+			t.Fatalf("Expected at most %d tracked entries, got %d", l.maxTracked, len(l.failures))
+		}
+	}
+
+	if wait := l.attempt("1.2.3.4", "alice"); wait == 0 {
+		t.Error("Expected locked out IP to stay locked out")
+	}
+	if _, ok := l.failures[userKey("user999")]; !ok {
+		t.Error("Expected the newest entry to be kept")
+	}
+	if _, ok := l.failures[userKey("user0")]; ok {
+		t.Error("Expected the oldest entry to be evicted")
+	}
+}
+
+func TestParseSigningKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		keys     string
+		expected []SigningKey
+		wantErr  bool
+	}{
+		{"single", "k1:secret", []SigningKey{{ID: "k1", Key: []byte("secret")}}, false},
+		{"rotated", "k2:new, k1:old", []SigningKey{{ID: "k2", Key: []byte("new")}, {ID: "k1", Key: []byte("old")}}, false},
+		{"colon in key", "k1:a:b", []SigningKey{{ID: "k1", Key: []byte("a:b")}}, false},
+		{"empty", "", nil, true},
+		{"no id", "secret", nil, true},
+		{"empty id", ":secret", nil, true},
+		{"empty key", "k1:", nil, true},
+		{"trailing comma", "k1:secret,", nil, true},
+		{"duplicate id", "k1:a,k1:b", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSigningKeys(tt.keys)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: expected error %v, got %v", tt.name, tt.wantErr, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.expected, got)
+		}
+	}
+}
+
+// signTestToken signs claims with method and key, setting the kid header if not empty.
+func signTestToken(t *testing.T, method jwt.SigningMethod, key interface{}, kid string, claims jwt.Claims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	ss, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+	return ss
+}
+
+func TestParseToken(t *testing.T) {
+	primary := SigningKey{ID: "k2", Key: []byte("new-secret")}
+	rotated := SigningKey{ID: "k1", Key: []byte("old-secret")}
+	c := Config{JWTSigningKeys: []SigningKey{primary, rotated}}
+
+	claimsWith := func(modify func(*tokenClaims)) *tokenClaims {
+		claims := &tokenClaims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(EXPIRES_IN)),
+				IssuedAt:  jwt.NewNumericDate(time.Now()),
+				Issuer:    TOKEN_ISSUER,
+				Subject:   "alice",
+				Audience:  jwt.ClaimStrings{TOKEN_AUDIENCE},
+			},
+			SessionVersion: 4,
+		}
+		if modify != nil {
+			modify(claims)
+		}
+		return claims
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{"primary key", signTestToken(t, jwt.SigningMethodHS256, primary.Key, primary.ID, claimsWith(nil)), false},
+		{"rotated key", signTestToken(t, jwt.SigningMethodHS256, rotated.Key, rotated.ID, claimsWith(nil)), false},
+		{"alg none", signTestToken(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, primary.ID, claimsWith(nil)), true},
+		{"alg HS384", signTestToken(t, jwt.SigningMethodHS384, primary.Key, primary.ID, claimsWith(nil)), true},
+		{"wrong key for kid", signTestToken(t, jwt.SigningMethodHS256, rotated.Key, primary.ID, claimsWith(nil)), true},
+		{"missing kid", signTestToken(t, jwt.SigningMethodHS256, primary.Key, "", claimsWith(nil)), true},
+		{"unknown kid", signTestToken(t, jwt.SigningMethodHS256, primary.Key, "k3", claimsWith(nil)), true},
+		{"wrong audience", signTestToken(t, jwt.SigningMethodHS256, primary.Key, primary.ID, claimsWith(func(c *tokenClaims) {
+			c.Audience = jwt.ClaimStrings{"other-backend"}
+		})), true},
+		{"missing audience", signTestToken(t, jwt.SigningMethodHS256, primary.Key, primary.ID, claimsWith(func(c *tokenClaims) {
+			c.Audience = nil
+		})), true},
+		{"wrong issuer", signTestToken(t, jwt.SigningMethodHS256, primary.Key, primary.ID, claimsWith(func(c *tokenClaims) {
+			c.Issuer = "someone-else"
+		})), true},
+		{"expired", signTestToken(t, jwt.SigningMethodHS256, primary.Key, primary.ID, claimsWith(func(c *tokenClaims) {
+			c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+		})), true},
+		{"missing expiry", signTestToken(t, jwt.SigningMethodHS256, primary.Key, primary.ID, claimsWith(func(c *tokenClaims) {
+			c.ExpiresAt = nil
+		})), true},
+	}
+	for _, tt := range tests {
+		claims, err := c.parseToken(tt.token)
+		if tt.wantErr {
+			if !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("%s: expected %v, got %v", tt.name, ErrInvalidToken, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: expected no error, got %v", tt.name, err)
+			continue
+		}
+		if claims.Subject != "alice" || claims.SessionVersion != 4 {
+			t.Errorf("%s: expected alice at session version 4, got %s at %d", tt.name, claims.Subject, claims.SessionVersion)
+		}
+	}
+}
+
+func TestCreateTokenParses(t *testing.T) {
+	c := Config{JWTSigningKeys: []SigningKey{{ID: "k1", Key: []byte("secret")}}}
+	token, err := createToken(c.JWTSigningKeys[0], User{Username: "alice", SessionVersion: 2})
+	if err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+
+	claims, err := c.parseToken(token)
+	if err != nil {
+		t.Fatalf("Expected created token to parse, got %v", err)
+	}
+	if claims.Subject != "alice" || claims.SessionVersion != 2 {
+		t.Errorf("Expected alice at session version 2, got %s at %d", claims.Subject, claims.SessionVersion)
+	}
+}
+
+func TestAPITokenAllows(t *testing.T) {
+	token := APIToken{Name: "script", Scopes: []Scope{ScopeCalendarRead, ScopeEmailSend}}
+
+	tests := []struct {
+		scope    Scope
+		expected bool
+	}{
+		{ScopeCalendarRead, true},
+		{ScopeEmailSend, true},
+		{ScopeCalendarWrite, false},
+		{ScopeMealsRead, false},
+		{ScopeSession, false},
+	}
+	for _, tt := range tests {
+		if got := token.Allows(tt.scope); got != tt.expected {
+			t.Errorf("Allows(%q): expected %v, got %v", tt.scope, tt.expected, got)
+		}
+	}
+}
+
+func TestCheckAPIToken(t *testing.T) {
+	user := User{Username: "alice", Role: RoleEditor, SessionVersion: 3}
+
+	tests := []struct {
+		name     string
+		token    APIToken
+		scope    Scope
+		expected error
+	}{
+		{"allowed", APIToken{Scopes: []Scope{ScopeMealsRead}, SessionVersion: 3}, ScopeMealsRead, nil},
+		{"missing scope", APIToken{Scopes: []Scope{ScopeMealsRead}, SessionVersion: 3}, ScopeMealsWrite, ErrAPITokenMissingScope},
+		{"revoked", APIToken{Scopes: []Scope{ScopeMealsRead}, SessionVersion: 2}, ScopeMealsRead, ErrAPITokenRevoked},
+		{"revoked and missing scope", APIToken{Scopes: []Scope{ScopeMealsRead}, SessionVersion: 2}, ScopeMealsWrite, ErrAPITokenRevoked},
+	}
+	for _, tt := range tests {
+		err := checkAPIToken(tt.token, user, tt.scope)
+		if tt.expected == nil && err != nil {
+			t.Errorf("%s: expected no error, got %v", tt.name, err)
+		}
+		if tt.expected != nil && !errors.Is(err, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.expected, err)
+		}
+	}
+}
+
+func TestValidateAPIToken(t *testing.T) {
+	tests := []struct {
+		name    string
+		token   APIToken
+		role    Role
+		wantErr bool
+	}{
+		{"valid", APIToken{Name: "script", Scopes: []Scope{ScopeCalendarRead}}, RoleViewer, false},
+		{"valid write", APIToken{Name: "script", Scopes: []Scope{ScopeMealsWrite, ScopeEmailSend}}, RoleEditor, false},
+		{"empty name", APIToken{Name: " ", Scopes: []Scope{ScopeCalendarRead}}, RoleViewer, true},
+		{"no scopes", APIToken{Name: "script"}, RoleAdmin, true},
+		{"invalid scope", APIToken{Name: "script", Scopes: []Scope{"calendar:delete"}}, RoleAdmin, true},
+		{"session scope", APIToken{Name: "script", Scopes: []Scope{ScopeSession}}, RoleAdmin, true},
+		{"scope above role", APIToken{Name: "script", Scopes: []Scope{ScopeCalendarWrite}}, RoleViewer, true},
+	}
+	for _, tt := range tests {
+		err := validateAPIToken(tt.token, tt.role)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: expected error %v, got %v", tt.name, tt.wantErr, err)
+		}
+	}
+}
+
+func TestAuthenticateMiddlewareRejectsAPITokenOnSessionRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/tokens", Config{}.authenticateMiddleware(RoleViewer, ScopeSession), func(ctx *gin.Context) {
+		ctx.Status(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/tokens", nil)
+	req.Header.Set("Authorization", "Bearer "+API_TOKEN_PREFIX+"secret")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, w.Code)
+	}
+}
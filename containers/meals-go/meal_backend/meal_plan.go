@@ -0,0 +1,276 @@
+package meal_backend
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/andrewpollack/pi-infrastructure/containers/meals-go/calendar"
+	"github.com/andrewpollack/pi-infrastructure/containers/meals-go/meal_collection"
+
+	"github.com/gin-gonic/gin"
+)
+
+// parseDate parses a date in YYYY-MM-DD format.
+func parseDate(date string) (time.Time, error) {
+	t, err := time.Parse(time.DateOnly, date)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date '%s', expected YYYY-MM-DD", date)
+	}
+	return t, nil
+}
+
+// SNAPSHOT_INTERVAL is how often snapshotMeals runs. Each day only needs snapshotting
+// once while it's served, so this just needs to be well under a day.
+const SNAPSHOT_INTERVAL = time.Hour
+
+// snapshotMeals snapshots the meals of the current month up to today every
+// SNAPSHOT_INTERVAL, so each day is frozen to its meal once it's served. Reading the
+// calendar never writes, so this is what keeps elapsed days stable.
+func (c Config) snapshotMeals() {
+	for {
+		if err := c.snapshotServedDays(); err != nil {
+			log.Println("Error in snapshotMeals:", err)
+		}
+		time.Sleep(SNAPSHOT_INTERVAL)
+	}
+}
+
+// snapshotServedDays snapshots the meals generated for every date of the current month
+// up to and including today.
+func (c Config) snapshotServedDays() error {
+	now := time.Now()
+	collection, err := meal_collection.ReadMealCollectionFromDB(c.PostgresURL, now.Unix())
+	if err != nil {
+		return fmt.Errorf("failed to read meal collection: %v", err)
+	}
+
+	dates := calendar.NewCalendar(now.Year(), now.Month()).Dates()[:now.Day()]
+	schedule, err := meal_collection.LoadScheduleFromDB(c.PostgresURL, dates, c.GenerateOptions)
+	if err != nil {
+		return fmt.Errorf("failed to load schedule: %v", err)
+	}
+	_, err = meal_collection.SnapshotScheduleInDB(c.PostgresURL, collection, dates, c.GenerateOptions, schedule)
+	return err
+}
+
+// PlannedMealResponse represents a meal pinned to a date.
+type PlannedMealResponse struct {
+	Date     string
+	Meal     string
+	Servings int
+}
+
+// GetMealPlan handles the GET /plan endpoint, returning pinned meals between the
+// start and end query parameters.
+func (c Config) GetMealPlan(ctx *gin.Context) {
+	start, err := parseDate(ctx.Query("start"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	end, err := parseDate(ctx.Query("end"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	plan, err := meal_collection.ReadMealPlanFromDB(c.PostgresURL, start, end)
+	if err != nil {
+		log.Println("Error in GetMealPlan while fetching meal plan:", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err,
+		})
+		return
+	}
+
+	planResponse := make([]PlannedMealResponse, 0, len(plan))
+	for _, planned := range plan {
+		planResponse = append(planResponse, PlannedMealResponse{
+			Date:     planned.Date.Format(time.DateOnly),
+			Meal:     planned.Meal,
+			Servings: planned.Servings,
+		})
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"plan": planResponse,
+	})
+}
+
+// PinMealRequest represents the pin request payload. Servings is optional and
+// overrides the household size for that date. Meal is optional when Servings is set,
+// overriding only the servings and leaving the date's meal as generated.
+type PinMealRequest struct {
+	Date     string `json:"date"`
+	Meal     string `json:"meal"`
+	Servings int    `json:"servings"`
+}
+
+// PinMeal handles the POST /plan/pin endpoint.
+func (c Config) PinMeal(ctx *gin.Context) {
+	var pinRequest PinMealRequest
+	if err := ctx.BindJSON(&pinRequest); err != nil {
+		log.Println("Error in PinMeal while binding JSON:", err)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err,
+		})
+		return
+	}
+
+	date, err := parseDate(pinRequest.Date)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if pinRequest.Servings < 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "servings cannot be negative"})
+		return
+	}
+	if pinRequest.Meal == "" && pinRequest.Servings == 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "either meal or servings must be set"})
+		return
+	}
+
+	if pinRequest.Meal != "" {
+		mealCollection, err := meal_collection.ReadMealCollectionFromDB(c.PostgresURL, time.Now().Unix())
+		if err != nil {
+			log.Println("Error in PinMeal while fetching meal collection:", err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"error": err,
+			})
+			return
+		}
+
+		if _, found := mealCollection.MapNameToMealWithTemplate(c.GenerateOptions.Template)[pinRequest.Meal]; !found {
+			errMsg := fmt.Sprintf("Meal not found: %s", pinRequest.Meal)
+			log.Println("Error in PinMeal:", errMsg)
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"error": errMsg,
+			})
+			return
+		}
+	}
+
+	err = meal_collection.PinMealsInDB(c.PostgresURL, []meal_collection.PlannedMeal{
+		{Date: date, Meal: pinRequest.Meal, Servings: pinRequest.Servings},
+	})
+	if err != nil {
+		log.Println("Error in PinMeal while pinning meal in DB:", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err,
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+	})
+}
+
+// SwapMealsRequest represents the swap request payload.
+type SwapMealsRequest struct {
+	First  string `json:"first"`
+	Second string `json:"second"`
+}
+
+// SwapMeals handles the POST /plan/swap endpoint. Both dates end up pinned to the
+// meal currently shown on the other date, whether it was generated or pinned. Serving
+// overrides stay with their date.
+func (c Config) SwapMeals(ctx *gin.Context) {
+	var swapRequest SwapMealsRequest
+	if err := ctx.BindJSON(&swapRequest); err != nil {
+		log.Println("Error in SwapMeals while binding JSON:", err)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err,
+		})
+		return
+	}
+
+	first, err := parseDate(swapRequest.First)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	second, err := parseDate(swapRequest.Second)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	mealCollection, err := meal_collection.ReadMealCollectionFromDB(c.PostgresURL, time.Now().Unix())
+	if err != nil {
+		log.Println("Error in SwapMeals while fetching meal collection:", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err,
+		})
+		return
+	}
+
+	dates := []time.Time{first, second}
+	schedule, err := meal_collection.LoadScheduleFromDB(c.PostgresURL, dates, c.GenerateOptions)
+	if err != nil {
+		log.Println("Error in SwapMeals while fetching schedule:", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err,
+		})
+		return
+	}
+
+	meals := mealCollection.MealsForDates(dates, c.GenerateOptions, schedule)
+	servings := meal_collection.ServingsForDates(schedule.Plan, dates, 0)
+	err = meal_collection.PinMealsInDB(c.PostgresURL, []meal_collection.PlannedMeal{
+		{Date: first, Meal: meals[1].BaseName(), Servings: servings[0]},
+		{Date: second, Meal: meals[0].BaseName(), Servings: servings[1]},
+	})
+	if err != nil {
+		log.Println("Error in SwapMeals while pinning meals in DB:", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err,
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+	})
+}
+
+// ClearMealRequest represents the clear request payload.
+type ClearMealRequest struct {
+	Date string `json:"date"`
+}
+
+// ClearMeal handles the POST /plan/clear endpoint, removing the date's pin. The date
+// returns to its generated meal, or with GenerateOptions.Stable, to its snapshotted meal
+// once it has one.
+func (c Config) ClearMeal(ctx *gin.Context) {
+	var clearRequest ClearMealRequest
+	if err := ctx.BindJSON(&clearRequest); err != nil {
+		log.Println("Error in ClearMeal while binding JSON:", err)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err,
+		})
+		return
+	}
+
+	date, err := parseDate(clearRequest.Date)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := meal_collection.ClearMealPlanInDB(c.PostgresURL, []time.Time{date}); err != nil {
+		log.Println("Error in ClearMeal while clearing meal plan in DB:", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err,
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+	})
+}
@@ -0,0 +1,63 @@
+package meal_backend
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/andrewpollack/pi-infrastructure/containers/meals-go/meal_collection"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetPantry handles the GET /pantry endpoint, returning every pantry item.
+func (c Config) GetPantry(ctx *gin.Context) {
+	pantry, err := meal_collection.ReadPantryFromDB(c.PostgresURL)
+	if err != nil {
+		log.Println("Error in GetPantry while fetching pantry:", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err,
+		})
+		return
+	}
+
+	if pantry == nil {
+		pantry = []meal_collection.PantryItem{}
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"pantry": pantry,
+	})
+}
+
+// UpdatePantry handles the POST /pantry/update endpoint, applying a list of
+// Add/Update/Delete actions.
+func (c Config) UpdatePantry(ctx *gin.Context) {
+	var pantryUpdate []meal_collection.FEPantryItem
+	if err := ctx.BindJSON(&pantryUpdate); err != nil {
+		log.Println("Error in UpdatePantry while binding JSON:", err)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err,
+		})
+		return
+	}
+
+	if err := meal_collection.ValidatePantryUpdates(pantryUpdate); err != nil {
+		log.Println("Error in UpdatePantry:", err)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if err := meal_collection.UpdatePantryInDB(c.PostgresURL, pantryUpdate); err != nil {
+		log.Println("Error in UpdatePantry while updating pantry in DB:", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err,
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+	})
+}
@@ -0,0 +1,212 @@
+package meal_backend
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// LOGIN_FREE_ATTEMPTS is how many failed logins are allowed before locking out
+	LOGIN_FREE_ATTEMPTS = 5
+	// LOGIN_BASE_LOCKOUT is the first lockout, which doubles with each further failure
+	LOGIN_BASE_LOCKOUT = 30 * time.Second
+	// LOGIN_MAX_LOCKOUT caps the lockout
+	LOGIN_MAX_LOCKOUT = time.Hour
+	// LOGIN_FAILURE_WINDOW is how long failures are remembered after the last one
+	LOGIN_FAILURE_WINDOW = 24 * time.Hour
+	// LOGIN_MAX_TRACKED is how many IPs and accounts are tracked at most
+	LOGIN_MAX_TRACKED = 10000
+)
+
+// loginFailures tracks the failed logins of one IP or account.
+type loginFailures struct {
+	count       int
+	last        time.Time
+	lockedUntil time.Time
+	// reported is the lockedUntil of the last lockout reported, see reportLockout
+	reported time.Time
+}
+
+// lock sets the lockout for the current count of failures, if past LOGIN_FREE_ATTEMPTS.
+func (f *loginFailures) lock(now time.Time) {
+	over := f.count - LOGIN_FREE_ATTEMPTS
+	if over <= 0 {
+		f.lockedUntil = time.Time{}
+		return
+	}
+
+	lockout := LOGIN_MAX_LOCKOUT
+	if over <= 20 {
+		lockout = min(LOGIN_BASE_LOCKOUT<<(over-1), LOGIN_MAX_LOCKOUT)
+	}
+	f.lockedUntil = now.Add(lockout)
+}
+
+// loginRateLimiter locks out IPs and accounts with too many failed logins. Locking
+// both means spreading guesses over many IPs still locks the account, and guessing
+// many accounts from one IP still locks the IP.
+//
+// Each attempt is counted as a failure before the password is checked, and given back
+// when it succeeds, so a burst of parallel guesses can't all get in before the first
+// failure is recorded.
+type loginRateLimiter struct {
+	mu         sync.Mutex
+	failures   map[string]*loginFailures
+	maxTracked int
+	now        func() time.Time
+}
+
+func newLoginRateLimiter() *loginRateLimiter {
+	return &loginRateLimiter{
+		failures:   map[string]*loginFailures{},
+		maxTracked: LOGIN_MAX_TRACKED,
+		now:        time.Now,
+	}
+}
+
+// loginLimiter is shared by every request, and forgotten on restart.
+var loginLimiter = newLoginRateLimiter()
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+func userKey(username string) string {
+	return "user:" + username
+}
+
+// current returns the failures of key, forgetting them once LOGIN_FAILURE_WINDOW has
+// passed since the last one.
+func (l *loginRateLimiter) current(key string, now time.Time) *loginFailures {
+	f, ok := l.failures[key]
+	if ok && now.Sub(f.last) > LOGIN_FAILURE_WINDOW {
+		delete(l.failures, key)
+		return nil
+	}
+	return f
+}
+
+// attempt reserves a login attempt from ip to username. When either is locked out it
+// returns how long for, without reserving. Otherwise the attempt counts as a failure
+// until succeed or release is called.
+func (l *loginRateLimiter) attempt(ip string, username string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	keys := []string{ipKey(ip), userKey(username)}
+
+	var wait time.Duration
+	for _, key := range keys {
+		if f := l.current(key, now); f != nil {
+			wait = max(wait, f.lockedUntil.Sub(now))
+		}
+	}
+	if wait > 0 {
+		return wait
+	}
+
+	for _, key := range keys {
+		f := l.current(key, now)
+		if f == nil {
+			f = &loginFailures{}
+			l.failures[key] = f
+		}
+		f.count++
+		f.last = now
+		f.lock(now)
+	}
+
+	l.evict(now)
+	return 0
+}
+
+// reportLockout reports whether ip or username is locked out by a lockout that hasn't
+// been reported yet, and marks it reported. This way a lockout is audited once, rather
+// than on every attempt rejected during it.
+func (l *loginRateLimiter) reportLockout(ip string, username string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	report := false
+	for _, key := range []string{ipKey(ip), userKey(username)} {
+		f := l.current(key, now)
+		if f == nil || !f.lockedUntil.After(now) || f.reported.Equal(f.lockedUntil) {
+			continue
+		}
+		f.reported = f.lockedUntil
+		report = true
+	}
+	return report
+}
+
+// unreserve gives back an attempt reserved for key.
+func (l *loginRateLimiter) unreserve(key string, now time.Time) {
+	f := l.current(key, now)
+	if f == nil {
+		return
+	}
+	f.count--
+	if f.count <= 0 {
+		delete(l.failures, key)
+	} else if f.count <= LOGIN_FREE_ATTEMPTS {
+		f.lockedUntil = time.Time{}
+	}
+}
+
+// release gives back the attempt reserved for a login that couldn't be checked, e.g.
+// because the database was down.
+func (l *loginRateLimiter) release(ip string, username string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.unreserve(ipKey(ip), now)
+	l.unreserve(userKey(username), now)
+}
+
+// succeed gives back the attempt reserved for a login that succeeded, and forgets the
+// account's failures. The IP's earlier failures are kept, so logging in to one account
+// can't be used to keep guessing others.
+func (l *loginRateLimiter) succeed(ip string, username string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.unreserve(ipKey(ip), l.now())
+	delete(l.failures, userKey(username))
+}
+
+// evict keeps the number of tracked IPs and accounts to maxTracked, so guessing random
+// usernames can't grow it without bound. Stale entries go first, then the ones that
+// aren't locked out, oldest first.
+func (l *loginRateLimiter) evict(now time.Time) {
+	if len(l.failures) <= l.maxTracked {
+		return
+	}
+
+	keys := make([]string, 0, len(l.failures))
+	for key := range l.failures {
+		if l.current(key, now) != nil {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) <= l.maxTracked {
+		return
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := l.failures[keys[i]], l.failures[keys[j]]
+		aLocked, bLocked := a.lockedUntil.After(now), b.lockedUntil.After(now)
+		if aLocked != bLocked {
+			return !aLocked
+		}
+		return a.last.Before(b.last)
+	})
+
+	// Evict a tenth beyond the limit, so this doesn't sort on every attempt
+	for _, key := range keys[:len(keys)-l.maxTracked*9/10] {
+		delete(l.failures, key)
+	}
+}
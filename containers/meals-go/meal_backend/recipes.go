@@ -0,0 +1,178 @@
+package meal_backend
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/andrewpollack/pi-infrastructure/containers/meals-go/meal_collection"
+
+	"github.com/gin-gonic/gin"
+)
+
+// recipeErrorStatus maps an error from writing a recipe to its HTTP status.
+func recipeErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, meal_collection.ErrRecipeExists):
+		return http.StatusConflict
+	case errors.Is(err, meal_collection.ErrRecipeNotFound):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// bindRecipe binds and validates the recipe in the request body, writing the error
+// response and returning false when it isn't valid.
+func (c Config) bindRecipe(ctx *gin.Context, handler string) (meal_collection.Meal, bool) {
+	var recipe meal_collection.Meal
+	if err := ctx.BindJSON(&recipe); err != nil {
+		log.Println("Error in "+handler+" while binding JSON:", err)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err,
+		})
+		return recipe, false
+	}
+
+	if err := recipe.ParseRawIngredients(); err != nil {
+		log.Println("Error in "+handler+":", err)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return recipe, false
+	}
+
+	catalog, err := meal_collection.ReadIngredientCatalogFromDB(c.PostgresURL)
+	if err != nil {
+		log.Println("Error in "+handler+" while fetching ingredient catalog:", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err,
+		})
+		return recipe, false
+	}
+
+	if err := meal_collection.ValidateRecipe(recipe, catalog); err != nil {
+		log.Println("Error in "+handler+":", err)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return recipe, false
+	}
+
+	return recipe, true
+}
+
+// ExportRecipes handles the GET /recipes/export endpoint, returning the recipes table as
+// a recipe file.
+func (c Config) ExportRecipes(ctx *gin.Context) {
+	data, err := meal_collection.ExportRecipesFromDB(c.PostgresURL)
+	if err != nil {
+		log.Println("Error in ExportRecipes while exporting recipes:", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	ctx.Header("Content-Disposition", `attachment; filename="recipes.json"`)
+	ctx.Data(http.StatusOK, "application/json", data)
+}
+
+// ImportRecipe handles the POST /recipes/import endpoint. The body is a schema.org Recipe,
+// as JSON-LD or a saved HTML page, and the parsed recipe is returned for review without
+// being created.
+func (c Config) ImportRecipe(ctx *gin.Context) {
+	data, err := ctx.GetRawData()
+	if err != nil {
+		log.Println("Error in ImportRecipe while reading body:", err)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	mealCollection, err := meal_collection.ReadMealCollectionFromDB(c.PostgresURL, time.Now().Unix())
+	if err != nil {
+		log.Println("Error in ImportRecipe while fetching meal collection:", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err,
+		})
+		return
+	}
+
+	catalog, err := meal_collection.ReadIngredientCatalogFromDB(c.PostgresURL)
+	if err != nil {
+		log.Println("Error in ImportRecipe while fetching ingredient catalog:", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err,
+		})
+		return
+	}
+
+	imported, err := meal_collection.ImportRecipe(data, mealCollection, catalog)
+	if err != nil {
+		log.Println("Error in ImportRecipe:", err)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, imported)
+}
+
+// CreateRecipe handles the POST /recipes endpoint.
+func (c Config) CreateRecipe(ctx *gin.Context) {
+	recipe, ok := c.bindRecipe(ctx, "CreateRecipe")
+	if !ok {
+		return
+	}
+
+	if err := meal_collection.CreateRecipeInDB(c.PostgresURL, recipe); err != nil {
+		log.Println("Error in CreateRecipe while creating recipe in DB:", err)
+		ctx.JSON(recipeErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+	})
+}
+
+// UpdateRecipe handles the PUT /recipes/:name endpoint, replacing the named recipe.
+func (c Config) UpdateRecipe(ctx *gin.Context) {
+	recipe, ok := c.bindRecipe(ctx, "UpdateRecipe")
+	if !ok {
+		return
+	}
+
+	if err := meal_collection.UpdateRecipeInDB(c.PostgresURL, ctx.Param("name"), recipe); err != nil {
+		log.Println("Error in UpdateRecipe while updating recipe in DB:", err)
+		ctx.JSON(recipeErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+	})
+}
+
+// DeleteRecipe handles the DELETE /recipes/:name endpoint.
+func (c Config) DeleteRecipe(ctx *gin.Context) {
+	if err := meal_collection.DeleteRecipeFromDB(c.PostgresURL, ctx.Param("name")); err != nil {
+		log.Println("Error in DeleteRecipe while deleting recipe from DB:", err)
+		ctx.JSON(recipeErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+	})
+}
@@ -0,0 +1,380 @@
+package meal_backend
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// REFRESH_EXPIRES_IN is how long a session lasts without being refreshed.
+const REFRESH_EXPIRES_IN = 730 * time.Hour // 1 month
+
+// REFRESH_REUSE_GRACE is how long after a refresh token is rotated it can still be used.
+// A page's parallel requests all refresh with the same token when the access token has
+// expired, so only reuse after this is treated as a leak.
+const REFRESH_REUSE_GRACE = 30 * time.Second
+
+// REFRESH_COOKIE holds the refresh token.
+const REFRESH_COOKIE = "refresh_token"
+
+var (
+	ErrRefreshTokenInvalid = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token was already used")
+)
+
+// newSecretToken returns a random token starting with prefix, and the hash it's stored
+// under.
+func newSecretToken(prefix string) (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", fmt.Errorf("failed to generate token: %v", err)
+	}
+	token := prefix + base64.RawURLEncoding.EncodeToString(b)
+	return token, hashToken(token), nil
+}
+
+// hashToken hashes a refresh or API token, so a leaked table can't be used to log in.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// refreshTokenState is what's stored about a refresh token when it's used.
+type refreshTokenState struct {
+	SessionVersion int
+	ExpiresAt      time.Time
+	// RotatedAt is when the token was first exchanged, or nil if it hasn't been
+	RotatedAt *time.Time
+}
+
+// checkRefreshToken decides whether a refresh token of user can be rotated at now. It
+// returns ErrRefreshTokenReused once the token was rotated more than
+// REFRESH_REUSE_GRACE ago.
+func checkRefreshToken(state refreshTokenState, user User, now time.Time) error {
+	if state.SessionVersion != user.SessionVersion || now.After(state.ExpiresAt) {
+		return ErrRefreshTokenInvalid
+	}
+	if state.RotatedAt != nil && now.Sub(*state.RotatedAt) > REFRESH_REUSE_GRACE {
+		return ErrRefreshTokenReused
+	}
+	return nil
+}
+
+// insertRefreshToken stores a refresh token for user, clearing out expired ones.
+func insertRefreshToken(tx pgx.Tx, user User, tokenHash string) error {
+	_, err := tx.Exec(context.Background(), `
+		DELETE FROM refresh_tokens
+		WHERE expires_at < now()
+	`)
+	if err != nil {
+		return fmt.Errorf("query failed: %v", err)
+	}
+
+	_, err = tx.Exec(context.Background(), `
+		INSERT INTO refresh_tokens (username, token_hash, session_version, expires_at)
+		VALUES ($1, $2, $3, $4)
+	`, user.Username, tokenHash, user.SessionVersion, time.Now().Add(REFRESH_EXPIRES_IN))
+	if err != nil {
+		return fmt.Errorf("query failed: %v", err)
+	}
+
+	return nil
+}
+
+// AddRefreshTokenInDB stores the refresh token of a new session for user.
+func AddRefreshTokenInDB(postgresURL string, user User, tokenHash string) error {
+	if postgresURL == "" {
+		return fmt.Errorf("POSTGRES_URL is not set")
+	}
+
+	conn, err := pgx.Connect(context.Background(), postgresURL)
+	if err != nil {
+		return fmt.Errorf("unable to connect to database: %v", err)
+	}
+	defer func() {
+		if err := conn.Close(context.Background()); err != nil {
+			fmt.Printf("error closing connection: %v\n", err)
+		}
+	}()
+
+	tx, err := conn.Begin(context.Background())
+	if err != nil {
+		return fmt.Errorf("unable to begin transaction: %v", err)
+	}
+	defer func() {
+		_ = tx.Rollback(context.Background())
+	}()
+
+	if err := insertRefreshToken(tx, user, tokenHash); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(context.Background()); err != nil {
+		return fmt.Errorf("unable to commit transaction: %v", err)
+	}
+
+	return nil
+}
+
+// RotateRefreshTokenInDB exchanges the refresh token hashed as oldHash for the one
+// hashed as newHash, returning the user the session belongs to. Each refresh token can
+// only be used once, besides within REFRESH_REUSE_GRACE of its first use: using one
+// again later means it leaked, so every session of its user is revoked and
+// ErrRefreshTokenReused is returned.
+func RotateRefreshTokenInDB(postgresURL string, oldHash string, newHash string) (User, error) {
+	if postgresURL == "" {
+		return User{}, fmt.Errorf("POSTGRES_URL is not set")
+	}
+
+	conn, err := pgx.Connect(context.Background(), postgresURL)
+	if err != nil {
+		return User{}, fmt.Errorf("unable to connect to database: %v", err)
+	}
+	defer func() {
+		if err := conn.Close(context.Background()); err != nil {
+			fmt.Printf("error closing connection: %v\n", err)
+		}
+	}()
+
+	tx, err := conn.Begin(context.Background())
+	if err != nil {
+		return User{}, fmt.Errorf("unable to begin transaction: %v", err)
+	}
+	defer func() {
+		_ = tx.Rollback(context.Background())
+	}()
+
+	var (
+		user  User
+		state refreshTokenState
+	)
+	err = tx.QueryRow(context.Background(), `
+		SELECT u.username, u.role, u.password_hash, u.session_version,
+		       t.session_version, t.expires_at, t.rotated_at
+		FROM refresh_tokens t
+		JOIN users u ON u.username = t.username
+		WHERE t.token_hash = $1
+		FOR UPDATE OF t
+	`, oldHash).Scan(&user.Username, &user.Role, &user.PasswordHash, &user.SessionVersion,
+		&state.SessionVersion, &state.ExpiresAt, &state.RotatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return User{}, ErrRefreshTokenInvalid
+	}
+	if err != nil {
+		return User{}, fmt.Errorf("query failed: %v", err)
+	}
+
+	err = checkRefreshToken(state, user, time.Now())
+	if errors.Is(err, ErrRefreshTokenInvalid) {
+		return User{}, err
+	}
+	if errors.Is(err, ErrRefreshTokenReused) {
+		_, err = tx.Exec(context.Background(), `
+			UPDATE users
+			SET session_version = session_version + 1,
+			    date_modified = now()
+			WHERE username = $1
+		`, user.Username)
+		if err != nil {
+			return User{}, fmt.Errorf("query failed: %v", err)
+		}
+		if err := tx.Commit(context.Background()); err != nil {
+			return User{}, fmt.Errorf("unable to commit transaction: %v", err)
+		}
+		return User{}, fmt.Errorf("%w: revoked sessions of %s", ErrRefreshTokenReused, user.Username)
+	}
+
+	_, err = tx.Exec(context.Background(), `
+		UPDATE refresh_tokens
+		SET rotated_at = COALESCE(rotated_at, now())
+		WHERE token_hash = $1
+	`, oldHash)
+	if err != nil {
+		return User{}, fmt.Errorf("query failed: %v", err)
+	}
+
+	if err := insertRefreshToken(tx, user, newHash); err != nil {
+		return User{}, err
+	}
+
+	if err := tx.Commit(context.Background()); err != nil {
+		return User{}, fmt.Errorf("unable to commit transaction: %v", err)
+	}
+
+	return user, nil
+}
+
+// DeleteRefreshTokenFromDB ends the session of the refresh token hashed as tokenHash.
+// Unknown tokens are ignored.
+func DeleteRefreshTokenFromDB(postgresURL string, tokenHash string) error {
+	if postgresURL == "" {
+		return fmt.Errorf("POSTGRES_URL is not set")
+	}
+
+	conn, err := pgx.Connect(context.Background(), postgresURL)
+	if err != nil {
+		return fmt.Errorf("unable to connect to database: %v", err)
+	}
+	defer func() {
+		if err := conn.Close(context.Background()); err != nil {
+			fmt.Printf("error closing connection: %v\n", err)
+		}
+	}()
+
+	_, err = conn.Exec(context.Background(), `
+		DELETE FROM refresh_tokens
+		WHERE token_hash = $1
+	`, tokenHash)
+	if err != nil {
+		return fmt.Errorf("query failed: %v", err)
+	}
+
+	return nil
+}
+
+// RevokeSessionsInDB revokes every access, refresh and API token issued to username,
+// or to every user when username is empty.
+func RevokeSessionsInDB(postgresURL string, username string) error {
+	if postgresURL == "" {
+		return fmt.Errorf("POSTGRES_URL is not set")
+	}
+
+	conn, err := pgx.Connect(context.Background(), postgresURL)
+	if err != nil {
+		return fmt.Errorf("unable to connect to database: %v", err)
+	}
+	defer func() {
+		if err := conn.Close(context.Background()); err != nil {
+			fmt.Printf("error closing connection: %v\n", err)
+		}
+	}()
+
+	res, err := conn.Exec(context.Background(), `
+		UPDATE users
+		SET session_version = session_version + 1,
+		    date_modified = now()
+		WHERE $1::text = '' OR username = $1
+	`, username)
+	if err != nil {
+		return fmt.Errorf("query failed: %v", err)
+	}
+	if username != "" && res.RowsAffected() == 0 {
+		return fmt.Errorf("%w: %s", ErrUserNotFound, username)
+	}
+
+	return nil
+}
+
+// startSession logs user in, setting cookies with a new access and refresh token.
+func (c Config) startSession(ctx *gin.Context, user User, refreshToken string) error {
+	tokenString, err := createToken(c.JWTSigningKeys[0], user)
+	if err != nil {
+		return err
+	}
+
+	ctx.SetCookie(TOKEN_COOKIE, tokenString, int(EXPIRES_IN.Seconds()), "/", "", false, true)
+	ctx.SetCookie(REFRESH_COOKIE, refreshToken, int(REFRESH_EXPIRES_IN.Seconds()), "/", "", false, true)
+	ctx.JSON(http.StatusOK, gin.H{"token": tokenString, "refresh_token": refreshToken})
+	return nil
+}
+
+// clearSessionCookies removes the access and refresh token cookies.
+func clearSessionCookies(ctx *gin.Context) {
+	ctx.SetCookie(TOKEN_COOKIE, "", -1, "/", "", false, true)
+	ctx.SetCookie(REFRESH_COOKIE, "", -1, "/", "", false, true)
+}
+
+// Refresh handles the POST /refresh endpoint, exchanging the refresh token cookie for
+// a new access token and refresh token.
+func (c Config) Refresh(ctx *gin.Context) {
+	oldToken, err := ctx.Cookie(REFRESH_COOKIE)
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token missing in cookie"})
+		return
+	}
+
+	newToken, newHash, err := newSecretToken("")
+	if err != nil {
+		log.Println("Error in Refresh while creating refresh token:", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh session"})
+		return
+	}
+
+	user, err := RotateRefreshTokenInDB(c.PostgresURL, hashToken(oldToken), newHash)
+	if errors.Is(err, ErrRefreshTokenInvalid) || errors.Is(err, ErrRefreshTokenReused) {
+		log.Println("Error in Refresh while rotating refresh token:", err)
+		clearSessionCookies(ctx)
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		return
+	}
+	if err != nil {
+		log.Println("Error in Refresh while rotating refresh token:", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh session"})
+		return
+	}
+
+	if err := c.startSession(ctx, user, newToken); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create token"})
+	}
+}
+
+// Logout handles the POST /logout endpoint, ending the session of the refresh token
+// cookie and clearing the cookies.
+func (c Config) Logout(ctx *gin.Context) {
+	if refreshToken, err := ctx.Cookie(REFRESH_COOKIE); err == nil {
+		if err := DeleteRefreshTokenFromDB(c.PostgresURL, hashToken(refreshToken)); err != nil {
+			log.Println("Error in Logout while deleting refresh token from DB:", err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	clearSessionCookies(ctx)
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+	})
+}
+
+// RevokeUserSessions handles the POST /users/:username/revoke endpoint, logging the
+// user out everywhere and revoking their API tokens.
+func (c Config) RevokeUserSessions(ctx *gin.Context) {
+	username := ctx.Param("username")
+	if err := RevokeSessionsInDB(c.PostgresURL, username); err != nil {
+		log.Println("Error in RevokeUserSessions while revoking sessions in DB:", err)
+		ctx.JSON(userErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+	})
+}
+
+// RevokeAllSessions handles the POST /sessions/revoke endpoint, logging every user
+// out everywhere, including the admin calling it, and revoking every API token.
+func (c Config) RevokeAllSessions(ctx *gin.Context) {
+	if err := RevokeSessionsInDB(c.PostgresURL, ""); err != nil {
+		log.Println("Error in RevokeAllSessions while revoking sessions in DB:", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	clearSessionCookies(ctx)
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+	})
+}
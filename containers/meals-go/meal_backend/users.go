@@ -0,0 +1,457 @@
+package meal_backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Role decides which routes a user can call. Each role can do everything the roles
+// below it can.
+type Role string
+
+const (
+	// RoleViewer can read the calendar, items and meals
+	RoleViewer Role = "viewer"
+	// RoleEditor can also change items, meals, the plan and recipes, and send email
+	RoleEditor Role = "editor"
+	// RoleAdmin can also manage users
+	RoleAdmin Role = "admin"
+)
+
+var roleRanks = map[Role]int{
+	RoleViewer: 1,
+	RoleEditor: 2,
+	RoleAdmin:  3,
+}
+
+func (r Role) IsValid() error {
+	if _, ok := roleRanks[r]; !ok {
+		return errors.New("invalid role: " + string(r))
+	}
+	return nil
+}
+
+// Allows reports whether the role can call routes requiring required.
+func (r Role) Allows(required Role) bool {
+	return roleRanks[r] >= roleRanks[required]
+}
+
+// INITIAL_ADMIN_USERNAME is the admin created with the deployment password when there
+// are no users yet.
+const INITIAL_ADMIN_USERNAME = "admin"
+
+// MIN_PASSWORD_LENGTH is the shortest password accepted for a user.
+const MIN_PASSWORD_LENGTH = 8
+
+// dummyPasswordHash is checked against when logging in as a user that doesn't exist, so
+// that takes as long as a wrong password and doesn't reveal which usernames exist.
+const dummyPasswordHash = "$2a$10$FCDlBJ0Rla3t5tAcouuWO.uZ1.uMeVf8R4UJWsa15CWfa62ybn9/m"
+
+var (
+	ErrUserExists   = errors.New("user already exists")
+	ErrUserNotFound = errors.New("user not found")
+)
+
+// User is an account that can log in. The password is only ever stored hashed.
+type User struct {
+	Username     string
+	Role         Role
+	PasswordHash string `json:"-"`
+	// SessionVersion is in every token issued to the user. Bumping it revokes them all.
+	SessionVersion int `json:"-"`
+}
+
+// hashPassword hashes password with bcrypt.
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %v", err)
+	}
+	return string(hash), nil
+}
+
+// CheckPassword reports whether password is the user's password.
+func (u User) CheckPassword(password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)) == nil
+}
+
+// validateUsername checks that a username is set and has no surrounding whitespace.
+func validateUsername(username string) error {
+	if username == "" {
+		return errors.New("username cannot be empty")
+	}
+	if strings.TrimSpace(username) != username {
+		return errors.New("username cannot start or end with whitespace")
+	}
+	return nil
+}
+
+// validatePassword checks that a password is long enough.
+func validatePassword(password string) error {
+	if len(password) < MIN_PASSWORD_LENGTH {
+		return fmt.Errorf("password must be at least %d characters", MIN_PASSWORD_LENGTH)
+	}
+	return nil
+}
+
+// ReadUsersFromDB returns every user, sorted by username.
+func ReadUsersFromDB(postgresURL string) ([]User, error) {
+	if postgresURL == "" {
+		return nil, fmt.Errorf("POSTGRES_URL is not set")
+	}
+
+	conn, err := pgx.Connect(context.Background(), postgresURL)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to database: %v", err)
+	}
+	defer func() {
+		if err := conn.Close(context.Background()); err != nil {
+			fmt.Printf("error closing connection: %v\n", err)
+		}
+	}()
+
+	rows, err := conn.Query(context.Background(), `
+		SELECT username, role, password_hash, session_version
+		FROM users
+		ORDER BY username
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %v", err)
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var u User
+		if err := rows.Scan(&u.Username, &u.Role, &u.PasswordHash, &u.SessionVersion); err != nil {
+			return nil, fmt.Errorf("scan failed: %v", err)
+		}
+		users = append(users, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %v", err)
+	}
+
+	return users, nil
+}
+
+// ReadUserFromDB returns the user named username, or ErrUserNotFound.
+func ReadUserFromDB(postgresURL string, username string) (User, error) {
+	if postgresURL == "" {
+		return User{}, fmt.Errorf("POSTGRES_URL is not set")
+	}
+
+	conn, err := pgx.Connect(context.Background(), postgresURL)
+	if err != nil {
+		return User{}, fmt.Errorf("unable to connect to database: %v", err)
+	}
+	defer func() {
+		if err := conn.Close(context.Background()); err != nil {
+			fmt.Printf("error closing connection: %v\n", err)
+		}
+	}()
+
+	u := User{Username: username}
+	err = conn.QueryRow(context.Background(), `
+		SELECT role, password_hash, session_version
+		FROM users
+		WHERE username = $1
+	`, username).Scan(&u.Role, &u.PasswordHash, &u.SessionVersion)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return User{}, fmt.Errorf("%w: %s", ErrUserNotFound, username)
+	}
+	if err != nil {
+		return User{}, fmt.Errorf("query failed: %v", err)
+	}
+
+	return u, nil
+}
+
+// CreateUserInDB adds a user with the given password.
+func CreateUserInDB(postgresURL string, username string, password string, role Role) error {
+	if err := validateUsername(username); err != nil {
+		return err
+	}
+	if err := validatePassword(password); err != nil {
+		return err
+	}
+	if err := role.IsValid(); err != nil {
+		return err
+	}
+
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	if postgresURL == "" {
+		return fmt.Errorf("POSTGRES_URL is not set")
+	}
+
+	conn, err := pgx.Connect(context.Background(), postgresURL)
+	if err != nil {
+		return fmt.Errorf("unable to connect to database: %v", err)
+	}
+	defer func() {
+		if err := conn.Close(context.Background()); err != nil {
+			fmt.Printf("error closing connection: %v\n", err)
+		}
+	}()
+
+	res, err := conn.Exec(context.Background(), `
+		INSERT INTO users (username, password_hash, role)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (username) DO NOTHING
+	`, username, hash, role)
+	if err != nil {
+		return fmt.Errorf("query failed: %v", err)
+	}
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("%w: %s", ErrUserExists, username)
+	}
+
+	return nil
+}
+
+// UpdateUserInDB changes a user's role and, when password isn't empty, their password.
+// Changing the password revokes the user's sessions and API tokens.
+func UpdateUserInDB(postgresURL string, username string, password string, role Role) error {
+	if err := role.IsValid(); err != nil {
+		return err
+	}
+
+	hash := ""
+	if password != "" {
+		if err := validatePassword(password); err != nil {
+			return err
+		}
+		var err error
+		if hash, err = hashPassword(password); err != nil {
+			return err
+		}
+	}
+
+	if postgresURL == "" {
+		return fmt.Errorf("POSTGRES_URL is not set")
+	}
+
+	conn, err := pgx.Connect(context.Background(), postgresURL)
+	if err != nil {
+		return fmt.Errorf("unable to connect to database: %v", err)
+	}
+	defer func() {
+		if err := conn.Close(context.Background()); err != nil {
+			fmt.Printf("error closing connection: %v\n", err)
+		}
+	}()
+
+	res, err := conn.Exec(context.Background(), `
+		UPDATE users
+		SET role = $1,
+		    password_hash = COALESCE(NULLIF($2, ''), password_hash),
+		    session_version = session_version + CASE WHEN $2 = '' THEN 0 ELSE 1 END,
+		    date_modified = now()
+		WHERE username = $3
+	`, role, hash, username)
+	if err != nil {
+		return fmt.Errorf("query failed: %v", err)
+	}
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("%w: %s", ErrUserNotFound, username)
+	}
+
+	return nil
+}
+
+// DeleteUserFromDB deletes the user named username.
+func DeleteUserFromDB(postgresURL string, username string) error {
+	if postgresURL == "" {
+		return fmt.Errorf("POSTGRES_URL is not set")
+	}
+
+	conn, err := pgx.Connect(context.Background(), postgresURL)
+	if err != nil {
+		return fmt.Errorf("unable to connect to database: %v", err)
+	}
+	defer func() {
+		if err := conn.Close(context.Background()); err != nil {
+			fmt.Printf("error closing connection: %v\n", err)
+		}
+	}()
+
+	res, err := conn.Exec(context.Background(), `
+		DELETE FROM users
+		WHERE username = $1
+	`, username)
+	if err != nil {
+		return fmt.Errorf("query failed: %v", err)
+	}
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("%w: %s", ErrUserNotFound, username)
+	}
+
+	return nil
+}
+
+// ensureInitialAdmin creates INITIAL_ADMIN_USERNAME with the deployment password when
+// there are no users, so a fresh deployment can log in and add everyone else.
+func (c Config) ensureInitialAdmin() {
+	users, err := ReadUsersFromDB(c.PostgresURL)
+	if err != nil {
+		log.Fatalf("Failed to read users: %v", err)
+	}
+	if len(users) > 0 {
+		return
+	}
+
+	if err := CreateUserInDB(c.PostgresURL, INITIAL_ADMIN_USERNAME, c.DeploymentPassword, RoleAdmin); err != nil {
+		log.Fatalf("Failed to create initial admin user: %v", err)
+	}
+	log.Printf("Created initial admin user '%s' with the deployment password\n", INITIAL_ADMIN_USERNAME)
+}
+
+// userErrorStatus maps an error from writing a user to its HTTP status.
+func userErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrUserExists):
+		return http.StatusConflict
+	case errors.Is(err, ErrUserNotFound):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// UserRequest represents the payload for creating or updating a user. When updating,
+// an empty Password keeps the current one.
+type UserRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Role     Role   `json:"role"`
+}
+
+// GetUsers handles the GET /users endpoint, returning every user without their password.
+func (c Config) GetUsers(ctx *gin.Context) {
+	users, err := ReadUsersFromDB(c.PostgresURL)
+	if err != nil {
+		log.Println("Error in GetUsers while fetching users:", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err,
+		})
+		return
+	}
+
+	if users == nil {
+		users = []User{}
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"users": users,
+	})
+}
+
+// CreateUser handles the POST /users endpoint.
+func (c Config) CreateUser(ctx *gin.Context) {
+	var userRequest UserRequest
+	if err := ctx.BindJSON(&userRequest); err != nil {
+		log.Println("Error in CreateUser while binding JSON:", err)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err,
+		})
+		return
+	}
+
+	for _, err := range []error{
+		validateUsername(userRequest.Username),
+		validatePassword(userRequest.Password),
+		userRequest.Role.IsValid(),
+	} {
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	if err := CreateUserInDB(c.PostgresURL, userRequest.Username, userRequest.Password, userRequest.Role); err != nil {
+		log.Println("Error in CreateUser while creating user in DB:", err)
+		ctx.JSON(userErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+	})
+}
+
+// UpdateUser handles the PUT /users/:username endpoint, changing the user's role and
+// optionally their password.
+func (c Config) UpdateUser(ctx *gin.Context) {
+	var userRequest UserRequest
+	if err := ctx.BindJSON(&userRequest); err != nil {
+		log.Println("Error in UpdateUser while binding JSON:", err)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err,
+		})
+		return
+	}
+
+	if err := userRequest.Role.IsValid(); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if userRequest.Password != "" {
+		if err := validatePassword(userRequest.Password); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	username := ctx.Param("username")
+	if username == currentUser(ctx).Username && userRequest.Role != RoleAdmin {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Admins cannot remove their own admin role"})
+		return
+	}
+
+	if err := UpdateUserInDB(c.PostgresURL, username, userRequest.Password, userRequest.Role); err != nil {
+		log.Println("Error in UpdateUser while updating user in DB:", err)
+		ctx.JSON(userErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+	})
+}
+
+// DeleteUser handles the DELETE /users/:username endpoint.
+func (c Config) DeleteUser(ctx *gin.Context) {
+	username := ctx.Param("username")
+	if username == currentUser(ctx).Username {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Admins cannot delete themselves"})
+		return
+	}
+
+	if err := DeleteUserFromDB(c.PostgresURL, username); err != nil {
+		log.Println("Error in DeleteUser while deleting user from DB:", err)
+		ctx.JSON(userErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+	})
+}
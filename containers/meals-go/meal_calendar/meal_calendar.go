@@ -9,8 +9,12 @@ import (
 
 // MealCalendar combines calendar and meal collection functionalities
 type MealCalendar struct {
-	Calendar       calendar.Calendar
-	MealCollection meal_collection.MealCollection
+	Calendar        calendar.Calendar
+	MealCollection  meal_collection.MealCollection
+	GenerateOptions meal_collection.GenerateOptions
+	// Schedule is the stored plan, snapshot and pins covering the month, see
+	// meal_collection.LoadScheduleFromDB
+	Schedule meal_collection.Schedule
 }
 
 func NewCalendar(calendar calendar.Calendar, meal_collection meal_collection.MealCollection) *MealCalendar {
@@ -23,7 +27,7 @@ func NewCalendar(calendar calendar.Calendar, meal_collection meal_collection.Mea
 }
 
 func (mc *MealCalendar) RenderHTMLCalendar() string {
-	items := mc.MealCollection.GenerateMealsWholeYearNoCategories(mc.Calendar)
+	items := mc.MealCollection.MealsForDates(mc.Calendar.Dates(), mc.GenerateOptions, mc.Schedule)
 
 	mealCalendar := fmt.Sprintf(`
 <h1>%s %d</h1>
@@ -47,7 +51,7 @@ func (mc *MealCalendar) RenderHTMLCalendar() string {
 				mealCalendar += "NONE"
 			} else {
 				item := items[day.Number-1]
-				itemName := item.Name
+				itemName := item.DisplayName()
 				if len(item.Ingredients) == 0 {
 					if item.Name != "Leftovers" && item.Name != "Out" {
 						itemName += "*"
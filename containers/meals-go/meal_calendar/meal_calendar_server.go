@@ -11,9 +11,29 @@ import (
 )
 
 type Config struct {
-	BucketName string
-	BucketKey  string
-	Port       int
+	BucketName      string
+	BucketKey       string
+	Port            int
+	PostgresURL     string
+	GenerateOptions meal_collection.GenerateOptions
+}
+
+// newMealCalendar builds the calendar of the given month with its stored schedule, so it
+// shows the same meals as the backend calendar and the email. Without PostgresURL there
+// is no stored schedule, and the meals are only generated.
+func (c Config) newMealCalendar(year int, month time.Month, collection meal_collection.MealCollection) (*MealCalendar, error) {
+	mealCalendar := NewCalendar(*calendar.NewCalendar(year, month), collection)
+	mealCalendar.GenerateOptions = c.GenerateOptions
+	if c.PostgresURL == "" {
+		return mealCalendar, nil
+	}
+
+	schedule, err := meal_collection.LoadScheduleFromDB(c.PostgresURL, mealCalendar.Calendar.Dates(), c.GenerateOptions)
+	if err != nil {
+		return nil, err
+	}
+	mealCalendar.Schedule = schedule
+	return mealCalendar, nil
 }
 
 func (c Config) mealCalendarHandler(w http.ResponseWriter, r *http.Request) {
@@ -45,8 +65,18 @@ func (c Config) mealCalendarHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Build two calendars: current month + next month
-	currMonthMealCalendar := NewCalendar(*calendar.NewCalendar(currYear, currMonth), mealCollection)
-	nextMonthMealCalendar := NewCalendar(*calendar.NewCalendar(nextYear, nextMonth), mealCollection)
+	currMonthMealCalendar, err := c.newMealCalendar(currYear, currMonth, mealCollection)
+	if err != nil {
+		log.Println("Error fetching schedule:", err)
+		http.Error(w, "Failed to fetch schedule", http.StatusInternalServerError)
+		return
+	}
+	nextMonthMealCalendar, err := c.newMealCalendar(nextYear, nextMonth, mealCollection)
+	if err != nil {
+		log.Println("Error fetching schedule:", err)
+		http.Error(w, "Failed to fetch schedule", http.StatusInternalServerError)
+		return
+	}
 
 	// Build the HTML list of all items
 	endList := "<h2>ALL ITEMS</h2>\n\n<ul>\n"
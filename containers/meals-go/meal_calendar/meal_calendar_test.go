@@ -2,6 +2,7 @@ package meal_calendar
 
 import (
 	"log"
+	"strings"
 	"testing"
 	"time"
 
@@ -26,3 +27,37 @@ func TestCalendarHTMLGeneration(t *testing.T) {
 	// TODO: Actually test here. Golden tests are a pain comparing against a changing
 	// output...
 }
+
+func TestCalendarHTMLFollowsSchedule(t *testing.T) {
+	collection := meal_collection.MealCollection{{Name: "Tacos"}, {Name: "Chili"}}
+	template := meal_collection.WeeklyTemplate{}
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		template[day] = meal_collection.DayRule{Meal: "Tacos"}
+	}
+
+	mealCalendar := NewCalendar(*calendar.NewCalendar(2024, time.February), collection)
+	mealCalendar.GenerateOptions = meal_collection.GenerateOptions{Template: template}
+	mealCalendar.Schedule = meal_collection.Schedule{
+		Plan: []meal_collection.PlannedMeal{{Date: time.Date(2024, time.February, 3, 0, 0, 0, 0, time.UTC), Meal: "Chili"}},
+	}
+
+	html := mealCalendar.RenderHTMLCalendar()
+	if !strings.Contains(html, "<b> 3 </b> Chili*") {
+		t.Errorf("Expected the planned meal on the 3rd, got %s", html)
+	}
+	if !strings.Contains(html, "<b> 4 </b> Tacos*") {
+		t.Errorf("Expected the template's meal on the 4th, got %s", html)
+	}
+}
+
+func TestNewMealCalendarWithoutPostgres(t *testing.T) {
+	collection := meal_collection.MealCollection{{Name: "Tacos"}}
+
+	mealCalendar, err := Config{}.newMealCalendar(2024, time.February, collection)
+	if err != nil {
+		t.Fatalf("Expected the calendar to be generated without a database, got %v", err)
+	}
+	if mealCalendar.Schedule.Plan != nil || mealCalendar.Schedule.Snapshot != nil {
+		t.Errorf("Expected an empty schedule, got %+v", mealCalendar.Schedule)
+	}
+}
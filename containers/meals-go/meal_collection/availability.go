@@ -0,0 +1,66 @@
+package meal_collection
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// MonthDay is a day of the year in "MM-DD" format, e.g. "11-01".
+type MonthDay string
+
+// MONTH_DAY_LAYOUT is the time layout of a MonthDay.
+const MONTH_DAY_LAYOUT = "01-02"
+
+func (d MonthDay) IsValid() error {
+	if _, err := time.Parse(MONTH_DAY_LAYOUT, string(d)); err != nil {
+		return fmt.Errorf("invalid month-day '%s', expected MM-DD", d)
+	}
+	return nil
+}
+
+// ordinal returns the month-day as a sortable number, e.g. 1101 for "11-01". d must be valid.
+func (d MonthDay) ordinal() int {
+	t, _ := time.Parse(MONTH_DAY_LAYOUT, string(d))
+	return int(t.Month())*100 + t.Day()
+}
+
+// validateAvailability checks that a meal's availability window is either unset, or
+// has both ends set to valid month-days.
+func validateAvailability(meal Meal) error {
+	if meal.AvailableFrom == "" && meal.AvailableTo == "" {
+		return nil
+	}
+	if meal.AvailableFrom == "" || meal.AvailableTo == "" {
+		return errors.New("available_from and available_to must be set together")
+	}
+	if err := meal.AvailableFrom.IsValid(); err != nil {
+		return err
+	}
+	return meal.AvailableTo.IsValid()
+}
+
+// AvailableOn reports whether date falls within the meal's availability window. A
+// window whose end is before its start wraps around the new year, so "11-01" to
+// "03-15" covers the winter.
+func (m Meal) AvailableOn(date time.Time) bool {
+	if m.AvailableFrom == "" || m.AvailableTo == "" {
+		return true
+	}
+
+	from, to := m.AvailableFrom.ordinal(), m.AvailableTo.ordinal()
+	day := int(date.Month())*100 + date.Day()
+	if from <= to {
+		return from <= day && day <= to
+	}
+	return day >= from || day <= to
+}
+
+// availabilityFilter rejects meals outside their availability window.
+type availabilityFilter struct{}
+
+func (availabilityFilter) Accept(date time.Time, meal Meal) bool {
+	return meal.AvailableOn(date)
+}
+
+func (availabilityFilter) Served(date time.Time, meal Meal) {}
@@ -0,0 +1,172 @@
+package meal_collection
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// CatalogIngredient is the canonical form of an ingredient. Recipes may refer to it by
+// its name or any alias, in any case, and may leave out the aisle to use the default.
+type CatalogIngredient struct {
+	Name    string   `json:"name"`
+	Aliases []string `json:"aliases,omitempty"`
+	Aisle   Aisle    `json:"aisle,omitempty"`
+}
+
+type IngredientCatalog []CatalogIngredient
+
+// RecipeFile is the JSON document recipes are loaded from. It is either a bare list of
+// recipes, or an object that also carries an ingredient catalog.
+type RecipeFile struct {
+	Recipes     MealCollection    `json:"recipes"`
+	Ingredients IngredientCatalog `json:"ingredients,omitempty"`
+}
+
+// UnsortUnknownAisles returns a copy of the file where every recipe ingredient and
+// catalog entry in an aisle that isn't configured is moved to AisleUnsorted, logging a
+// warning for each. This way an aisle dropped from the config doesn't fail the file.
+func (f RecipeFile) UnsortUnknownAisles() RecipeFile {
+	unsorted := RecipeFile{
+		Recipes:     f.Recipes.DeepCopy(),
+		Ingredients: append(IngredientCatalog(nil), f.Ingredients...),
+	}
+
+	for i, entry := range unsorted.Ingredients {
+		if entry.Aisle != "" && entry.Aisle.IsValid() != nil {
+			log.Printf("Warning: catalog ingredient '%s' is in unknown aisle '%s', using '%s'\n", entry.Name, entry.Aisle, AisleUnsorted)
+			unsorted.Ingredients[i].Aisle = AisleUnsorted
+		}
+	}
+	for _, meal := range unsorted.Recipes {
+		for j, ing := range meal.Ingredients {
+			if ing.Aisle != "" && ing.Aisle.IsValid() != nil {
+				log.Printf("Warning: ingredient '%s' of '%s' is in unknown aisle '%s', using '%s'\n", ing.Name, meal.Name, ing.Aisle, AisleUnsorted)
+				meal.Ingredients[j].Aisle = AisleUnsorted
+			}
+		}
+	}
+
+	return unsorted
+}
+
+// NormalizeIngredientName returns the key ingredient names are compared by: lowercase,
+// with runs of whitespace collapsed.
+func NormalizeIngredientName(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+// CatalogIndex maps the normalized name and aliases of every catalog entry to the entry.
+type CatalogIndex map[string]CatalogIngredient
+
+// Index builds the index of the catalog. Build it once and reuse it for many lookups.
+func (c IngredientCatalog) Index() CatalogIndex {
+	index := make(CatalogIndex)
+	for _, entry := range c {
+		index[NormalizeIngredientName(entry.Name)] = entry
+		for _, alias := range entry.Aliases {
+			index[NormalizeIngredientName(alias)] = entry
+		}
+	}
+	return index
+}
+
+// Validate checks that every entry is named and has a valid aisle, and that no name or
+// alias is claimed by two entries.
+func (c IngredientCatalog) Validate() error {
+	seen := make(map[string]string)
+	for _, entry := range c {
+		if strings.TrimSpace(entry.Name) == "" {
+			return errors.New("catalog ingredient name cannot be empty")
+		}
+		if entry.Aisle != "" {
+			if err := entry.Aisle.IsValid(); err != nil {
+				return fmt.Errorf("catalog ingredient '%s': %v", entry.Name, err)
+			}
+		}
+		for _, name := range append([]string{entry.Name}, entry.Aliases...) {
+			key := NormalizeIngredientName(name)
+			if owner, ok := seen[key]; ok && owner != entry.Name {
+				return fmt.Errorf("catalog name '%s' is used by both '%s' and '%s'", name, owner, entry.Name)
+			}
+			seen[key] = entry.Name
+		}
+	}
+	return nil
+}
+
+// Lookup returns the catalog entry whose name or alias matches name.
+func (i CatalogIndex) Lookup(name string) (CatalogIngredient, bool) {
+	entry, ok := i[NormalizeIngredientName(name)]
+	return entry, ok
+}
+
+// ApplyCatalog returns a copy of the collection where every ingredient found in the
+// catalog is renamed to its canonical name, and gets the catalog's aisle if it has none.
+func (m MealCollection) ApplyCatalog(catalog IngredientCatalog) MealCollection {
+	mealCopy := m.DeepCopy()
+	if len(catalog) == 0 {
+		return mealCopy
+	}
+
+	index := catalog.Index()
+	for i := range mealCopy {
+		for j, ing := range mealCopy[i].Ingredients {
+			entry, ok := index.Lookup(ing.Name)
+			if !ok {
+				continue
+			}
+			ing.Name = entry.Name
+			if ing.Aisle == "" {
+				ing.Aisle = entry.Aisle
+			}
+			mealCopy[i].Ingredients[j] = ing
+		}
+	}
+
+	return mealCopy
+}
+
+// decodeRecipeFile decodes either format of RecipeFile, parsing raw ingredient lines.
+func decodeRecipeFile(data []byte, disallowUnknownFields bool) (RecipeFile, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if disallowUnknownFields {
+		decoder.DisallowUnknownFields()
+	}
+
+	var recipeFile RecipeFile
+	var err error
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		err = decoder.Decode(&recipeFile)
+	} else {
+		err = decoder.Decode(&recipeFile.Recipes)
+	}
+	if err != nil {
+		return RecipeFile{}, err
+	}
+
+	for i := range recipeFile.Recipes {
+		if err := recipeFile.Recipes[i].ParseRawIngredients(); err != nil {
+			return RecipeFile{}, err
+		}
+	}
+
+	return recipeFile, nil
+}
+
+// Marshal encodes the recipe file in the object format, indented like recipes.json.
+func (f RecipeFile) Marshal() ([]byte, error) {
+	data, err := json.MarshalIndent(f, "", "    ")
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling JSON: %v", err)
+	}
+	return append(data, '\n'), nil
+}
+
+// UnmarshalRecipeFile decodes a RecipeFile, ignoring unknown fields.
+func UnmarshalRecipeFile(data []byte) (RecipeFile, error) {
+	return decodeRecipeFile(data, false)
+}
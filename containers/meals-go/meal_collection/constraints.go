@@ -0,0 +1,226 @@
+package meal_collection
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// HasTag reports whether the meal is tagged with tag, ignoring case.
+func (m Meal) HasTag(tag string) bool {
+	for _, t := range m.Tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateTags checks that a meal's tags are non-empty and not repeated.
+func validateTags(meal Meal) error {
+	seen := map[string]bool{}
+	for _, tag := range meal.Tags {
+		normalized := strings.ToLower(strings.TrimSpace(tag))
+		if normalized == "" {
+			return errors.New("tags cannot be empty")
+		}
+		if seen[normalized] {
+			return fmt.Errorf("duplicate tag '%s'", tag)
+		}
+		seen[normalized] = true
+	}
+	return nil
+}
+
+// MAX_WEEK_CONSTRAINTS is how many constraints can be enforced, one bit of a uint64 mask each.
+const MAX_WEEK_CONSTRAINTS = 64
+
+// WeekConstraint bounds how many meals with a tag are served each week, Sunday through
+// Saturday. For example, at least 3 "vegetarian" meals, or at most 1 "long-prep" meal
+// on weekdays.
+type WeekConstraint struct {
+	Tag string
+	Min int
+	// Max is the most meals allowed, or nil for no limit
+	Max *int
+	// Weekdays only counts meals served Monday through Friday
+	Weekdays bool
+}
+
+func (c WeekConstraint) IsValid() error {
+	if strings.TrimSpace(c.Tag) == "" {
+		return errors.New("constraint tag cannot be empty")
+	}
+	if c.Min < 0 || (c.Max != nil && *c.Max < 0) {
+		return fmt.Errorf("constraint on '%s' cannot be negative", c.Tag)
+	}
+	if c.Max != nil && *c.Max < c.Min {
+		return fmt.Errorf("constraint on '%s' has a max below its min", c.Tag)
+	}
+	days := 7
+	if c.Weekdays {
+		days = 5
+	}
+	if c.Min > days {
+		return fmt.Errorf("constraint on '%s' needs more than %d days", c.Tag, days)
+	}
+	if c.Min == 0 && c.Max == nil {
+		return fmt.Errorf("constraint on '%s' needs a min or a max", c.Tag)
+	}
+	return nil
+}
+
+// counts reports whether meal, served on date, counts toward the constraint.
+func (c WeekConstraint) counts(date time.Time, meal Meal) bool {
+	if c.Weekdays && (date.Weekday() == time.Saturday || date.Weekday() == time.Sunday) {
+		return false
+	}
+	return meal.HasTag(c.Tag)
+}
+
+// weekStart returns the Sunday starting date's week.
+func weekStart(date time.Time) time.Time {
+	return date.AddDate(0, 0, -int(date.Weekday()))
+}
+
+// weekConstraintFilter only accepts a meal when the rest of the week can still meet
+// every constraint. It searches the remaining days of the week over the kinds of meals
+// that could be served on each, so a choice that would leave the week unsatisfiable is
+// rejected up front rather than discovered on Saturday. When the week can no longer be
+// satisfied, e.g. there aren't enough tagged meals, every meal is accepted.
+type weekConstraintFilter struct {
+	constraints []WeekConstraint
+	collection  MealCollection
+	mealMap     map[string]Meal
+	template    WeeklyTemplate
+	// candidates decide which meals could be generated on a day, besides being enabled
+	candidates []mealFilter
+
+	week   time.Time
+	served []int
+	// Per week caches of the masks possible on each weekday, and of searched states
+	masks    map[time.Weekday][]uint64
+	feasible map[string]bool
+}
+
+func newWeekConstraintFilter(constraints []WeekConstraint, collection MealCollection, template WeeklyTemplate, candidates []mealFilter) *weekConstraintFilter {
+	return &weekConstraintFilter{
+		constraints: constraints,
+		collection:  collection,
+		mealMap:     collection.MapNameToMeal(),
+		template:    template,
+		candidates:  candidates,
+	}
+}
+
+// startWeek resets the counts when date is in a new week.
+func (f *weekConstraintFilter) startWeek(date time.Time) {
+	if start := weekStart(date); !start.Equal(f.week) || f.served == nil {
+		f.week = start
+		f.served = make([]int, len(f.constraints))
+		f.masks = map[time.Weekday][]uint64{}
+		f.feasible = map[string]bool{}
+	}
+}
+
+// mask has bit i set when meal, served on date, counts toward constraint i.
+func (f *weekConstraintFilter) mask(date time.Time, meal Meal) uint64 {
+	var mask uint64
+	for i, constraint := range f.constraints {
+		if constraint.counts(date, meal) {
+			mask |= 1 << i
+		}
+	}
+	return mask
+}
+
+// add returns counts with mask added, and false if that exceeds a max.
+func (f *weekConstraintFilter) add(counts []int, mask uint64) ([]int, bool) {
+	next := make([]int, len(counts))
+	copy(next, counts)
+	for i, constraint := range f.constraints {
+		if mask&(1<<i) == 0 {
+			continue
+		}
+		next[i]++
+		if constraint.Max != nil && next[i] > *constraint.Max {
+			return next, false
+		}
+	}
+	return next, true
+}
+
+// dayMasks returns the distinct masks of the meals that could be served on date.
+func (f *weekConstraintFilter) dayMasks(date time.Time) []uint64 {
+	if masks, ok := f.masks[date.Weekday()]; ok {
+		return masks
+	}
+
+	var masks []uint64
+	if rule := f.template[date.Weekday()]; rule.Meal != "" {
+		masks = []uint64{f.mask(date, fixedMeal(f.mealMap, rule.Meal))}
+	} else {
+		seen := map[uint64]bool{}
+		for _, meal := range f.collection {
+			if meal.Disabled || !acceptedByAll(f.candidates, date, meal) {
+				continue
+			}
+			if mask := f.mask(date, meal); !seen[mask] {
+				seen[mask] = true
+				masks = append(masks, mask)
+			}
+		}
+		if len(masks) == 0 {
+			// Nothing fits, so whatever is served anyway is assumed not to count
+			masks = []uint64{0}
+		}
+	}
+
+	f.masks[date.Weekday()] = masks
+	return masks
+}
+
+// satisfiable reports whether the days from date to the end of the week can be served
+// so that, starting from counts, every constraint is met.
+func (f *weekConstraintFilter) satisfiable(date time.Time, counts []int) bool {
+	if !weekStart(date).Equal(f.week) {
+		for i, constraint := range f.constraints {
+			if counts[i] < constraint.Min {
+				return false
+			}
+		}
+		return true
+	}
+
+	key := fmt.Sprint(date.Weekday(), counts)
+	if result, ok := f.feasible[key]; ok {
+		return result
+	}
+
+	result := false
+	for _, mask := range f.dayMasks(date) {
+		if next, ok := f.add(counts, mask); ok && f.satisfiable(date.AddDate(0, 0, 1), next) {
+			result = true
+			break
+		}
+	}
+
+	f.feasible[key] = result
+	return result
+}
+
+func (f *weekConstraintFilter) Accept(date time.Time, meal Meal) bool {
+	f.startWeek(date)
+	if !f.satisfiable(date, f.served) {
+		return true
+	}
+
+	next, ok := f.add(f.served, f.mask(date, meal))
+	return ok && f.satisfiable(date.AddDate(0, 0, 1), next)
+}
+
+func (f *weekConstraintFilter) Served(date time.Time, meal Meal) {
+	f.startWeek(date)
+	f.served, _ = f.add(f.served, f.mask(date, meal))
+}
@@ -1,36 +1,58 @@
 package meal_collection
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"sort"
 	"strings"
 	"time"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
 func ReadMealCollectionFromDB(postgresURL string, recipeCreatedCutoff int64) (MealCollection, error) {
-	// Temporary types just for DB scans and JSON unmarshaling.
-	type DBIngredient struct {
-		Item     string  `json:"item"`
-		Quantity float64 `json:"quantity"`
-		Unit     string  `json:"unit"`
-		Aisle    string  `json:"aisle"`
+	if postgresURL == "" {
+		return nil, fmt.Errorf("POSTGRES_URL is not set")
 	}
 
-	type DBRecipe struct {
-		ID           int            `json:"id"`
-		Category     string         `json:"category"`
-		Name         string         `json:"name"`
-		URL          string         `json:"url"`
-		Ingredients  []DBIngredient `json:"ingredients"`
-		DateCreated  time.Time      `json:"date_created"`
-		DateModified time.Time      `json:"date_modified"`
-		Enabled      bool           `json:"enabled"`
+	conn, err := pgx.Connect(context.Background(), postgresURL)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to database: %v", err)
+	}
+	defer func() {
+		if err := conn.Close(context.Background()); err != nil {
+			fmt.Printf("error closing connection: %v\n", err)
+		}
+	}()
+
+	mealCollection, err := readRecipes(conn, recipeCreatedCutoff)
+	if err != nil {
+		return nil, err
 	}
 
+	catalog, err := readIngredientCatalog(conn)
+	if err != nil {
+		return nil, err
+	}
+	mealCollection = mealCollection.ApplyCatalog(catalog)
+
+	// Sort meals by name
+	sort.Slice(mealCollection, func(i, j int) bool {
+		return strings.ToLower(mealCollection[i].Name) < strings.ToLower(mealCollection[j].Name)
+	})
+
+	return mealCollection, nil
+}
+
+// ExportRecipesFromDB encodes the recipes and ingredient catalog as stored, without the
+// catalog applied, as a recipe file. The result is checked to be accepted by
+// ReadMealCollectionFromReader.
+func ExportRecipesFromDB(postgresURL string) ([]byte, error) {
 	if postgresURL == "" {
 		return nil, fmt.Errorf("POSTGRES_URL is not set")
 	}
@@ -45,8 +67,70 @@ func ReadMealCollectionFromDB(postgresURL string, recipeCreatedCutoff int64) (Me
 		}
 	}()
 
+	recipes, err := readRecipes(conn, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+
+	catalog, err := readIngredientCatalog(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range recipes {
+		// The recipes table stores a missing URL as an empty one
+		if recipes[i].URL != nil && *recipes[i].URL == "" {
+			recipes[i].URL = nil
+		}
+	}
+	sort.Slice(recipes, func(i, j int) bool {
+		return strings.ToLower(recipes[i].Name) < strings.ToLower(recipes[j].Name)
+	})
+
+	data, err := RecipeFile{Recipes: recipes, Ingredients: catalog}.Marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := ReadMealCollectionFromReader(io.NopCloser(bytes.NewReader(data))); err != nil {
+		return nil, fmt.Errorf("exported recipes would not be accepted: %v", err)
+	}
+
+	return data, nil
+}
+
+// readRecipes reads the recipes created before recipeCreatedCutoff, as stored.
+func readRecipes(conn *pgx.Conn, recipeCreatedCutoff int64) (MealCollection, error) {
+	// Temporary types just for DB scans and JSON unmarshaling.
+	type DBIngredient struct {
+		Item     string  `json:"item"`
+		Quantity float64 `json:"quantity"`
+		Unit     string  `json:"unit"`
+		Aisle    string  `json:"aisle"`
+		Raw      string  `json:"raw"`
+	}
+
+	type DBRecipe struct {
+		ID            int            `json:"id"`
+		Category      string         `json:"category"`
+		Name          string         `json:"name"`
+		URL           string         `json:"url"`
+		Ingredients   []DBIngredient `json:"ingredients"`
+		DateCreated   time.Time      `json:"date_created"`
+		DateModified  time.Time      `json:"date_modified"`
+		Enabled       bool           `json:"enabled"`
+		Servings      int            `json:"servings"`
+		AvailableFrom string         `json:"available_from"`
+		AvailableTo   string         `json:"available_to"`
+		Tags          []string       `json:"tags"`
+		PrepMinutes   int            `json:"prep_minutes"`
+		ActiveMinutes int            `json:"active_minutes"`
+		Yield         int            `json:"yield"`
+	}
+
 	rows, err := conn.Query(context.Background(), `
-		SELECT id, category, name, url, ingredients, date_created, date_modified, enabled
+		SELECT id, category, name, url, ingredients, date_created, date_modified, enabled, servings,
+		       available_from, available_to, tags, prep_minutes, active_minutes, yield
 		FROM recipes
 		WHERE date_created < to_timestamp($1)
 	`, recipeCreatedCutoff)
@@ -71,6 +155,13 @@ func ReadMealCollectionFromDB(postgresURL string, recipeCreatedCutoff int64) (Me
 			&r.DateCreated,
 			&r.DateModified,
 			&r.Enabled,
+			&r.Servings,
+			&r.AvailableFrom,
+			&r.AvailableTo,
+			&r.Tags,
+			&r.PrepMinutes,
+			&r.ActiveMinutes,
+			&r.Yield,
 		); err != nil {
 			return nil, fmt.Errorf("scan failed: %v", err)
 		}
@@ -93,28 +184,80 @@ func ReadMealCollectionFromDB(postgresURL string, recipeCreatedCutoff int64) (Me
 				Quantity: dbIng.Quantity,
 				Unit:     Unit(dbIng.Unit),
 				Aisle:    Aisle(dbIng.Aisle),
+				Raw:      dbIng.Raw,
 			})
 		}
 
 		meal := Meal{
-			Name:        recipe.Name,
-			URL:         &recipe.URL,
-			Ingredients: ingredients,
-			Disabled:    !recipe.Enabled,
-			Category:    &recipe.Category,
+			Name:          recipe.Name,
+			URL:           &recipe.URL,
+			Ingredients:   ingredients,
+			Disabled:      !recipe.Enabled,
+			Category:      &recipe.Category,
+			Servings:      recipe.Servings,
+			AvailableFrom: MonthDay(recipe.AvailableFrom),
+			AvailableTo:   MonthDay(recipe.AvailableTo),
+			Tags:          recipe.Tags,
+			PrepMinutes:   recipe.PrepMinutes,
+			ActiveMinutes: recipe.ActiveMinutes,
+			Yield:         recipe.Yield,
 		}
 
 		mealCollection = append(mealCollection, meal)
 	}
 
-	// Sort meals by name
-	sort.Slice(mealCollection, func(i, j int) bool {
-		return strings.ToLower(mealCollection[i].Name) < strings.ToLower(mealCollection[j].Name)
-	})
-
 	return mealCollection, nil
 }
 
+// ReadIngredientCatalogFromDB returns the ingredient catalog, sorted by name.
+func ReadIngredientCatalogFromDB(postgresURL string) (IngredientCatalog, error) {
+	if postgresURL == "" {
+		return nil, fmt.Errorf("POSTGRES_URL is not set")
+	}
+
+	conn, err := pgx.Connect(context.Background(), postgresURL)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to database: %v", err)
+	}
+	defer func() {
+		if err := conn.Close(context.Background()); err != nil {
+			fmt.Printf("error closing connection: %v\n", err)
+		}
+	}()
+
+	return readIngredientCatalog(conn)
+}
+
+func readIngredientCatalog(conn *pgx.Conn) (IngredientCatalog, error) {
+	rows, err := conn.Query(context.Background(), `
+		SELECT name, aliases, aisle
+		FROM ingredient_catalog
+		ORDER BY name
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %v", err)
+	}
+	defer rows.Close()
+
+	var catalog IngredientCatalog
+	for rows.Next() {
+		var (
+			entry CatalogIngredient
+			aisle string
+		)
+		if err := rows.Scan(&entry.Name, &entry.Aliases, &aisle); err != nil {
+			return nil, fmt.Errorf("scan failed: %v", err)
+		}
+		entry.Aisle = Aisle(aisle)
+		catalog = append(catalog, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %v", err)
+	}
+
+	return catalog, nil
+}
+
 type MealUpdate struct {
 	Name     string `json:"name"`
 	Disabled bool   `json:"disabled"`
@@ -260,11 +403,35 @@ type FEExtraItem struct {
 	New    FEItem `json:"New"`
 }
 
+// ValidateExtraItemUpdates checks that every added or updated item has a name and a
+// valid aisle.
+func ValidateExtraItemUpdates(updates []FEExtraItem) error {
+	for _, update := range updates {
+		if update.Action != Add && update.Action != Update {
+			continue
+		}
+		if update.New.Name == "" {
+			return errors.New("item name cannot be empty")
+		}
+		if update.New.Aisle == "" {
+			return fmt.Errorf("aisle of item '%s' cannot be empty", update.New.Name)
+		}
+		if err := update.New.Aisle.IsValid(); err != nil {
+			return fmt.Errorf("item '%s': %v", update.New.Name, err)
+		}
+	}
+	return nil
+}
+
 func UpdateExtraItemsInDB(postgresURL string, updates []FEExtraItem) error {
 	if len(updates) == 0 {
 		return nil
 	}
 
+	if err := ValidateExtraItemUpdates(updates); err != nil {
+		return fmt.Errorf("validation error: %v", err)
+	}
+
 	if postgresURL == "" {
 		return fmt.Errorf("POSTGRES_URL is not set")
 	}
@@ -313,3 +480,819 @@ func UpdateExtraItemsInDB(postgresURL string, updates []FEExtraItem) error {
 
 	return nil
 }
+
+// Recipes synced from the recipe file and recipes edited through the API are told apart
+// by the source column, so that meal_db_sync never overwrites or deletes API edits.
+// Recipes deleted or renamed through the API leave their old name in deleted_recipes,
+// so that meal_db_sync doesn't bring them back either.
+const (
+	RECIPE_SOURCE_SYNC = "sync"
+	RECIPE_SOURCE_UI   = "ui"
+)
+
+var (
+	ErrRecipeExists   = errors.New("recipe already exists")
+	ErrRecipeNotFound = errors.New("recipe not found")
+)
+
+// uniqueViolation is the Postgres error code for a unique constraint violation.
+const uniqueViolation = "23505"
+
+// isUniqueViolation reports whether err is from violating a unique constraint.
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == uniqueViolation
+}
+
+// CreateRecipeInDB validates meal and inserts it as a UI-edited recipe.
+func CreateRecipeInDB(postgresURL string, meal Meal) error {
+	return writeRecipeInDB(postgresURL, meal, func(tx pgx.Tx, ingJSON []byte, category string, url string) error {
+		res, err := tx.Exec(context.Background(), `
+			INSERT INTO recipes (name, category, url, ingredients, servings, available_from, available_to, tags,
+			                     prep_minutes, active_minutes, yield, enabled, source)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, COALESCE($8::text[], '{}'), $9, $10, $11, $12, $13)
+			ON CONFLICT (name) DO NOTHING
+		`, meal.Name, category, url, ingJSON, meal.Servings, meal.AvailableFrom, meal.AvailableTo, meal.Tags,
+			meal.PrepMinutes, meal.ActiveMinutes, meal.Yield, !meal.Disabled, RECIPE_SOURCE_UI)
+		if err != nil {
+			return err
+		}
+		if res.RowsAffected() == 0 {
+			return fmt.Errorf("%w: %s", ErrRecipeExists, meal.Name)
+		}
+		return undeleteRecipe(tx, meal.Name)
+	})
+}
+
+// UpdateRecipeInDB validates meal and replaces the recipe named name with it, marking
+// the recipe as UI-edited. Renaming it onto another recipe fails with ErrRecipeExists.
+func UpdateRecipeInDB(postgresURL string, name string, meal Meal) error {
+	return writeRecipeInDB(postgresURL, meal, func(tx pgx.Tx, ingJSON []byte, category string, url string) error {
+		res, err := tx.Exec(context.Background(), `
+			UPDATE recipes
+			SET name = $1, category = $2, url = $3, ingredients = $4, servings = $5,
+			    available_from = $6, available_to = $7, tags = COALESCE($8::text[], '{}'),
+			    prep_minutes = $9, active_minutes = $10, yield = $11, enabled = $12, source = $13,
+			    date_modified = now()
+			WHERE name = $14
+		`, meal.Name, category, url, ingJSON, meal.Servings, meal.AvailableFrom, meal.AvailableTo, meal.Tags,
+			meal.PrepMinutes, meal.ActiveMinutes, meal.Yield, !meal.Disabled, RECIPE_SOURCE_UI, name)
+		if isUniqueViolation(err) {
+			return fmt.Errorf("%w: %s", ErrRecipeExists, meal.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if res.RowsAffected() == 0 {
+			return fmt.Errorf("%w: %s", ErrRecipeNotFound, name)
+		}
+		if name == meal.Name {
+			return nil
+		}
+
+		// The old name may still be in the recipe file
+		if err := markRecipeDeleted(tx, name); err != nil {
+			return err
+		}
+		return undeleteRecipe(tx, meal.Name)
+	})
+}
+
+// markRecipeDeleted records that the recipe named name was deleted through the API.
+func markRecipeDeleted(tx pgx.Tx, name string) error {
+	_, err := tx.Exec(context.Background(), `
+		INSERT INTO deleted_recipes (name)
+		VALUES ($1)
+		ON CONFLICT (name) DO NOTHING
+	`, name)
+	return err
+}
+
+// undeleteRecipe forgets that a recipe named name was deleted, as it's been created again.
+func undeleteRecipe(tx pgx.Tx, name string) error {
+	_, err := tx.Exec(context.Background(), `
+		DELETE FROM deleted_recipes
+		WHERE name = $1
+	`, name)
+	return err
+}
+
+// writeRecipeInDB validates meal against the stored ingredient catalog, then runs write
+// in a transaction.
+func writeRecipeInDB(postgresURL string, meal Meal, write func(tx pgx.Tx, ingJSON []byte, category string, url string) error) error {
+	if postgresURL == "" {
+		return fmt.Errorf("POSTGRES_URL is not set")
+	}
+
+	conn, err := pgx.Connect(context.Background(), postgresURL)
+	if err != nil {
+		return fmt.Errorf("unable to connect to database: %v", err)
+	}
+	defer func() {
+		if err := conn.Close(context.Background()); err != nil {
+			fmt.Printf("error closing connection: %v\n", err)
+		}
+	}()
+
+	catalog, err := readIngredientCatalog(conn)
+	if err != nil {
+		return err
+	}
+	if err := ValidateRecipe(meal, catalog); err != nil {
+		return fmt.Errorf("validation error: %v", err)
+	}
+
+	ingJSON, err := json.Marshal(meal.Ingredients)
+	if err != nil {
+		return fmt.Errorf("error marshaling ingredients: %w", err)
+	}
+
+	var category, url string
+	if meal.Category != nil {
+		category = *meal.Category
+	}
+	if meal.URL != nil {
+		url = *meal.URL
+	}
+
+	tx, err := conn.Begin(context.Background())
+	if err != nil {
+		return fmt.Errorf("unable to begin transaction: %v", err)
+	}
+	defer func() {
+		_ = tx.Rollback(context.Background())
+	}()
+
+	if err := write(tx, ingJSON, category, url); err != nil {
+		if errors.Is(err, ErrRecipeExists) || errors.Is(err, ErrRecipeNotFound) {
+			return err
+		}
+		return fmt.Errorf("query failed: %v", err)
+	}
+
+	if err := tx.Commit(context.Background()); err != nil {
+		return fmt.Errorf("unable to commit transaction: %v", err)
+	}
+
+	return nil
+}
+
+// DeleteRecipeFromDB deletes the recipe named name. It's recorded as deleted, so a synced
+// recipe that is still in the recipe file doesn't come back on the next sync.
+func DeleteRecipeFromDB(postgresURL string, name string) error {
+	if postgresURL == "" {
+		return fmt.Errorf("POSTGRES_URL is not set")
+	}
+
+	conn, err := pgx.Connect(context.Background(), postgresURL)
+	if err != nil {
+		return fmt.Errorf("unable to connect to database: %v", err)
+	}
+	defer func() {
+		if err := conn.Close(context.Background()); err != nil {
+			fmt.Printf("error closing connection: %v\n", err)
+		}
+	}()
+
+	tx, err := conn.Begin(context.Background())
+	if err != nil {
+		return fmt.Errorf("unable to begin transaction: %v", err)
+	}
+	defer func() {
+		_ = tx.Rollback(context.Background())
+	}()
+
+	res, err := tx.Exec(context.Background(), `
+		DELETE FROM recipes
+		WHERE name = $1
+	`, name)
+	if err != nil {
+		return fmt.Errorf("query failed: %v", err)
+	}
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("%w: %s", ErrRecipeNotFound, name)
+	}
+	if err := markRecipeDeleted(tx, name); err != nil {
+		return fmt.Errorf("query failed: %v", err)
+	}
+
+	if err := tx.Commit(context.Background()); err != nil {
+		return fmt.Errorf("unable to commit transaction: %v", err)
+	}
+
+	return nil
+}
+
+// ReadPantryFromDB returns every pantry item, sorted by name.
+func ReadPantryFromDB(postgresURL string) ([]PantryItem, error) {
+	if postgresURL == "" {
+		return nil, fmt.Errorf("POSTGRES_URL is not set")
+	}
+
+	conn, err := pgx.Connect(context.Background(), postgresURL)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to database: %v", err)
+	}
+	defer func() {
+		if err := conn.Close(context.Background()); err != nil {
+			fmt.Printf("error closing connection: %v\n", err)
+		}
+	}()
+
+	rows, err := conn.Query(context.Background(), `
+		SELECT id, name, quantity, unit, staple
+		FROM pantry
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %v", err)
+	}
+	defer rows.Close()
+
+	var items []PantryItem
+	for rows.Next() {
+		var p PantryItem
+		if err := rows.Scan(&p.ID, &p.Name, &p.Quantity, &p.Unit, &p.Staple); err != nil {
+			return nil, fmt.Errorf("scan failed: %v", err)
+		}
+		items = append(items, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %v", err)
+	}
+
+	// Sort items by name
+	sort.Slice(items, func(i, j int) bool {
+		return strings.ToLower(items[i].Name) < strings.ToLower(items[j].Name)
+	})
+
+	return items, nil
+}
+
+type FEPantryItem struct {
+	Action Action     `json:"Action"`
+	Old    PantryItem `json:"Old"`
+	New    PantryItem `json:"New"`
+}
+
+// ValidatePantryUpdates checks every added or updated pantry item.
+func ValidatePantryUpdates(updates []FEPantryItem) error {
+	for _, update := range updates {
+		if update.Action != Add && update.Action != Update {
+			continue
+		}
+		if err := update.New.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func UpdatePantryInDB(postgresURL string, updates []FEPantryItem) error {
+	if len(updates) == 0 {
+		return nil
+	}
+
+	if err := ValidatePantryUpdates(updates); err != nil {
+		return fmt.Errorf("validation error: %v", err)
+	}
+
+	if postgresURL == "" {
+		return fmt.Errorf("POSTGRES_URL is not set")
+	}
+
+	conn, err := pgx.Connect(context.Background(), postgresURL)
+	if err != nil {
+		return fmt.Errorf("unable to connect to database: %v", err)
+	}
+	defer func() {
+		if err := conn.Close(context.Background()); err != nil {
+			fmt.Printf("error closing connection: %v\n", err)
+		}
+	}()
+
+	for _, update := range updates {
+		switch update.Action {
+		case Add:
+			_, err = conn.Exec(context.Background(), `
+				INSERT INTO pantry (name, quantity, unit, staple)
+				VALUES ($1, $2, $3, $4)
+			`, update.New.Name, update.New.Quantity, update.New.Unit, update.New.Staple)
+			if err != nil {
+				return fmt.Errorf("query failed: %v", err)
+			}
+		case Update:
+			_, err = conn.Exec(context.Background(), `
+				UPDATE pantry
+				SET name = $1, quantity = $2, unit = $3, staple = $4, date_modified = now()
+				WHERE name = $5
+			`, update.New.Name, update.New.Quantity, update.New.Unit, update.New.Staple, update.Old.Name)
+			if err != nil {
+				return fmt.Errorf("query failed: %v", err)
+			}
+		case Delete:
+			_, err = conn.Exec(context.Background(), `
+				DELETE FROM pantry
+				WHERE name = $1
+			`, update.Old.Name)
+			if err != nil {
+				return fmt.Errorf("query failed: %v", err)
+			}
+		default:
+			return fmt.Errorf("unknown action: %s", update.Action)
+		}
+	}
+
+	return nil
+}
+
+// ReadIngredientInfoFromDB returns the price and nutrition data of every ingredient.
+func ReadIngredientInfoFromDB(postgresURL string) (IngredientInfos, error) {
+	if postgresURL == "" {
+		return nil, fmt.Errorf("POSTGRES_URL is not set")
+	}
+
+	conn, err := pgx.Connect(context.Background(), postgresURL)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to database: %v", err)
+	}
+	defer func() {
+		if err := conn.Close(context.Background()); err != nil {
+			fmt.Printf("error closing connection: %v\n", err)
+		}
+	}()
+
+	rows, err := conn.Query(context.Background(), `
+		SELECT name, unit, price, calories, protein
+		FROM ingredient_info
+		ORDER BY lower(name), unit
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %v", err)
+	}
+	defer rows.Close()
+
+	var infos IngredientInfos
+	for rows.Next() {
+		var i IngredientInfo
+		if err := rows.Scan(&i.Name, &i.Unit, &i.Price, &i.Calories, &i.Protein); err != nil {
+			return nil, fmt.Errorf("scan failed: %v", err)
+		}
+		infos = append(infos, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %v", err)
+	}
+
+	return infos, nil
+}
+
+type FEIngredientInfo struct {
+	Action Action         `json:"Action"`
+	Old    IngredientInfo `json:"Old"`
+	New    IngredientInfo `json:"New"`
+}
+
+// ValidateIngredientInfoUpdates checks every added or updated ingredient info entry.
+func ValidateIngredientInfoUpdates(updates []FEIngredientInfo) error {
+	for _, update := range updates {
+		if update.Action != Add && update.Action != Update {
+			continue
+		}
+		if err := update.New.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func UpdateIngredientInfoInDB(postgresURL string, updates []FEIngredientInfo) error {
+	if len(updates) == 0 {
+		return nil
+	}
+
+	if err := ValidateIngredientInfoUpdates(updates); err != nil {
+		return fmt.Errorf("validation error: %v", err)
+	}
+
+	if postgresURL == "" {
+		return fmt.Errorf("POSTGRES_URL is not set")
+	}
+
+	conn, err := pgx.Connect(context.Background(), postgresURL)
+	if err != nil {
+		return fmt.Errorf("unable to connect to database: %v", err)
+	}
+	defer func() {
+		if err := conn.Close(context.Background()); err != nil {
+			fmt.Printf("error closing connection: %v\n", err)
+		}
+	}()
+
+	for _, update := range updates {
+		switch update.Action {
+		case Add:
+			_, err = conn.Exec(context.Background(), `
+				INSERT INTO ingredient_info (name, unit, price, calories, protein)
+				VALUES ($1, $2, $3, $4, $5)
+			`, update.New.Name, update.New.Unit, update.New.Price, update.New.Calories, update.New.Protein)
+			if err != nil {
+				return fmt.Errorf("query failed: %v", err)
+			}
+		case Update:
+			_, err = conn.Exec(context.Background(), `
+				UPDATE ingredient_info
+				SET name = $1, unit = $2, price = $3, calories = $4, protein = $5, date_modified = now()
+				WHERE name = $6 AND unit = $7
+			`, update.New.Name, update.New.Unit, update.New.Price, update.New.Calories, update.New.Protein, update.Old.Name, update.Old.Unit)
+			if err != nil {
+				return fmt.Errorf("query failed: %v", err)
+			}
+		case Delete:
+			_, err = conn.Exec(context.Background(), `
+				DELETE FROM ingredient_info
+				WHERE name = $1 AND unit = $2
+			`, update.Old.Name, update.Old.Unit)
+			if err != nil {
+				return fmt.Errorf("query failed: %v", err)
+			}
+		default:
+			return fmt.Errorf("unknown action: %s", update.Action)
+		}
+	}
+
+	return nil
+}
+
+// ReadMealPlanFromDB returns the meals pinned between start and end, inclusive.
+func ReadMealPlanFromDB(postgresURL string, start time.Time, end time.Time) ([]PlannedMeal, error) {
+	return readDatedMealsFromDB(postgresURL, "meal_plan", true, start, end)
+}
+
+// PinMealsInDB pins each meal to its date, replacing any meal already pinned there.
+// All meals are pinned in a single transaction so a swap is never half applied.
+func PinMealsInDB(postgresURL string, plan []PlannedMeal) error {
+	return upsertDatedMealsInDB(postgresURL, "meal_plan", true, plan)
+}
+
+// ReadMealSnapshotFromDB returns the snapshotted meals between start and end, inclusive.
+func ReadMealSnapshotFromDB(postgresURL string, start time.Time, end time.Time) ([]PlannedMeal, error) {
+	return readDatedMealsFromDB(postgresURL, "meal_snapshot", false, start, end)
+}
+
+// SnapshotMealsInDB freezes each date to its meal, replacing any meal already
+// snapshotted there.
+func SnapshotMealsInDB(postgresURL string, snapshot []PlannedMeal) error {
+	return upsertDatedMealsInDB(postgresURL, "meal_snapshot", false, snapshot)
+}
+
+// LoadScheduleFromDB reads the stored state covering dates. The snapshot is only read
+// when opts.Stable is set. It only reads, see SnapshotScheduleInDB and SnapshotMealsInDB
+// for updating the snapshot.
+func LoadScheduleFromDB(postgresURL string, dates []time.Time, opts GenerateOptions) (Schedule, error) {
+	if len(dates) == 0 {
+		return Schedule{}, nil
+	}
+
+	// MealsForDates links leftovers over the wider window, so it needs its plan too
+	window := leftoverWindow(dates)
+	start, end := window[0], window[len(window)-1]
+
+	var schedule Schedule
+	plan, err := ReadMealPlanFromDB(postgresURL, start, end)
+	if err != nil {
+		return Schedule{}, fmt.Errorf("failed to read meal plan: %v", err)
+	}
+	schedule.Plan = plan
+
+	if opts.Mode == GenerationModeRated {
+		history, err := ReadMealHistoryFromDB(postgresURL)
+		if err != nil {
+			return Schedule{}, fmt.Errorf("failed to read meal history: %v", err)
+		}
+		schedule.History = history
+	}
+
+	if opts.Stable {
+		snapshot, err := ReadMealSnapshotFromDB(postgresURL, start, end)
+		if err != nil {
+			return Schedule{}, fmt.Errorf("failed to read meal snapshot: %v", err)
+		}
+		schedule.Snapshot = snapshot
+	}
+
+	return schedule, nil
+}
+
+// SnapshotScheduleInDB freezes the meals generated for dates that aren't snapshotted yet,
+// returning schedule, as loaded by LoadScheduleFromDB for dates, with the snapshot
+// updated. Dates already snapshotted keep their meal.
+func SnapshotScheduleInDB(postgresURL string, collection MealCollection, dates []time.Time, opts GenerateOptions, schedule Schedule) (Schedule, error) {
+	if len(dates) == 0 {
+		return schedule, nil
+	}
+
+	meals := collection.MealsForDates(dates, opts, schedule)
+	updates := schedule.SnapshotUpdates(dates, meals)
+	if err := SnapshotMealsInDB(postgresURL, updates); err != nil {
+		return Schedule{}, fmt.Errorf("failed to snapshot meals: %v", err)
+	}
+	schedule.Snapshot = append(schedule.Snapshot, updates...)
+
+	return schedule, nil
+}
+
+// datedMealColumns returns the columns of a (date, meal) table, which also has a
+// servings column when servings is set.
+func datedMealColumns(servings bool) string {
+	if servings {
+		return "date, meal, servings"
+	}
+	return "date, meal"
+}
+
+// readDatedMealsFromDB returns the rows of a (date, meal) table between start and end,
+// inclusive. servings says whether the table has a servings column.
+func readDatedMealsFromDB(postgresURL string, table string, servings bool, start time.Time, end time.Time) ([]PlannedMeal, error) {
+	if postgresURL == "" {
+		return nil, fmt.Errorf("POSTGRES_URL is not set")
+	}
+
+	conn, err := pgx.Connect(context.Background(), postgresURL)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to database: %v", err)
+	}
+	defer func() {
+		if err := conn.Close(context.Background()); err != nil {
+			fmt.Printf("error closing connection: %v\n", err)
+		}
+	}()
+
+	rows, err := conn.Query(context.Background(), fmt.Sprintf(`
+		SELECT %s
+		FROM %s
+		WHERE date BETWEEN $1 AND $2
+		ORDER BY date
+	`, datedMealColumns(servings), table), start, end)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %v", err)
+	}
+	defer rows.Close()
+
+	var meals []PlannedMeal
+	for rows.Next() {
+		var p PlannedMeal
+		dest := []any{&p.Date, &p.Meal}
+		if servings {
+			dest = append(dest, &p.Servings)
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("scan failed: %v", err)
+		}
+		meals = append(meals, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %v", err)
+	}
+
+	return meals, nil
+}
+
+// upsertDatedMealsInDB writes the rows of a (date, meal) table in a single transaction.
+// servings says whether the table has a servings column.
+func upsertDatedMealsInDB(postgresURL string, table string, servings bool, meals []PlannedMeal) error {
+	if len(meals) == 0 {
+		return nil
+	}
+
+	if postgresURL == "" {
+		return fmt.Errorf("POSTGRES_URL is not set")
+	}
+
+	conn, err := pgx.Connect(context.Background(), postgresURL)
+	if err != nil {
+		return fmt.Errorf("unable to connect to database: %v", err)
+	}
+	defer func() {
+		if err := conn.Close(context.Background()); err != nil {
+			fmt.Printf("error closing connection: %v\n", err)
+		}
+	}()
+
+	tx, err := conn.Begin(context.Background())
+	if err != nil {
+		return fmt.Errorf("unable to begin transaction: %v", err)
+	}
+	defer func() {
+		_ = tx.Rollback(context.Background())
+	}()
+
+	query := fmt.Sprintf(`
+		INSERT INTO %[1]s (date, meal)
+		VALUES ($1, $2)
+		ON CONFLICT (date) DO UPDATE
+		  SET meal          = EXCLUDED.meal,
+		      date_modified = now()
+		  WHERE %[1]s.meal IS DISTINCT FROM EXCLUDED.meal
+	`, table)
+	if servings {
+		query = fmt.Sprintf(`
+			INSERT INTO %[1]s (date, meal, servings)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (date) DO UPDATE
+			  SET meal          = EXCLUDED.meal,
+			      servings      = EXCLUDED.servings,
+			      date_modified = now()
+			  WHERE (%[1]s.meal, %[1]s.servings) IS DISTINCT FROM (EXCLUDED.meal, EXCLUDED.servings)
+		`, table)
+	}
+
+	for _, planned := range meals {
+		args := []any{planned.Date, planned.Meal}
+		if servings {
+			args = append(args, planned.Servings)
+		}
+		_, err = tx.Exec(context.Background(), query, args...)
+		if err != nil {
+			return fmt.Errorf("query failed: %v", err)
+		}
+	}
+
+	if err := tx.Commit(context.Background()); err != nil {
+		return fmt.Errorf("unable to commit transaction: %v", err)
+	}
+
+	return nil
+}
+
+// ClearMealPlanInDB removes the pinned meal of each date, if any.
+func ClearMealPlanInDB(postgresURL string, dates []time.Time) error {
+	if len(dates) == 0 {
+		return nil
+	}
+
+	if postgresURL == "" {
+		return fmt.Errorf("POSTGRES_URL is not set")
+	}
+
+	conn, err := pgx.Connect(context.Background(), postgresURL)
+	if err != nil {
+		return fmt.Errorf("unable to connect to database: %v", err)
+	}
+	defer func() {
+		if err := conn.Close(context.Background()); err != nil {
+			fmt.Printf("error closing connection: %v\n", err)
+		}
+	}()
+
+	_, err = conn.Exec(context.Background(), `
+		DELETE FROM meal_plan
+		WHERE date = ANY($1::date[])
+	`, dates)
+	if err != nil {
+		return fmt.Errorf("query failed: %v", err)
+	}
+
+	return nil
+}
+
+// ErrHistoryNotFound is returned when updating or deleting a history entry that doesn't exist.
+var ErrHistoryNotFound = errors.New("history entry not found")
+
+// ReadMealHistoryFromDB returns every meal history entry, oldest first.
+func ReadMealHistoryFromDB(postgresURL string) ([]HistoryEntry, error) {
+	if postgresURL == "" {
+		return nil, fmt.Errorf("POSTGRES_URL is not set")
+	}
+
+	conn, err := pgx.Connect(context.Background(), postgresURL)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to database: %v", err)
+	}
+	defer func() {
+		if err := conn.Close(context.Background()); err != nil {
+			fmt.Printf("error closing connection: %v\n", err)
+		}
+	}()
+
+	rows, err := conn.Query(context.Background(), `
+		SELECT id, date, meal, status, rating, notes
+		FROM meal_history
+		ORDER BY date, id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %v", err)
+	}
+	defer rows.Close()
+
+	var history []HistoryEntry
+	for rows.Next() {
+		var h HistoryEntry
+		if err := rows.Scan(&h.ID, &h.Date, &h.Meal, &h.Status, &h.Rating, &h.Notes); err != nil {
+			return nil, fmt.Errorf("scan failed: %v", err)
+		}
+		history = append(history, h)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %v", err)
+	}
+
+	return history, nil
+}
+
+// AddMealHistoryInDB records a history entry, returning its ID.
+func AddMealHistoryInDB(postgresURL string, entry HistoryEntry) (int, error) {
+	if err := entry.Validate(); err != nil {
+		return 0, fmt.Errorf("validation error: %v", err)
+	}
+
+	if postgresURL == "" {
+		return 0, fmt.Errorf("POSTGRES_URL is not set")
+	}
+
+	conn, err := pgx.Connect(context.Background(), postgresURL)
+	if err != nil {
+		return 0, fmt.Errorf("unable to connect to database: %v", err)
+	}
+	defer func() {
+		if err := conn.Close(context.Background()); err != nil {
+			fmt.Printf("error closing connection: %v\n", err)
+		}
+	}()
+
+	var id int
+	err = conn.QueryRow(context.Background(), `
+		INSERT INTO meal_history (date, meal, status, rating, notes)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id
+	`, entry.Date, entry.Meal, entry.Status, entry.Rating, entry.Notes).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("query failed: %v", err)
+	}
+
+	return id, nil
+}
+
+// UpdateMealHistoryInDB replaces the history entry with entry.ID.
+func UpdateMealHistoryInDB(postgresURL string, entry HistoryEntry) error {
+	if err := entry.Validate(); err != nil {
+		return fmt.Errorf("validation error: %v", err)
+	}
+
+	if postgresURL == "" {
+		return fmt.Errorf("POSTGRES_URL is not set")
+	}
+
+	conn, err := pgx.Connect(context.Background(), postgresURL)
+	if err != nil {
+		return fmt.Errorf("unable to connect to database: %v", err)
+	}
+	defer func() {
+		if err := conn.Close(context.Background()); err != nil {
+			fmt.Printf("error closing connection: %v\n", err)
+		}
+	}()
+
+	res, err := conn.Exec(context.Background(), `
+		UPDATE meal_history
+		SET date = $1, meal = $2, status = $3, rating = $4, notes = $5, date_modified = now()
+		WHERE id = $6
+	`, entry.Date, entry.Meal, entry.Status, entry.Rating, entry.Notes, entry.ID)
+	if err != nil {
+		return fmt.Errorf("query failed: %v", err)
+	}
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("%w: %d", ErrHistoryNotFound, entry.ID)
+	}
+
+	return nil
+}
+
+// DeleteMealHistoryFromDB deletes the history entry with id.
+func DeleteMealHistoryFromDB(postgresURL string, id int) error {
+	if postgresURL == "" {
+		return fmt.Errorf("POSTGRES_URL is not set")
+	}
+
+	conn, err := pgx.Connect(context.Background(), postgresURL)
+	if err != nil {
+		return fmt.Errorf("unable to connect to database: %v", err)
+	}
+	defer func() {
+		if err := conn.Close(context.Background()); err != nil {
+			fmt.Printf("error closing connection: %v\n", err)
+		}
+	}()
+
+	res, err := conn.Exec(context.Background(), `
+		DELETE FROM meal_history
+		WHERE id = $1
+	`, id)
+	if err != nil {
+		return fmt.Errorf("query failed: %v", err)
+	}
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("%w: %d", ErrHistoryNotFound, id)
+	}
+
+	return nil
+}
@@ -0,0 +1,175 @@
+package meal_collection
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// unicodeFractions maps the vulgar fraction characters found in recipes to plain fractions.
+var unicodeFractions = map[rune]string{
+	'½': "1/2", '⅓': "1/3", '⅔': "2/3", '¼': "1/4", '¾': "3/4",
+	'⅕': "1/5", '⅖': "2/5", '⅗': "3/5", '⅘': "4/5", '⅙': "1/6",
+	'⅚': "5/6", '⅛': "1/8", '⅜': "3/8", '⅝': "5/8", '⅞': "7/8",
+}
+
+// unitSynonyms maps the spellings of units found in recipes to a Unit.
+var unitSynonyms = map[string]Unit{
+	"g": UnitGram, "gram": UnitGram, "grams": UnitGram,
+	"lb": UnitLb, "lbs": UnitLb, "pound": UnitLb, "pounds": UnitLb,
+	"oz": UnitOz, "ounce": UnitOz, "ounces": UnitOz,
+	"cup": UnitCup, "cups": UnitCup,
+	"tbsp": UnitTbsp, "tbsps": UnitTbsp, "tbs": UnitTbsp, "tablespoon": UnitTbsp, "tablespoons": UnitTbsp,
+	"tsp": UnitTsp, "tsps": UnitTsp, "teaspoon": UnitTsp, "teaspoons": UnitTsp,
+	"count": UnitCount,
+}
+
+// containerWords are dropped after a package size, e.g. "cans" in "2 (15 oz) cans beans".
+var containerWords = map[string]bool{
+	"can": true, "cans": true, "jar": true, "jars": true, "bag": true, "bags": true,
+	"box": true, "boxes": true, "bottle": true, "bottles": true, "carton": true, "cartons": true,
+	"package": true, "packages": true, "pkg": true, "container": true, "containers": true,
+}
+
+var (
+	// quantityLessSuffix matches notes like "to taste" that replace a quantity.
+	quantityLessSuffix = regexp.MustCompile(`(?i)[,\s]*(\(?\s*(or\s+)?(to taste|as needed|optional|for serving|for garnish)\s*\)?)$`)
+	// packageSize matches a leading parenthetical size, e.g. "(15 oz)".
+	packageSize = regexp.MustCompile(`^\(([^)]*)\)\s*`)
+)
+
+// expandUnicodeFractions rewrites vulgar fractions as plain ones, so "1½" becomes "1 1/2".
+func expandUnicodeFractions(line string) string {
+	var sb strings.Builder
+	var previous rune
+	for _, r := range line {
+		switch fraction, ok := unicodeFractions[r]; {
+		case ok:
+			if unicode.IsDigit(previous) {
+				sb.WriteRune(' ')
+			}
+			sb.WriteString(fraction)
+		case r == '⁄':
+			sb.WriteRune('/')
+		default:
+			sb.WriteRune(r)
+		}
+		previous = r
+	}
+	return sb.String()
+}
+
+// parseQuantity parses a quantity written as a number, e.g. "2" or "0.5", or as a
+// fraction, e.g. "1/2".
+func parseQuantity(token string) (float64, bool) {
+	if numerator, denominator, found := strings.Cut(token, "/"); found {
+		n, err := strconv.ParseFloat(numerator, 64)
+		if err != nil {
+			return 0, false
+		}
+		d, err := strconv.ParseFloat(denominator, 64)
+		if err != nil || d == 0 {
+			return 0, false
+		}
+		return n / d, true
+	}
+
+	q, err := strconv.ParseFloat(token, 64)
+	if err != nil {
+		return 0, false
+	}
+	return q, true
+}
+
+// parseLeadingQuantity parses the quantity at the start of fields, including mixed
+// numbers like "1 1/2", and returns the remaining fields.
+func parseLeadingQuantity(fields []string) (float64, []string, bool) {
+	if len(fields) == 0 {
+		return 0, fields, false
+	}
+
+	quantity, ok := parseQuantity(fields[0])
+	if !ok {
+		return 0, fields, false
+	}
+
+	whole := !strings.ContainsAny(fields[0], "./")
+	if whole && len(fields) > 1 && strings.Contains(fields[1], "/") {
+		if fraction, ok := parseQuantity(fields[1]); ok {
+			return quantity + fraction, fields[2:], true
+		}
+	}
+	return quantity, fields[1:], true
+}
+
+// parseUnit parses a unit written as any of its synonyms, e.g. "tablespoons" or "lb.".
+func parseUnit(token string) (Unit, bool) {
+	unit, ok := unitSynonyms[strings.TrimSuffix(strings.ToLower(token), ".")]
+	return unit, ok
+}
+
+// ingredientName joins the name fields, dropping a leading "of" and any preparation
+// notes after a comma, e.g. "onion, diced".
+func ingredientName(fields []string) string {
+	if len(fields) > 0 && strings.EqualFold(fields[0], "of") {
+		fields = fields[1:]
+	}
+	name, _, _ := strings.Cut(strings.Join(fields, " "), ",")
+	return strings.TrimSpace(name)
+}
+
+// ParseIngredientLine parses a free-text ingredient line into its quantity, unit and
+// name. It handles:
+//   - fractions and mixed numbers: "1 1/2 lb ground beef", "1½ cups rice"
+//   - unit synonyms: "2 tablespoons olive oil"
+//   - package sizes, multiplied out: "2 (15 oz) cans black beans" is 30 oz
+//   - lines without a unit, which are counted: "3 eggs"
+//   - lines without a quantity, which have neither quantity nor unit: "salt to taste"
+//
+// The aisle is left empty.
+func ParseIngredientLine(line string) (Ingredient, error) {
+	text := strings.Join(strings.Fields(expandUnicodeFractions(line)), " ")
+	text = quantityLessSuffix.ReplaceAllString(text, "")
+	if text == "" {
+		return Ingredient{}, fmt.Errorf("empty ingredient line")
+	}
+
+	quantity, fields, ok := parseLeadingQuantity(strings.Fields(text))
+	if !ok {
+		return Ingredient{Name: ingredientName(fields)}, nil
+	}
+
+	unit := UnitCount
+	rest := strings.Join(fields, " ")
+	if match := packageSize.FindStringSubmatch(rest); match != nil {
+		size, sizeFields, sizeOk := parseLeadingQuantity(strings.Fields(match[1]))
+		if sizeOk && len(sizeFields) == 1 {
+			if sizeUnit, unitOk := parseUnit(sizeFields[0]); unitOk {
+				quantity *= size
+				unit = sizeUnit
+				fields = strings.Fields(rest[len(match[0]):])
+				if len(fields) > 0 && containerWords[strings.ToLower(fields[0])] {
+					fields = fields[1:]
+				}
+			}
+		}
+	} else if len(fields) > 0 {
+		if parsed, ok := parseUnit(fields[0]); ok {
+			unit = parsed
+			fields = fields[1:]
+		}
+	}
+
+	name := ingredientName(fields)
+	if name == "" {
+		return Ingredient{}, fmt.Errorf("no ingredient name in '%s'", line)
+	}
+
+	return Ingredient{
+		Name:     name,
+		Quantity: quantity,
+		Unit:     unit,
+	}, nil
+}
@@ -0,0 +1,108 @@
+package meal_collection
+
+import (
+	"fmt"
+	"time"
+)
+
+// LEFTOVER_MAX_DAYS is how many days after a meal is cooked its leftovers can be eaten.
+const LEFTOVER_MAX_DAYS = 4
+
+// LeftoverSource ties a leftover day to the meal being finished, and the day it was cooked.
+type LeftoverSource struct {
+	Meal string
+	Date time.Time
+}
+
+// IsLeftovers reports whether the meal is a leftover day.
+func (m Meal) IsLeftovers() bool {
+	return m.BaseName() == MEAL_LEFTOVERS.Name
+}
+
+// dinners returns how many dinners the recipe makes.
+func (m Meal) dinners() int {
+	if m.Yield > 1 {
+		return m.Yield
+	}
+	return 1
+}
+
+// DisplayName returns the name to show for the meal, which for a leftover day tied to
+// a source meal is like "Leftovers: Chili (from Mon)".
+func (m Meal) DisplayName() string {
+	if m.LeftoversOf == nil {
+		return m.Name
+	}
+	return fmt.Sprintf("%s: %s (from %s)", m.Name, m.LeftoversOf.Meal, m.LeftoversOf.Date.Weekday().String()[:3])
+}
+
+// LinkLeftovers returns a copy of meals where each leftover day is tied to the most
+// recent meal within LEFTOVER_MAX_DAYS before it that makes more dinners than have been
+// eaten so far. Only meals within dates are considered. Each source meal counts the
+// leftover days tied to it in LeftoverDays. dates and meals must be the same length.
+func LinkLeftovers(dates []time.Time, meals []Meal) []Meal {
+	linked := make([]Meal, len(meals))
+	copy(linked, meals)
+
+	for i := range linked {
+		if !linked[i].IsLeftovers() {
+			continue
+		}
+
+		earliest := dates[i].AddDate(0, 0, -LEFTOVER_MAX_DAYS)
+		for j := i - 1; j >= 0 && !dates[j].Before(earliest); j-- {
+			source := &linked[j]
+			if source.IsLeftovers() || source.LeftoverDays >= source.dinners()-1 {
+				continue
+			}
+
+			source.LeftoverDays++
+			linked[i].LeftoversOf = &LeftoverSource{Meal: source.BaseName(), Date: dates[j]}
+			break
+		}
+	}
+
+	return linked
+}
+
+// leftoverWindow returns every date from LEFTOVER_MAX_DAYS before the first of dates to
+// LEFTOVER_MAX_DAYS after the last. Linking leftovers over it ties leftover days early in
+// dates to meals cooked before them, and counts the leftover days after dates of meals
+// cooked late in them.
+func leftoverWindow(dates []time.Time) []time.Time {
+	if len(dates) == 0 {
+		return nil
+	}
+
+	start, end := dates[0], dates[0]
+	for _, date := range dates {
+		if date.Before(start) {
+			start = date
+		}
+		if date.After(end) {
+			end = date
+		}
+	}
+
+	var window []time.Time
+	for d := start.AddDate(0, 0, -LEFTOVER_MAX_DAYS); !d.After(end.AddDate(0, 0, LEFTOVER_MAX_DAYS)); d = d.AddDate(0, 0, 1) {
+		window = append(window, d)
+	}
+	return window
+}
+
+// leftoverSourceFilter prefers meals making several dinners on the day before a leftover
+// day, so there is something to have leftovers of.
+type leftoverSourceFilter struct {
+	template WeeklyTemplate
+}
+
+func (f leftoverSourceFilter) Accept(date time.Time, meal Meal) bool {
+	next := date.AddDate(0, 0, 1)
+	if f.template[next.Weekday()].Meal != MEAL_LEFTOVERS.Name {
+		return true
+	}
+	return meal.Yield > 1
+}
+
+func (f leftoverSourceFilter) Served(date time.Time, meal Meal) {}
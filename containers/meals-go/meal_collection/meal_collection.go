@@ -1,8 +1,8 @@
 package meal_collection
 
 import (
+	"bytes"
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -14,9 +14,10 @@ import (
 	"time"
 
 	"github.com/andrewpollack/pi-infrastructure/containers/meals-go/calendar"
+	"github.com/andrewpollack/pi-infrastructure/containers/meals-go/config"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"golang.org/x/exp/rand"
 )
@@ -34,11 +35,26 @@ const (
 	AislePastaGlobalCanned   Aisle = "1 & 2 (Pasta, Global, Canned)"
 	AisleProduce             Aisle = "Produce"
 	AisleMeatAndYogurt       Aisle = "Meat & Yogurt"
+
+	// AisleUnsorted collects grocery items whose aisle isn't configured.
+	AisleUnsorted Aisle = "Unsorted"
+	// AisleCheckStock collects pantry staples to check at home.
+	AisleCheckStock Aisle = "Check Stock"
 )
 
-// IsValidAisle checks if the given aisle is a valid enum value
+// IsValid checks that the aisle is one of the aisles configured under app.aisles, or
+// AisleUnsorted. Any aisle is valid when none are configured.
 func (a Aisle) IsValid() error {
-	return nil
+	if len(config.Cfg.App.Aisles) == 0 || a == AisleUnsorted {
+		return nil
+	}
+
+	for _, aisle := range config.Cfg.App.Aisles {
+		if string(a) == aisle {
+			return nil
+		}
+	}
+	return errors.New("invalid aisle: " + string(a))
 }
 
 type Unit string
@@ -71,11 +87,16 @@ func (u Unit) IsValid() error {
 }
 
 type Ingredient struct {
-	Name         string  `json:"item"`
-	Quantity     float64 `json:"quantity"`
-	Unit         Unit    `json:"unit"`
-	Aisle        Aisle   `json:"aisle"`
-	RelatedMeals []string
+	Name     string  `json:"item"`
+	Quantity float64 `json:"quantity"`
+	Unit     Unit    `json:"unit"`
+	Aisle    Aisle   `json:"aisle"`
+	// Raw is a free-text line like "1 1/2 lb ground beef", parsed into Name, Quantity
+	// and Unit when Name is empty
+	Raw          string   `json:"raw,omitempty"`
+	RelatedMeals []string `json:"-"`
+	// CheckStock marks a pantry staple, listed to check at home rather than to buy
+	CheckStock bool `json:"-"`
 }
 
 type Meal struct {
@@ -84,6 +105,25 @@ type Meal struct {
 	Ingredients []Ingredient `json:"ingredients,omitempty"`
 	Disabled    bool         `json:"disabled,omitempty"`
 	Category    *string      `json:"category,omitempty"`
+	// Servings is how many people the ingredient quantities feed; 0 means unknown
+	Servings int `json:"servings,omitempty"`
+	// AvailableFrom and AvailableTo limit the meal to a yearly window, inclusive, like
+	// "11-01" to "03-15". Both empty means the meal is available all year.
+	AvailableFrom MonthDay `json:"available_from,omitempty"`
+	AvailableTo   MonthDay `json:"available_to,omitempty"`
+	// Tags describe the meal, e.g. "vegetarian" or "quick", for filtering and WeekConstraints
+	Tags []string `json:"tags,omitempty"`
+	// PrepMinutes is the total time to make the meal, and ActiveMinutes the hands-on part
+	// of it; 0 means unknown
+	PrepMinutes   int `json:"prep_minutes,omitempty"`
+	ActiveMinutes int `json:"active_minutes,omitempty"`
+	// Yield is how many dinners the recipe makes, with the extra ones eaten on leftover
+	// days; 0 means 1
+	Yield int `json:"yield,omitempty"`
+	// LeftoversOf ties a leftover day to its source meal, and LeftoverDays counts the
+	// leftover days tied to a source meal. Both are set by LinkLeftovers.
+	LeftoversOf  *LeftoverSource `json:"-"`
+	LeftoverDays int             `json:"-"`
 }
 
 var MEAL_LEFTOVERS = Meal{
@@ -96,6 +136,77 @@ var MEAL_OUT = Meal{
 
 type MealCollection []Meal
 
+// RESHUFFLED_SUFFIX is appended by the generators to the first meal served after the
+// collection is reshuffled.
+const RESHUFFLED_SUFFIX = "**"
+
+// BaseName returns the meal's name without any generator markers.
+func (m Meal) BaseName() string {
+	return strings.TrimSuffix(m.Name, RESHUFFLED_SUFFIX)
+}
+
+// QuantityLess reports whether the ingredient was parsed from a raw line without a
+// quantity, e.g. "salt to taste".
+func (i Ingredient) QuantityLess() bool {
+	return i.Raw != "" && i.Quantity == 0 && i.Unit == ""
+}
+
+// ParseRawIngredients parses the Raw line of every ingredient that has no Name. An
+// explicit aisle is kept.
+func (m *Meal) ParseRawIngredients() error {
+	for i, ingredient := range m.Ingredients {
+		if ingredient.Raw == "" || ingredient.Name != "" {
+			continue
+		}
+
+		parsed, err := ParseIngredientLine(ingredient.Raw)
+		if err != nil {
+			return fmt.Errorf("error in item '%s': %v", m.Name, err)
+		}
+		parsed.Aisle = ingredient.Aisle
+		parsed.Raw = ingredient.Raw
+		m.Ingredients[i] = parsed
+	}
+	return nil
+}
+
+// Scaled returns a copy of the meal with ingredient quantities scaled to feed servings.
+// The meal is returned unchanged when either its own or the requested servings are unknown.
+func (m Meal) Scaled(servings int) Meal {
+	if servings <= 0 || m.Servings <= 0 || servings == m.Servings {
+		return m
+	}
+
+	scaled := m.scaledBy(float64(servings) / float64(m.Servings))
+	scaled.Servings = servings
+	return scaled
+}
+
+// scaledBy returns a copy of the meal with every ingredient quantity multiplied by factor.
+func (m Meal) scaledBy(factor float64) Meal {
+	scaled := m
+	scaled.Ingredients = make([]Ingredient, len(m.Ingredients))
+	for i, ingredient := range m.Ingredients {
+		ingredient.Quantity *= factor
+		scaled.Ingredients[i] = ingredient
+	}
+	return scaled
+}
+
+// ScaleMeals scales each of meals to the servings at the same index. Meals making
+// several dinners are bought as the whole recipe whether or not their leftovers are
+// planned, since leftover days have no ingredients of their own.
+func ScaleMeals(meals []Meal, servings []int) []Meal {
+	scaled := make([]Meal, len(meals))
+	for i, meal := range meals {
+		if i < len(servings) {
+			meal = meal.Scaled(servings[i])
+		}
+		scaled[i] = meal
+	}
+	return scaled
+}
+
 // TODO: Name this something more logical... maybe just "Item"?
 type ExtraItem struct {
 	Name    string `json:"name"`
@@ -170,42 +281,77 @@ func (i Ingredient) StringBolded() string {
 	)
 }
 
+// MealsToIngredients combines the ingredients of meals into a grocery list, merging
+// compatible units into the largest one used for each ingredient.
 func MealsToIngredients(meals []Meal) []Ingredient {
+	return MealsToIngredientsWithUnits(meals, nil)
+}
+
+// MealsToIngredientsWithUnits combines the ingredients of meals into a grocery list.
+// Quantities of an ingredient in compatible units are converted and summed following
+// its UnitPreference, while quantities that can't be converted stay separate.
+func MealsToIngredientsWithUnits(meals []Meal, preferences UnitPreferences) []Ingredient {
+	// Names are compared case-insensitively, keeping the first spelling seen
 	type ingredientKey struct {
-		Name  string
-		Unit  Unit
-		Aisle Aisle
+		Name      string
+		Dimension Dimension
+		Aisle     Aisle
+	}
+
+	type ingredientGroup struct {
+		Name        string
+		Ingredients []Ingredient
+		MealNames   []string
 	}
 
-	combined := make(map[ingredientKey]Ingredient)
+	var keys []ingredientKey
+	groups := make(map[ingredientKey]*ingredientGroup)
 
-	// Aggregate ingredients by (Name, Unit, Aisle)
+	// Group ingredients by (Name, Dimension, Aisle)
 	for _, meal := range meals {
 		for _, ing := range meal.Ingredients {
 			key := ingredientKey{
-				Name:  ing.Name,
-				Unit:  ing.Unit,
-				Aisle: ing.Aisle,
+				Name:      NormalizeIngredientName(ing.Name),
+				Dimension: preferences.get(ing.Name).dimension(ing.Unit),
+				Aisle:     ing.Aisle,
 			}
 
-			agg := combined[key]
+			group, ok := groups[key]
+			if !ok {
+				group = &ingredientGroup{Name: strings.TrimSpace(ing.Name)}
+				groups[key] = group
+				keys = append(keys, key)
+			}
+			group.Ingredients = append(group.Ingredients, ing)
+			group.MealNames = append(group.MealNames, meal.Name)
+		}
+	}
 
-			// Update fields and sum quantities
-			agg.Name = ing.Name
-			agg.Unit = ing.Unit
-			agg.Aisle = ing.Aisle
-			agg.Quantity += ing.Quantity
-			agg.RelatedMeals = append(agg.RelatedMeals, meal.Name)
-			sort.Strings(agg.RelatedMeals)
+	// Convert each group into its target unit and sum quantities
+	result := make([]Ingredient, 0, len(groups))
+	for _, key := range keys {
+		group := groups[key]
+		preference := preferences.get(group.Name)
 
-			combined[key] = agg
+		units := make([]Unit, 0, len(group.Ingredients))
+		for _, ing := range group.Ingredients {
+			units = append(units, ing.Unit)
 		}
-	}
+		targetUnit := preference.targetUnit(units)
 
-	// Flatten into a single slice
-	result := make([]Ingredient, 0, len(combined))
-	for _, ing := range combined {
-		result = append(result, ing)
+		agg := Ingredient{
+			Name:  group.Name,
+			Unit:  targetUnit,
+			Aisle: key.Aisle,
+		}
+		for i, ing := range group.Ingredients {
+			quantity, _ := preference.Convert(ing.Quantity, ing.Unit, targetUnit)
+			agg.Quantity += quantity
+			agg.RelatedMeals = append(agg.RelatedMeals, group.MealNames[i])
+		}
+		sort.Strings(agg.RelatedMeals)
+
+		result = append(result, agg)
 	}
 
 	// Sort by agg.RelatedMeals first, then by Name
@@ -226,14 +372,17 @@ func validateIngredient(ingredient Ingredient) error {
 	if ingredient.Name == "" {
 		return errors.New("ingredient item cannot be empty")
 	}
-	if ingredient.Quantity <= 0 {
-		return errors.New("ingredient quantity must be greater than zero")
-	}
-	if ingredient.Unit == "" {
-		return errors.New("ingredient unit cannot be empty")
-	}
-	if err := ingredient.Unit.IsValid(); err != nil {
-		return err
+	// Raw lines like "salt to taste" parse without a quantity or unit
+	if !ingredient.QuantityLess() {
+		if ingredient.Quantity <= 0 {
+			return errors.New("ingredient quantity must be greater than zero")
+		}
+		if ingredient.Unit == "" {
+			return errors.New("ingredient unit cannot be empty")
+		}
+		if err := ingredient.Unit.IsValid(); err != nil {
+			return err
+		}
 	}
 	if ingredient.Aisle == "" {
 		return errors.New("ingredient aisle cannot be empty")
@@ -246,15 +395,50 @@ func validateIngredient(ingredient Ingredient) error {
 
 func validateMealCollection(mealCollection MealCollection) error {
 	for _, item := range mealCollection {
+		if item.Servings < 0 {
+			return fmt.Errorf("error in item '%s': servings cannot be negative", item.Name)
+		}
+		if item.Yield < 0 {
+			return fmt.Errorf("error in item '%s': yield cannot be negative", item.Name)
+		}
+		if item.PrepMinutes < 0 || item.ActiveMinutes < 0 {
+			return fmt.Errorf("error in item '%s': prep and active minutes cannot be negative", item.Name)
+		}
+		if err := validateAvailability(item); err != nil {
+			return fmt.Errorf("error in item '%s': %v", item.Name, err)
+		}
+		if err := validateTags(item); err != nil {
+			return fmt.Errorf("error in item '%s': %v", item.Name, err)
+		}
 		for _, ingredient := range item.Ingredients {
 			if err := validateIngredient(ingredient); err != nil {
-				return fmt.Errorf("error in item '%s' of category '%s': %v", item.Name, *item.Category, err)
+				category := ""
+				if item.Category != nil {
+					category = *item.Category
+				}
+				return fmt.Errorf("error in item '%s' of category '%s': %v", item.Name, category, err)
 			}
 		}
 	}
 	return nil
 }
 
+// ValidateRecipe checks a single recipe as it will be read, with catalog applied.
+func ValidateRecipe(meal Meal, catalog IngredientCatalog) error {
+	if strings.TrimSpace(meal.Name) == "" {
+		return errors.New("recipe name cannot be empty")
+	}
+	if strings.HasSuffix(meal.Name, RESHUFFLED_SUFFIX) {
+		return fmt.Errorf("recipe name cannot end with '%s'", RESHUFFLED_SUFFIX)
+	}
+	return MealCollection{meal}.ApplyCatalog(catalog).Validate()
+}
+
+// Validate checks that every ingredient of the collection is complete and valid.
+func (m MealCollection) Validate() error {
+	return validateMealCollection(m)
+}
+
 func OpenMealData(filename string) (io.ReadCloser, error) {
 	return os.Open(filename)
 }
@@ -268,7 +452,7 @@ func OpenFromS3(bucketName string, bucketKey string) (io.ReadCloser, error) {
 	}
 
 	// Load AWS config
-	cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion("us-west-2"))
+	cfg, err := awsconfig.LoadDefaultConfig(context.TODO(), awsconfig.WithRegion("us-west-2"))
 	if err != nil {
 		return nil, fmt.Errorf("unable to load SDK config: %v", err)
 	}
@@ -288,6 +472,34 @@ func OpenFromS3(bucketName string, bucketKey string) (io.ReadCloser, error) {
 	return resp.Body, nil
 }
 
+// UploadToS3 writes data to the given bucket and key.
+func UploadToS3(bucketName string, bucketKey string, data []byte) error {
+	if bucketName == "" {
+		return fmt.Errorf("bucket name is not set")
+	}
+	if bucketKey == "" {
+		return fmt.Errorf("bucket key is not set")
+	}
+
+	cfg, err := awsconfig.LoadDefaultConfig(context.TODO(), awsconfig.WithRegion("us-west-2"))
+	if err != nil {
+		return fmt.Errorf("unable to load SDK config: %v", err)
+	}
+
+	s3Client := s3.NewFromConfig(cfg)
+	_, err = s3Client.PutObject(context.TODO(), &s3.PutObjectInput{
+		Bucket:      aws.String(bucketName),
+		Key:         aws.String(bucketKey),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/json"),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to put object to S3: %v", err)
+	}
+
+	return nil
+}
+
 func ExtraItemToIngredient(ei ExtraItem) Ingredient {
 	return Ingredient{
 		Name:     ei.Name,
@@ -303,14 +515,22 @@ func ReadMealCollectionFromReader(reader io.ReadCloser) (MealCollection, error)
 		}
 	}()
 
-	decoder := json.NewDecoder(reader)
-	decoder.DisallowUnknownFields()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("error reading JSON: %v", err)
+	}
 
-	// Decode the JSON data into a MealCollection object
-	var mealCollection MealCollection
-	if err := decoder.Decode(&mealCollection); err != nil {
+	// Decode the JSON data into a RecipeFile object
+	recipeFile, err := decodeRecipeFile(data, true)
+	if err != nil {
 		return nil, fmt.Errorf("error unmarshalling JSON: %v", err)
 	}
+	recipeFile = recipeFile.UnsortUnknownAisles()
+
+	if err := recipeFile.Ingredients.Validate(); err != nil {
+		return nil, fmt.Errorf("validation error: %v", err)
+	}
+	mealCollection := recipeFile.Recipes.ApplyCatalog(recipeFile.Ingredients)
 
 	// Sort meals by name
 	sort.Slice(mealCollection, func(i, j int) bool {
@@ -333,14 +553,14 @@ func PopItem(items []Meal) (Meal, []Meal, bool) {
 	return items[0], items[1:], true
 }
 
-func Shuffle(slice interface{}) {
+func Shuffle(rng *rand.Rand, slice interface{}) {
 	v := reflect.ValueOf(slice)
 
 	if v.Kind() != reflect.Slice {
 		panic("Shuffle() expects a slice")
 	}
 
-	rand.Shuffle(v.Len(), func(i, j int) {
+	rng.Shuffle(v.Len(), func(i, j int) {
 		// Swap elements i and j using reflection
 		tmp := reflect.ValueOf(v.Index(i).Interface())
 		v.Index(i).Set(v.Index(j))
@@ -363,17 +583,196 @@ func (m MealCollection) DeepCopy() MealCollection {
 	return mealCopy
 }
 
+// GenerationMode selects which generator is used to build a calendar's meals.
+type GenerationMode string
+
+const (
+	GenerationModeNoCategories GenerationMode = "no_categories"
+	GenerationModeCategories   GenerationMode = "categories"
+	// GenerationModeRated weights meals by their ratings in GenerateOptions.History and
+	// avoids meals cooked recently.
+	GenerationModeRated GenerationMode = "rated"
+)
+
+// DEFAULT_CATEGORY_GAP is used when GenerationModeCategories is selected without a gap.
+const DEFAULT_CATEGORY_GAP = 2
+
+func (g GenerationMode) IsValid() error {
+	switch g {
+	case "", GenerationModeNoCategories, GenerationModeCategories, GenerationModeRated:
+		return nil
+	}
+	return errors.New("invalid generation mode: " + string(g))
+}
+
+// GenerateOptions configures GenerateMealsWholeYear.
+type GenerateOptions struct {
+	Mode GenerationMode
+	// CategoryGap is the number of days after a meal during which its category is avoided.
+	CategoryGap int
+	// Template decides what each weekday serves. DEFAULT_WEEKLY_TEMPLATE is used when nil.
+	Template WeeklyTemplate
+	// Stable has the backend snapshot each day's meal once it's served, and each week's
+	// meals once they're emailed, so changes to the collection only re-plan the future.
+	// See MealsForDates.
+	Stable bool
+	// History is the snapshot of meal history used by GenerationModeRated. The same
+	// snapshot always generates the same meals.
+	History []HistoryEntry
+	// Constraints bound how many meals with a tag are served each week.
+	Constraints []WeekConstraint
+}
+
+// GenerateMealsWholeYear generates the meals for the given calendar month using the
+// generator selected by opts.Mode.
+func (m MealCollection) GenerateMealsWholeYear(currCalendar calendar.Calendar, opts GenerateOptions) []Meal {
+	template := opts.Template
+	if template == nil {
+		template = DEFAULT_WEEKLY_TEMPLATE
+	}
+
+	g := generator{
+		template: template,
+		required: []mealFilter{
+			templateCategoryFilter{template: template},
+			templatePrepTimeFilter{template: template},
+			availabilityFilter{},
+		},
+	}
+
+	switch opts.Mode {
+	case GenerationModeCategories:
+		categoryGap := opts.CategoryGap
+		if categoryGap <= 0 {
+			categoryGap = DEFAULT_CATEGORY_GAP
+		}
+		g.preferred = append(g.preferred, &categoryGapFilter{gap: categoryGap})
+	case GenerationModeRated:
+		g.weights = mealWeights(opts.History)
+		g.preferred = append(g.preferred, newRecentlyCookedFilter(RATED_RECENT_DAYS, opts.History))
+	}
+
+	for _, meal := range m {
+		if meal.Yield > 1 {
+			g.preferred = append(g.preferred, leftoverSourceFilter{template: template})
+			break
+		}
+	}
+
+	if len(opts.Constraints) > 0 {
+		g.required = append(g.required, newWeekConstraintFilter(opts.Constraints, m, template, g.required))
+	}
+
+	return g.generateMealsWholeYear(m, currCalendar)
+}
+
 // GenerateMealsWholeYear generates a random list of meals, not respecting categories, and
 // starting from the beginning of the year
 func (m MealCollection) GenerateMealsWholeYearNoCategories(currCalendar calendar.Calendar) []Meal {
+	return m.GenerateMealsWholeYear(currCalendar, GenerateOptions{Mode: GenerationModeNoCategories})
+}
+
+// GenerateMealsWholeYearCategories generates a random list of meals like
+// GenerateMealsWholeYearNoCategories, but avoids serving a category again within
+// categoryGap days of the last meal of that category. Meals are still drawn from the
+// same shuffled cycle, so every meal is served once per cycle. When no remaining meal
+// in the cycle satisfies the gap, the next meal is served anyway.
+func (m MealCollection) GenerateMealsWholeYearCategories(currCalendar calendar.Calendar, categoryGap int) []Meal {
+	return m.GenerateMealsWholeYear(currCalendar, GenerateOptions{
+		Mode:        GenerationModeCategories,
+		CategoryGap: categoryGap,
+	})
+}
+
+// mealFilter steers which meal the generator serves on a generated day.
+type mealFilter interface {
+	// Accept reports whether meal is acceptable on date.
+	Accept(date time.Time, meal Meal) bool
+	// Served is called with the meal served on every day, including fixed meals
+	// like Leftovers.
+	Served(date time.Time, meal Meal)
+}
+
+// categoryGapFilter rejects meals whose category was served within the last gap days.
+type categoryGapFilter struct {
+	gap int
+	// Categories of the most recent days, oldest first. Days without a category are
+	// kept so the gap is measured in days rather than meals.
+	recent []string
+}
+
+func (f *categoryGapFilter) Accept(date time.Time, meal Meal) bool {
+	if meal.Category == nil || *meal.Category == "" {
+		return true
+	}
+	for _, category := range f.recent {
+		if strings.EqualFold(category, *meal.Category) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *categoryGapFilter) Served(date time.Time, meal Meal) {
+	category := ""
+	if meal.Category != nil {
+		category = *meal.Category
+	}
+	f.recent = append(f.recent, category)
+	if len(f.recent) > f.gap {
+		f.recent = f.recent[1:]
+	}
+}
+
+// generator cycles through a shuffled copy of a collection from the beginning of the
+// year, reshuffling whenever the list is exhausted.
+type generator struct {
+	template WeeklyTemplate
+	// required filters must accept the served meal. If nothing left in the cycle
+	// fits, an already served meal that fits is repeated instead.
+	required []mealFilter
+	// preferred filters are dropped when nothing left in the cycle fits them.
+	preferred []mealFilter
+	// weights, when set, make favorites appear twice per cycle, drop disliked meals from
+	// some cycles, and order each cycle by weighted sampling instead of a flat shuffle.
+	weights map[string]float64
+	// rng is seeded for each generated calendar, so the same calendar always generates
+	// the same meals, whatever else is drawing random numbers.
+	rng *rand.Rand
+}
+
+// nextCycle returns the shuffled meals of cycle n, drawn from meals. Without weights,
+// every cycle has the same meals, so previous is reshuffled in place.
+func (g generator) nextCycle(meals []Meal, previous []Meal, n int) []Meal {
+	if g.weights == nil {
+		Shuffle(g.rng, previous)
+		return previous
+	}
+
+	cycle := weightedCycle(meals, g.weights, n)
+	weightedShuffle(g.rng, cycle, g.weights)
+	return cycle
+}
+
+func acceptedByAll(filters []mealFilter, date time.Time, meal Meal) bool {
+	for _, filter := range filters {
+		if !filter.Accept(date, meal) {
+			return false
+		}
+	}
+	return true
+}
+
+func (g generator) generateMealsWholeYear(m MealCollection, currCalendar calendar.Calendar) []Meal {
 	// Use Year to make meal generation consistent
-	rand.Seed(uint64(currCalendar.Year))
+	g.rng = rand.New(rand.NewSource(uint64(currCalendar.Year)))
 
 	// Check if the target month is before or after the current calendar month
 	futureMonth := time.Now().Month() <= currCalendar.Month
 
 	// Create a copy of MealCollection so that the original isn't modified
 	mealCopy := m.DeepCopy()
+	mealMap := mealCopy.MapNameToMeal()
 
 	var allMeals []Meal
 	for _, item := range mealCopy {
@@ -382,7 +781,7 @@ func (m MealCollection) GenerateMealsWholeYearNoCategories(currCalendar calendar
 
 	currItemInd := 0
 	totalShuffles := 0
-	Shuffle(allMeals)
+	allMeals = g.nextCycle(mealCopy, allMeals, totalShuffles)
 	appendItems := false
 	var selectedMeals []Meal
 	for i := 1; i <= int(currCalendar.Month); i++ {
@@ -393,24 +792,23 @@ func (m MealCollection) GenerateMealsWholeYearNoCategories(currCalendar calendar
 
 		cal := calendar.NewCalendar(currCalendar.Year, time.Month(i))
 		for j := 1; j <= cal.DaysInMonth(); j++ {
+			date := time.Date(cal.Year, cal.Month, j, 0, 0, 0, 0, time.UTC)
 			startingShuffleNum := totalShuffles
+			skipDisabled := appendItems || futureMonth
 			var item Meal
-			switch cal.GetWeekday(j) {
-			case time.Thursday:
-				item = MEAL_LEFTOVERS
-			case time.Friday:
-				item = MEAL_OUT
-			default:
-				if appendItems || futureMonth {
+			if rule := g.template[date.Weekday()]; rule.Meal != "" {
+				item = fixedMeal(mealMap, rule.Meal)
+			} else {
+				if skipDisabled {
 					// Skip until finding a meal that is enabled. Makes shuffling more consistent
 					// month to month for previous months.
 					for {
 						if allMeals[currItemInd].Disabled {
 							currItemInd += 1
 							if currItemInd >= len(allMeals) {
-								Shuffle(allMeals)
 								currItemInd = 0
 								totalShuffles += 1
+								allMeals = g.nextCycle(mealCopy, allMeals, totalShuffles)
 							}
 						} else {
 							break
@@ -418,19 +816,64 @@ func (m MealCollection) GenerateMealsWholeYearNoCategories(currCalendar calendar
 					}
 				}
 
-				item = allMeals[currItemInd]
+				fits := func(meal Meal, filters []mealFilter) bool {
+					return !(skipDisabled && meal.Disabled) && acceptedByAll(filters, date, meal)
+				}
+				isFit := func(meal Meal) bool {
+					return fits(meal, g.required) && fits(meal, g.preferred)
+				}
 
-				currItemInd += 1
-				if currItemInd >= len(allMeals) {
-					Shuffle(allMeals)
-					currItemInd = 0
-					totalShuffles += 1
+				repeated := false
+				if !isFit(allMeals[currItemInd]) {
+					// Pull the best meal left in this cycle forward
+					found := -1
+					for _, accept := range []func(Meal) bool{
+						isFit,
+						func(meal Meal) bool { return fits(meal, g.required) },
+					} {
+						for k := currItemInd; k < len(allMeals) && found < 0; k++ {
+							if accept(allMeals[k]) {
+								found = k
+							}
+						}
+					}
+
+					if found >= 0 {
+						allMeals[currItemInd], allMeals[found] = allMeals[found], allMeals[currItemInd]
+					} else {
+						// Nothing left in this cycle fits, so repeat a meal already served
+						for k := 0; k < currItemInd; k++ {
+							if fits(allMeals[k], g.required) {
+								item = allMeals[k]
+								repeated = true
+								break
+							}
+						}
+					}
 				}
-				if totalShuffles > startingShuffleNum {
-					item.Name = fmt.Sprintf("%s**", item.Name)
+
+				if !repeated {
+					item = allMeals[currItemInd]
+
+					currItemInd += 1
+					if currItemInd >= len(allMeals) {
+						currItemInd = 0
+						totalShuffles += 1
+						allMeals = g.nextCycle(mealCopy, allMeals, totalShuffles)
+					}
+					if totalShuffles > startingShuffleNum {
+						item.Name = fmt.Sprintf("%s%s", item.Name, RESHUFFLED_SUFFIX)
+					}
 				}
 			}
 
+			for _, filter := range g.required {
+				filter.Served(date, item)
+			}
+			for _, filter := range g.preferred {
+				filter.Served(date, item)
+			}
+
 			if appendItems {
 				selectedMeals = append(selectedMeals, item)
 			}
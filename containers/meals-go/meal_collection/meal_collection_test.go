@@ -1,13 +1,24 @@
 package meal_collection
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"math"
+	"os"
 	"reflect"
 	"sort"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/andrewpollack/pi-infrastructure/containers/meals-go/calendar"
+	"github.com/andrewpollack/pi-infrastructure/containers/meals-go/config"
+
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
 const MEALS_JSON = "../data/recipes.json"
@@ -37,6 +48,61 @@ func TestMealCollectionBadReading(t *testing.T) {
 	}
 }
 
+func TestMealCollectionReadingWithCatalog(t *testing.T) {
+	mealData, err := OpenMealData("../data/recipes_with_catalog.json")
+	if err != nil {
+		log.Fatalf("Error fetching mealData: %v", err)
+	}
+
+	collection, err := ReadMealCollectionFromReader(mealData)
+	if err != nil {
+		t.Fatalf("Something went wrong reading meals... %s", err)
+	}
+
+	got := MealsToIngredients(collection)
+	want := map[string]Ingredient{
+		"onion":             {Name: "onion", Quantity: 5, Unit: UnitCount, Aisle: AisleProduce},
+		"Italian seasoning": {Name: "Italian seasoning", Quantity: 2, Unit: UnitTsp, Aisle: AisleBreakfastAndBaking},
+		"Veggie stock":      {Name: "Veggie stock", Quantity: 4, Unit: UnitCup, Aisle: AislePastaGlobalCanned},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d grocery lines, got: %v", len(want), got)
+	}
+	for _, ing := range got {
+		expected, ok := want[ing.Name]
+		if !ok || ing.Quantity != expected.Quantity || ing.Unit != expected.Unit || ing.Aisle != expected.Aisle {
+			t.Errorf("Unexpected grocery line: %v", ing)
+		}
+	}
+}
+
+func TestIngredientCatalogValidate(t *testing.T) {
+	catalog := IngredientCatalog{
+		{Name: "onion", Aliases: []string{"yellow onion"}},
+		{Name: "shallot", Aliases: []string{"Yellow Onion"}},
+	}
+	if err := catalog.Validate(); err == nil {
+		t.Errorf("Expected an error for an alias claimed twice")
+	}
+
+	if err := (IngredientCatalog{{Name: " "}}).Validate(); err == nil {
+		t.Errorf("Expected an error for an empty name")
+	}
+}
+
+func TestCatalogIndexLookup(t *testing.T) {
+	index := IngredientCatalog{{Name: "onion", Aliases: []string{"yellow onion"}, Aisle: AisleProduce}}.Index()
+
+	for _, name := range []string{"onion", "Yellow  Onion"} {
+		if entry, ok := index.Lookup(name); !ok || entry.Name != "onion" {
+			t.Errorf("Expected '%s' to resolve to onion, got %+v", name, entry)
+		}
+	}
+	if _, ok := index.Lookup("shallot"); ok {
+		t.Errorf("Expected shallot not to be found")
+	}
+}
+
 func TestMealListGenerationFromCollection(t *testing.T) {
 	mealData, err := OpenMealData(MEALS_JSON)
 	if err != nil {
@@ -180,72 +246,1283 @@ func TestGenerateMealsWholeYearUniquePerMonth(t *testing.T) {
 	}
 }
 
-func TestMealsToIngredients(t *testing.T) {
-	// Sample input data
-	meals := []Meal{
-		{
-			Name: "Pizza",
-			Ingredients: []Ingredient{
-				{Name: "Cheese", Quantity: 2, Unit: UnitCount, Aisle: AisleCheeseAndBakery},
-				{Name: "Tomato Sauce", Quantity: 1, Unit: UnitCup, Aisle: AisleProduce},
-			},
-		},
-		{
-			Name: "Burger",
-			Ingredients: []Ingredient{
-				{Name: "Cheese", Quantity: 2, Unit: UnitCount, Aisle: AisleCheeseAndBakery},
-				{Name: "Bun", Quantity: 4, Unit: UnitCount, Aisle: AisleCheeseAndBakery},
-				{Name: "Beef", Quantity: 1, Unit: UnitLb, Aisle: AisleMeatAndYogurt},
-			},
-		},
+func TestGenerateMealsWholeYearCategoriesMatchAcrossCalls(t *testing.T) {
+	mealData, err := OpenMealData(MEALS_JSON)
+	if err != nil {
+		log.Fatalf("Error fetching mealData: %v", err)
 	}
 
-	got := MealsToIngredients(meals)
-	want := []Ingredient{
-		{
-			Name:         "Bun",
-			Unit:         UnitCount,
-			Quantity:     4,
-			Aisle:        AisleCheeseAndBakery,
-			RelatedMeals: []string{"Burger"},
-		},
-		{
-			Name:         "Cheese",
-			Unit:         UnitCount,
-			Quantity:     4, // 2 + 2
-			Aisle:        AisleCheeseAndBakery,
-			RelatedMeals: []string{"Burger", "Pizza"},
-		},
-		{
-			Name:         "Tomato Sauce",
-			Unit:         UnitCup,
-			Quantity:     1,
-			Aisle:        AisleProduce,
-			RelatedMeals: []string{"Pizza"},
-		},
-		{
-			Name:         "Beef",
-			Unit:         UnitLb,
-			Quantity:     1,
-			Aisle:        AisleMeatAndYogurt,
-			RelatedMeals: []string{"Burger"},
-		},
+	collection, err := ReadMealCollectionFromReader(mealData)
+	if err != nil {
+		t.Errorf("Something went wrong reading meals... %s", err)
 	}
 
-	sortIngredients(got)
-	sortIngredients(want)
+	itemsOctober1 := collection.GenerateMealsWholeYearCategories(*calendar.NewCalendar(2024, time.October), 2)
+	itemsOctober2 := collection.GenerateMealsWholeYearCategories(*calendar.NewCalendar(2024, time.October), 2)
 
-	if !reflect.DeepEqual(got, want) {
-		t.Errorf("MealsToIngredients() = %#v;\nwant %#v", got, want)
+	if len(itemsOctober1) != 31 || len(itemsOctober2) != 31 {
+		t.Fatalf("Expected length of lists: '%d', got: '%d' and '%d'", 31, len(itemsOctober1), len(itemsOctober2))
+	}
+
+	for i := range itemsOctober1 {
+		if itemsOctober1[i].Name != itemsOctober2[i].Name {
+			t.Errorf("These items do not match: '%s' '%s'", itemsOctober1[i].Name, itemsOctober2[i].Name)
+		}
 	}
 }
 
-// sortIngredients sorts by Aisle, then by Name, for consistent comparison.
-func sortIngredients(ings []Ingredient) {
-	sort.Slice(ings, func(i, j int) bool {
-		if ings[i].Aisle != ings[j].Aisle {
-			return ings[i].Aisle < ings[j].Aisle
+func TestGenerateMealsWholeYearCategoriesSpreadsCategories(t *testing.T) {
+	mealData, err := OpenMealData(MEALS_JSON)
+	if err != nil {
+		log.Fatalf("Error fetching mealData: %v", err)
+	}
+
+	collection, err := ReadMealCollectionFromReader(mealData)
+	if err != nil {
+		t.Errorf("Something went wrong reading meals... %s", err)
+	}
+
+	// countRepeats counts days whose category was also served the day before
+	countRepeats := func(meals []Meal) int {
+		repeats := 0
+		for i := 1; i < len(meals); i++ {
+			if meals[i].Category != nil && meals[i-1].Category != nil && *meals[i].Category == *meals[i-1].Category {
+				repeats += 1
+			}
 		}
-		return ings[i].Name < ings[j].Name
+		return repeats
+	}
+
+	noCategoryRepeats := 0
+	categoryRepeats := 0
+	for month := time.January; month <= time.December; month++ {
+		cal := *calendar.NewCalendar(2024, month)
+		noCategoryRepeats += countRepeats(collection.GenerateMealsWholeYearNoCategories(cal))
+		categoryRepeats += countRepeats(collection.GenerateMealsWholeYear(cal, GenerateOptions{
+			Mode:        GenerationModeCategories,
+			CategoryGap: 1,
+		}))
+	}
+
+	if categoryRepeats >= noCategoryRepeats {
+		t.Errorf("Expected fewer repeated categories than '%d', got: '%d'", noCategoryRepeats, categoryRepeats)
+	}
+}
+
+func TestGenerateMealsWholeYearRated(t *testing.T) {
+	mealData, err := OpenMealData(MEALS_JSON)
+	if err != nil {
+		log.Fatalf("Error fetching mealData: %v", err)
+	}
+
+	collection, err := ReadMealCollectionFromReader(mealData)
+	if err != nil {
+		t.Errorf("Something went wrong reading meals... %s", err)
+	}
+
+	var enabled []string
+	for _, meal := range collection {
+		if !meal.Disabled {
+			enabled = append(enabled, meal.Name)
+		}
+	}
+	favorite, rejected := enabled[0], enabled[1]
+
+	day := func(month time.Month, d int) time.Time {
+		return time.Date(2024, month, d, 0, 0, 0, 0, time.UTC)
+	}
+	history := []HistoryEntry{
+		{Date: day(time.January, 3), Meal: favorite, Status: HistoryCooked, Rating: 5},
+		{Date: day(time.February, 8), Meal: favorite, Status: HistoryCooked, Rating: 5},
+		{Date: day(time.January, 5), Meal: rejected, Status: HistoryCooked, Rating: 1},
+		{Date: day(time.February, 2), Meal: rejected, Status: HistorySkipped},
+		{Date: day(time.June, 10), Meal: favorite, Status: HistoryCooked, Rating: 5},
+	}
+	opts := GenerateOptions{Mode: GenerationModeRated, History: history}
+
+	favoriteCount, rejectedCount := 0, 0
+	for month := time.January; month <= time.December; month++ {
+		cal := *calendar.NewCalendar(2024, month)
+		first := collection.GenerateMealsWholeYear(cal, opts)
+		second := collection.GenerateMealsWholeYear(cal, opts)
+		if !reflect.DeepEqual(first, second) {
+			t.Fatalf("Expected the same history to generate the same meals in %s", month)
+		}
+
+		for i, meal := range first {
+			switch meal.BaseName() {
+			case favorite:
+				favoriteCount++
+				if month == time.June && i >= 10 && i < 10+RATED_RECENT_DAYS {
+					t.Errorf("Expected '%s' to be avoided after being cooked, got it on June %d", favorite, i+1)
+				}
+			case rejected:
+				rejectedCount++
+			}
+		}
+	}
+
+	if favoriteCount <= rejectedCount {
+		t.Errorf("Expected '%s' to be served more than '%s', got %d and %d", favorite, rejected, favoriteCount, rejectedCount)
+	}
+
+	if err := (HistoryEntry{Date: day(time.March, 1), Meal: favorite, Status: HistoryCooked, Rating: 6}).Validate(); err == nil {
+		t.Errorf("Expected a rating above 5 to be rejected")
+	}
+}
+
+func TestGenerateMealsWholeYearSkipsUnavailable(t *testing.T) {
+	mealData, err := OpenMealData(MEALS_JSON)
+	if err != nil {
+		log.Fatalf("Error fetching mealData: %v", err)
+	}
+
+	collection, err := ReadMealCollectionFromReader(mealData)
+	if err != nil {
+		t.Errorf("Something went wrong reading meals... %s", err)
+	}
+
+	summer, winter := -1, -1
+	for i, meal := range collection {
+		if meal.Disabled {
+			continue
+		}
+		if summer < 0 {
+			summer = i
+		} else if winter < 0 {
+			winter = i
+		}
+	}
+	collection[summer].AvailableFrom, collection[summer].AvailableTo = "06-01", "08-31"
+	collection[winter].AvailableFrom, collection[winter].AvailableTo = "11-15", "02-15"
+	if err := collection.Validate(); err != nil {
+		t.Fatalf("Expected availability windows to be valid, got: %v", err)
+	}
+
+	served := 0
+	for month := time.January; month <= time.December; month++ {
+		for i, meal := range collection.GenerateMealsWholeYearNoCategories(*calendar.NewCalendar(2024, month)) {
+			date := time.Date(2024, month, i+1, 0, 0, 0, 0, time.UTC)
+			switch meal.BaseName() {
+			case collection[summer].Name, collection[winter].Name:
+				served++
+				if !meal.AvailableOn(date) {
+					t.Errorf("Expected '%s' to be skipped on %s", meal.Name, date.Format(time.DateOnly))
+				}
+			}
+		}
+	}
+	if served == 0 {
+		t.Errorf("Expected seasonal meals to be served within their windows")
+	}
+
+	wrapped := Meal{AvailableFrom: "11-15", AvailableTo: "02-15"}
+	if !wrapped.AvailableOn(time.Date(2025, time.January, 10, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected a window wrapping the new year to include January")
+	}
+	if wrapped.AvailableOn(time.Date(2025, time.July, 10, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected a window wrapping the new year to exclude July")
+	}
+
+	for _, invalid := range []Meal{
+		{Name: "Half", AvailableFrom: "06-01"},
+		{Name: "Bad", AvailableFrom: "13-01", AvailableTo: "02-01"},
+	} {
+		if err := (MealCollection{invalid}).Validate(); err == nil {
+			t.Errorf("Expected availability of '%s' to be rejected", invalid.Name)
+		}
+	}
+}
+
+func TestGenerateMealsWholeYearWeekConstraints(t *testing.T) {
+	mealData, err := OpenMealData(MEALS_JSON)
+	if err != nil {
+		log.Fatalf("Error fetching mealData: %v", err)
+	}
+
+	collection, err := ReadMealCollectionFromReader(mealData)
+	if err != nil {
+		t.Errorf("Something went wrong reading meals... %s", err)
+	}
+
+	for i := range collection {
+		switch i % 4 {
+		case 0:
+			collection[i].Tags = []string{"vegetarian"}
+		case 1:
+			collection[i].Tags = []string{"vegetarian", "long-prep"}
+		case 2:
+			collection[i].Tags = []string{"long-prep"}
+		}
+	}
+
+	maxLongPrep := 1
+	constraints := []WeekConstraint{
+		{Tag: "vegetarian", Min: 3},
+		{Tag: "Long-Prep", Max: &maxLongPrep, Weekdays: true},
+	}
+	for _, constraint := range constraints {
+		if err := constraint.IsValid(); err != nil {
+			t.Fatalf("Expected constraint on '%s' to be valid, got: %v", constraint.Tag, err)
+		}
+	}
+
+	opts := GenerateOptions{Constraints: constraints}
+	var dates []time.Time
+	var meals []Meal
+	for month := time.January; month <= time.December; month++ {
+		for i, meal := range collection.GenerateMealsWholeYear(*calendar.NewCalendar(2024, month), opts) {
+			dates = append(dates, time.Date(2024, month, i+1, 0, 0, 0, 0, time.UTC))
+			meals = append(meals, meal)
+		}
+	}
+
+	vegetarian, longPrep := map[time.Time]int{}, map[time.Time]int{}
+	for i, meal := range meals {
+		week := weekStart(dates[i])
+		if meal.HasTag("vegetarian") {
+			vegetarian[week]++
+		}
+		weekday := dates[i].Weekday()
+		if meal.HasTag("long-prep") && weekday != time.Saturday && weekday != time.Sunday {
+			longPrep[week]++
+		}
+	}
+
+	// Only check the full weeks of the year
+	for week := weekStart(dates[0]).AddDate(0, 0, 7); week.AddDate(0, 0, 6).Year() == 2024; week = week.AddDate(0, 0, 7) {
+		if vegetarian[week] < 3 {
+			t.Errorf("Expected at least 3 vegetarian meals the week of %s, got %d", week.Format(time.DateOnly), vegetarian[week])
+		}
+		if longPrep[week] > 1 {
+			t.Errorf("Expected at most 1 long-prep weekday meal the week of %s, got %d", week.Format(time.DateOnly), longPrep[week])
+		}
+	}
+
+	for _, invalid := range []WeekConstraint{
+		{Tag: "vegetarian"},
+		{Tag: "quick", Min: 6, Weekdays: true},
+		{Tag: "quick", Min: 2, Max: &maxLongPrep},
+	} {
+		if err := invalid.IsValid(); err == nil {
+			t.Errorf("Expected constraint %+v to be rejected", invalid)
+		}
+	}
+}
+
+func TestGenerationModeIsValid(t *testing.T) {
+	for _, mode := range []GenerationMode{"", GenerationModeNoCategories, GenerationModeCategories, GenerationModeRated} {
+		if err := mode.IsValid(); err != nil {
+			t.Errorf("Expected mode '%s' to be valid, got: %v", mode, err)
+		}
+	}
+
+	if err := GenerationMode("bogus").IsValid(); err == nil {
+		t.Errorf("Expected mode 'bogus' to be invalid")
+	}
+}
+
+func TestGenerateMealsWholeYearFollowsTemplate(t *testing.T) {
+	mealData, err := OpenMealData(MEALS_JSON)
+	if err != nil {
+		log.Fatalf("Error fetching mealData: %v", err)
+	}
+
+	collection, err := ReadMealCollectionFromReader(mealData)
+	if err != nil {
+		t.Errorf("Something went wrong reading meals... %s", err)
+	}
+
+	template, err := NewWeeklyTemplate(map[string]DayRule{
+		"monday":   {Meal: "Pizza night"},
+		"Sat":      {Category: "breakfast"},
+		"thursday": {},
+	})
+	if err != nil {
+		t.Fatalf("Something went wrong building template... %s", err)
+	}
+
+	cal := calendar.NewCalendar(2024, time.October)
+	items := collection.GenerateMealsWholeYear(*cal, GenerateOptions{Template: template})
+	if len(items) != 31 {
+		t.Fatalf("Expected length of list: '%d', got: '%d'", 31, len(items))
+	}
+
+	for i, item := range items {
+		switch cal.GetWeekday(i + 1) {
+		case time.Monday:
+			if item.Name != "Pizza night" {
+				t.Errorf("Expected 'Pizza night' on day %d, got: '%s'", i+1, item.Name)
+			}
+		case time.Saturday:
+			if item.Category == nil || *item.Category != "breakfast" {
+				t.Errorf("Expected a breakfast meal on day %d, got: '%s'", i+1, item.Name)
+			}
+		case time.Thursday, time.Friday:
+			if item.Name == MEAL_LEFTOVERS.Name || item.Name == MEAL_OUT.Name {
+				t.Errorf("Expected a generated meal on day %d, got: '%s'", i+1, item.Name)
+			}
+		}
+	}
+}
+
+func TestWeeklyTemplateUnknownCategories(t *testing.T) {
+	mealData, err := OpenMealData(MEALS_JSON)
+	if err != nil {
+		log.Fatalf("Error fetching mealData: %v", err)
+	}
+
+	collection, err := ReadMealCollectionFromReader(mealData)
+	if err != nil {
+		t.Errorf("Something went wrong reading meals... %s", err)
+	}
+
+	template := WeeklyTemplate{
+		time.Monday:  {Category: "Thai"},
+		time.Tuesday: {Category: "italy"},
+	}
+	unknown := template.UnknownCategories(collection)
+	if !reflect.DeepEqual(unknown, []time.Weekday{time.Monday}) {
+		t.Errorf("Expected only Monday's category to be unknown, got: %v", unknown)
+	}
+
+	// Generation still serves a meal on the days of the unknown category
+	cal := calendar.NewCalendar(2024, time.October)
+	items := collection.GenerateMealsWholeYear(*cal, GenerateOptions{Template: template})
+	if len(items) != cal.DaysInMonth() {
+		t.Errorf("Expected %d meals, got %d", cal.DaysInMonth(), len(items))
+	}
+}
+
+func TestGenerateMealsWholeYearRespectsPrepTime(t *testing.T) {
+	mealData, err := OpenMealData(MEALS_JSON)
+	if err != nil {
+		log.Fatalf("Error fetching mealData: %v", err)
+	}
+
+	collection, err := ReadMealCollectionFromReader(mealData)
+	if err != nil {
+		t.Errorf("Something went wrong reading meals... %s", err)
+	}
+
+	for i := range collection {
+		collection[i].PrepMinutes = 15 * (i%6 + 1)
+		collection[i].ActiveMinutes = 10
+	}
+
+	template, err := NewWeeklyTemplate(map[string]DayRule{
+		"monday":    {MaxPrepMinutes: 30},
+		"tuesday":   {MaxPrepMinutes: 30},
+		"wednesday": {MaxPrepMinutes: 30},
 	})
+	if err != nil {
+		t.Fatalf("Something went wrong building template... %s", err)
+	}
+
+	cal := calendar.NewCalendar(2024, time.October)
+	items := collection.GenerateMealsWholeYear(*cal, GenerateOptions{Template: template})
+
+	longMeals := 0
+	for i, item := range items {
+		switch cal.GetWeekday(i + 1) {
+		case time.Monday, time.Tuesday, time.Wednesday:
+			if item.PrepMinutes > 30 {
+				t.Errorf("Expected at most 30 minutes of prep on day %d, got '%s' at %d", i+1, item.Name, item.PrepMinutes)
+			}
+		default:
+			if item.PrepMinutes > 30 {
+				longMeals++
+			}
+		}
+	}
+	if longMeals == 0 {
+		t.Errorf("Expected longer meals on days without a limit")
+	}
+}
+
+func TestNewWeeklyTemplateInvalid(t *testing.T) {
+	invalid := []map[string]DayRule{
+		{"someday": {}},
+		{"monday": {Meal: "Out", Category: "Italy"}},
+		{"monday": {}, "Mon": {}},
+		{"monday": {MaxPrepMinutes: -1}},
+		{"monday": {Meal: "Out", MaxPrepMinutes: 30}},
+	}
+
+	for _, rules := range invalid {
+		if _, err := NewWeeklyTemplate(rules); err == nil {
+			t.Errorf("Expected an error for rules %v", rules)
+		}
+	}
+}
+
+func TestMealsForDatesAppliesMealPlan(t *testing.T) {
+	mealData, err := OpenMealData(MEALS_JSON)
+	if err != nil {
+		log.Fatalf("Error fetching mealData: %v", err)
+	}
+
+	collection, err := ReadMealCollectionFromReader(mealData)
+	if err != nil {
+		t.Errorf("Something went wrong reading meals... %s", err)
+	}
+
+	// A week spanning October and November
+	var dates []time.Time
+	for d := 27; d <= 33; d++ {
+		dates = append(dates, time.Date(2024, time.October, d, 0, 0, 0, 0, time.UTC))
+	}
+
+	generated := collection.MealsForDates(dates, GenerateOptions{}, Schedule{})
+	october := collection.GenerateMealsWholeYearNoCategories(*calendar.NewCalendar(2024, time.October))
+	november := collection.GenerateMealsWholeYearNoCategories(*calendar.NewCalendar(2024, time.November))
+	if generated[0].Name != october[26].Name || generated[6].Name != november[1].Name {
+		t.Errorf("Expected generated meals to match their months, got: '%s' and '%s'", generated[0].Name, generated[6].Name)
+	}
+
+	plan := []PlannedMeal{
+		{Date: dates[1], Meal: "lasagna"},
+		{Date: dates[6], Meal: MEAL_OUT.Name},
+		{Date: time.Date(2024, time.December, 1, 0, 0, 0, 0, time.UTC), Meal: "pasta"},
+		// Only overrides the servings, so the generated meal stays
+		{Date: dates[3], Servings: 6},
+	}
+	planned := collection.MealsForDates(dates, GenerateOptions{}, Schedule{Plan: plan})
+	for i := range dates {
+		switch i {
+		case 1:
+			if planned[i].Name != "lasagna" || planned[i].Category == nil {
+				t.Errorf("Expected pinned recipe 'lasagna', got: '%s'", planned[i].Name)
+			}
+		case 6:
+			if planned[i].Name != MEAL_OUT.Name {
+				t.Errorf("Expected pinned '%s', got: '%s'", MEAL_OUT.Name, planned[i].Name)
+			}
+		default:
+			if planned[i].Name != generated[i].Name {
+				t.Errorf("Expected unpinned day %d to keep '%s', got: '%s'", i, generated[i].Name, planned[i].Name)
+			}
+		}
+	}
+}
+
+func TestMealsForDatesFreezesSnapshottedDays(t *testing.T) {
+	mealData, err := OpenMealData(MEALS_JSON)
+	if err != nil {
+		log.Fatalf("Error fetching mealData: %v", err)
+	}
+
+	collection, err := ReadMealCollectionFromReader(mealData)
+	if err != nil {
+		t.Errorf("Something went wrong reading meals... %s", err)
+	}
+
+	var dates []time.Time
+	for d := 14; d <= 20; d++ {
+		dates = append(dates, time.Date(2024, time.October, d, 0, 0, 0, 0, time.UTC))
+	}
+	var schedule Schedule
+
+	// The first three days are served, so they're snapshotted
+	before := collection.MealsForDates(dates, GenerateOptions{}, schedule)
+	schedule.Snapshot = schedule.SnapshotUpdates(dates[:3], before[:3])
+	if len(schedule.Snapshot) != 3 {
+		t.Fatalf("Expected 3 snapshot entries, got: %d", len(schedule.Snapshot))
+	}
+
+	// Adding a recipe reshuffles the year, but only days that aren't snapshotted may change
+	changed := append(collection.DeepCopy(), Meal{Name: "aaa new recipe"})
+	after := changed.MealsForDates(dates, GenerateOptions{}, schedule)
+	regenerated := changed.MealsForDates(dates, GenerateOptions{}, Schedule{})
+	for i := range dates {
+		if i < 3 && after[i].Name != before[i].BaseName() {
+			t.Errorf("Expected snapshotted day %d to stay '%s', got: '%s'", i, before[i].BaseName(), after[i].Name)
+		}
+		if i >= 3 && after[i].Name != regenerated[i].Name {
+			t.Errorf("Expected day %d to be re-planned as '%s', got: '%s'", i, regenerated[i].Name, after[i].Name)
+		}
+	}
+
+	// Days that are already snapshotted are never updated
+	updates := schedule.SnapshotUpdates(dates, after)
+	if len(updates) != len(dates)-3 || !updates[0].Date.Equal(dates[3]) {
+		t.Errorf("Expected updates for the %d days not snapshotted, got: %v", len(dates)-3, updates)
+	}
+}
+
+func TestGenerateMealsWholeYearConcurrently(t *testing.T) {
+	mealData, err := OpenMealData(MEALS_JSON)
+	if err != nil {
+		log.Fatalf("Error fetching mealData: %v", err)
+	}
+
+	collection, err := ReadMealCollectionFromReader(mealData)
+	if err != nil {
+		t.Errorf("Something went wrong reading meals... %s", err)
+	}
+
+	opts := GenerateOptions{Mode: GenerationModeRated}
+	expected := map[int][]Meal{}
+	for _, year := range []int{2024, 2025} {
+		expected[year] = collection.GenerateMealsWholeYear(*calendar.NewCalendar(year, time.October), opts)
+	}
+
+	// Generating calendars at the same time, like the backend's snapshot job and a
+	// request can, still generates the same meals
+	var wg sync.WaitGroup
+	results := make([][]Meal, 8)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = collection.GenerateMealsWholeYear(*calendar.NewCalendar(2024+i%2, time.October), opts)
+		}(i)
+	}
+	wg.Wait()
+
+	for i, got := range results {
+		if !reflect.DeepEqual(got, expected[2024+i%2]) {
+			t.Errorf("Expected concurrent generation %d to match generating alone", i)
+		}
+	}
+}
+
+func TestMealsToIngredients(t *testing.T) {
+	// Sample input data
+	meals := []Meal{
+		{
+			Name: "Pizza",
+			Ingredients: []Ingredient{
+				{Name: "Cheese", Quantity: 2, Unit: UnitCount, Aisle: AisleCheeseAndBakery},
+				{Name: "Tomato Sauce", Quantity: 1, Unit: UnitCup, Aisle: AisleProduce},
+			},
+		},
+		{
+			Name: "Burger",
+			Ingredients: []Ingredient{
+				{Name: "Cheese", Quantity: 2, Unit: UnitCount, Aisle: AisleCheeseAndBakery},
+				{Name: "Bun", Quantity: 4, Unit: UnitCount, Aisle: AisleCheeseAndBakery},
+				{Name: "Beef", Quantity: 1, Unit: UnitLb, Aisle: AisleMeatAndYogurt},
+			},
+		},
+	}
+
+	got := MealsToIngredients(meals)
+	want := []Ingredient{
+		{
+			Name:         "Bun",
+			Unit:         UnitCount,
+			Quantity:     4,
+			Aisle:        AisleCheeseAndBakery,
+			RelatedMeals: []string{"Burger"},
+		},
+		{
+			Name:         "Cheese",
+			Unit:         UnitCount,
+			Quantity:     4, // 2 + 2
+			Aisle:        AisleCheeseAndBakery,
+			RelatedMeals: []string{"Burger", "Pizza"},
+		},
+		{
+			Name:         "Tomato Sauce",
+			Unit:         UnitCup,
+			Quantity:     1,
+			Aisle:        AisleProduce,
+			RelatedMeals: []string{"Pizza"},
+		},
+		{
+			Name:         "Beef",
+			Unit:         UnitLb,
+			Quantity:     1,
+			Aisle:        AisleMeatAndYogurt,
+			RelatedMeals: []string{"Burger"},
+		},
+	}
+
+	sortIngredients(got)
+	sortIngredients(want)
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("MealsToIngredients() = %#v;\nwant %#v", got, want)
+	}
+}
+
+func TestMealsToIngredientsMergesUnits(t *testing.T) {
+	meals := []Meal{
+		{
+			Name: "Stir Fry",
+			Ingredients: []Ingredient{
+				{Name: "Rice", Quantity: 1, Unit: UnitCup, Aisle: AislePastaGlobalCanned},
+				{Name: "Soy Sauce", Quantity: 2, Unit: UnitTbsp, Aisle: AislePastaGlobalCanned},
+				{Name: "Chicken", Quantity: 8, Unit: UnitOz, Aisle: AisleMeatAndYogurt},
+			},
+		},
+		{
+			Name: "Burrito",
+			Ingredients: []Ingredient{
+				{Name: "Rice", Quantity: 200, Unit: UnitGram, Aisle: AislePastaGlobalCanned},
+				{Name: "Soy Sauce", Quantity: 3, Unit: UnitTsp, Aisle: AislePastaGlobalCanned},
+				{Name: "Chicken", Quantity: 1, Unit: UnitLb, Aisle: AisleMeatAndYogurt},
+				{Name: "Tortilla", Quantity: 4, Unit: UnitCount, Aisle: AisleCheeseAndBakery},
+			},
+		},
+	}
+
+	byName := func(ings []Ingredient) map[string][]Ingredient {
+		m := map[string][]Ingredient{}
+		for _, ing := range ings {
+			m[ing.Name] = append(m[ing.Name], ing)
+		}
+		return m
+	}
+	approxEqual := func(a, b float64) bool {
+		return math.Abs(a-b) < 1e-9
+	}
+
+	// Without preferences, rice stays split since volume and mass can't be converted
+	got := byName(MealsToIngredients(meals))
+	if len(got["Rice"]) != 2 {
+		t.Errorf("Expected rice to stay split by volume and mass, got: %v", got["Rice"])
+	}
+	if soy := got["Soy Sauce"]; len(soy) != 1 || soy[0].Unit != UnitTbsp || !approxEqual(soy[0].Quantity, 3) {
+		t.Errorf("Expected 3 tbsp soy sauce, got: %v", soy)
+	}
+	if chicken := got["Chicken"]; len(chicken) != 1 || chicken[0].Unit != UnitLb || !approxEqual(chicken[0].Quantity, 1.5) {
+		t.Errorf("Expected 1.5 lb chicken, got: %v", chicken)
+	}
+	if chicken := got["Chicken"]; len(chicken) == 1 && !reflect.DeepEqual(chicken[0].RelatedMeals, []string{"Burrito", "Stir Fry"}) {
+		t.Errorf("Expected chicken for both meals, got: %v", chicken[0].RelatedMeals)
+	}
+
+	// With a density, rice merges into the preferred unit
+	got = byName(MealsToIngredientsWithUnits(meals, UnitPreferences{
+		"rice":    {Preferred: UnitGram, GramsPerCup: 185},
+		"chicken": {Preferred: UnitOz},
+	}))
+	if rice := got["Rice"]; len(rice) != 1 || rice[0].Unit != UnitGram || !approxEqual(rice[0].Quantity, 385) {
+		t.Errorf("Expected 385 gram rice, got: %v", rice)
+	}
+	if chicken := got["Chicken"]; len(chicken) != 1 || chicken[0].Unit != UnitOz || !approxEqual(chicken[0].Quantity, 24) {
+		t.Errorf("Expected 24 oz chicken, got: %v", chicken)
+	}
+	if tortilla := got["Tortilla"]; len(tortilla) != 1 || tortilla[0].Unit != UnitCount || tortilla[0].Quantity != 4 {
+		t.Errorf("Expected 4 count tortilla, got: %v", tortilla)
+	}
+}
+
+// sortIngredients sorts by Aisle, then by Name, for consistent comparison.
+func sortIngredients(ings []Ingredient) {
+	sort.Slice(ings, func(i, j int) bool {
+		if ings[i].Aisle != ings[j].Aisle {
+			return ings[i].Aisle < ings[j].Aisle
+		}
+		return ings[i].Name < ings[j].Name
+	})
+}
+
+func TestAisleValidationUsesConfig(t *testing.T) {
+	original := config.Cfg.App.Aisles
+	defer func() { config.Cfg.App.Aisles = original }()
+
+	config.Cfg.App.Aisles = []string{"Produce", "Cheese & Bakery"}
+	if err := Aisle("Produce").IsValid(); err != nil {
+		t.Errorf("Expected configured aisle to be valid, got %v", err)
+	}
+	if err := Aisle("Prodcue").IsValid(); err == nil {
+		t.Errorf("Expected unknown aisle to be invalid")
+	}
+
+	mealData, err := OpenMealData(MEALS_JSON)
+	if err != nil {
+		log.Fatalf("Error fetching mealData: %v", err)
+	}
+	collection, err := ReadMealCollectionFromReader(mealData)
+	if err != nil {
+		t.Fatalf("Expected recipes with unconfigured aisles to be read, got %v", err)
+	}
+	for _, meal := range collection {
+		for _, ing := range meal.Ingredients {
+			if ing.Aisle != "Produce" && ing.Aisle != "Cheese & Bakery" && ing.Aisle != AisleUnsorted {
+				t.Errorf("Expected '%s' of '%s' in an unconfigured aisle to be unsorted, got '%s'", ing.Name, meal.Name, ing.Aisle)
+			}
+		}
+	}
+	if err := AisleUnsorted.IsValid(); err != nil {
+		t.Errorf("Expected the unsorted aisle to always be valid, got %v", err)
+	}
+
+	file := RecipeFile{
+		Recipes:     MealCollection{{Name: "Salad", Ingredients: []Ingredient{{Name: "Lettuce", Aisle: "Greens"}}}},
+		Ingredients: IngredientCatalog{{Name: "Lettuce", Aisle: "Greens"}},
+	}
+	unsorted := file.UnsortUnknownAisles()
+	if unsorted.Recipes[0].Ingredients[0].Aisle != AisleUnsorted || unsorted.Ingredients[0].Aisle != AisleUnsorted {
+		t.Errorf("Expected unknown aisles to be unsorted, got %+v", unsorted)
+	}
+	if file.Recipes[0].Ingredients[0].Aisle != "Greens" || file.Ingredients[0].Aisle != "Greens" {
+		t.Errorf("Expected the original file to be left untouched, got %+v", file)
+	}
+
+	updates := []FEExtraItem{{Action: Add, New: FEItem{Name: "Napkins", Aisle: "Paper"}}}
+	if err := ValidateExtraItemUpdates(updates); err == nil {
+		t.Errorf("Expected extra item with unconfigured aisle to be rejected")
+	}
+
+	config.Cfg.App.Aisles = nil
+	if err := Aisle("Anything").IsValid(); err != nil {
+		t.Errorf("Expected any aisle to be valid without configured aisles, got %v", err)
+	}
+}
+
+func TestScaleMealsToServings(t *testing.T) {
+	chili := Meal{
+		Name:     "Chili",
+		Servings: 4,
+		Ingredients: []Ingredient{
+			{Name: "Beans", Quantity: 2, Unit: UnitCount, Aisle: AislePastaGlobalCanned},
+			{Name: "Beef", Quantity: 1, Unit: UnitLb, Aisle: AisleMeatAndYogurt},
+		},
+	}
+	unknown := Meal{
+		Name:        "Toast",
+		Ingredients: []Ingredient{{Name: "Bread", Quantity: 1, Unit: UnitCount, Aisle: AisleCheeseAndBakery}},
+	}
+
+	dates := []time.Time{
+		time.Date(2025, 3, 3, 0, 0, 0, 0, time.UTC),
+		time.Date(2025, 3, 4, 0, 0, 0, 0, time.UTC),
+	}
+	plan := []PlannedMeal{{Date: dates[1], Meal: "Toast", Servings: 6}}
+	servings := ServingsForDates(plan, dates, 2)
+	if !reflect.DeepEqual(servings, []int{2, 6}) {
+		t.Fatalf("Expected servings [2 6], got %v", servings)
+	}
+
+	scaled := ScaleMeals([]Meal{chili, unknown}, servings)
+	if scaled[0].Ingredients[0].Quantity != 1 || scaled[0].Ingredients[1].Quantity != 0.5 {
+		t.Errorf("Expected Chili halved to 2 servings, got %+v", scaled[0].Ingredients)
+	}
+	if scaled[1].Ingredients[0].Quantity != 1 {
+		t.Errorf("Expected meal without servings to be left alone, got %+v", scaled[1].Ingredients)
+	}
+	if chili.Ingredients[0].Quantity != 2 {
+		t.Errorf("Expected original meal to be unchanged, got %+v", chili.Ingredients)
+	}
+
+	if err := validateMealCollection(MealCollection{{Name: "Bad", Servings: -1}}); err == nil {
+		t.Errorf("Expected negative servings to be rejected")
+	}
+}
+
+func TestLinkLeftovers(t *testing.T) {
+	var dates []time.Time
+	for d := 1; d <= 7; d++ {
+		// 2024-09-01 is a Sunday
+		dates = append(dates, time.Date(2024, time.September, d, 0, 0, 0, 0, time.UTC))
+	}
+	chili := Meal{
+		Name:        "Chili",
+		Yield:       2,
+		Ingredients: []Ingredient{{Name: "Beans", Quantity: 2, Unit: UnitCount, Aisle: AislePastaGlobalCanned}},
+	}
+	soup := Meal{Name: "Soup", Yield: 3}
+	meals := []Meal{{Name: "Tacos"}, chili, soup, MEAL_LEFTOVERS, MEAL_LEFTOVERS, MEAL_LEFTOVERS, MEAL_LEFTOVERS}
+
+	linked := LinkLeftovers(dates, meals)
+	expected := []string{
+		"Tacos",
+		"Chili",
+		"Soup",
+		"Leftovers: Soup (from Tue)",
+		"Leftovers: Soup (from Tue)",
+		"Leftovers: Chili (from Mon)",
+		"Leftovers",
+	}
+	for i, meal := range linked {
+		if meal.DisplayName() != expected[i] {
+			t.Errorf("Expected '%s' on day %d, got '%s'", expected[i], i, meal.DisplayName())
+		}
+	}
+	if meals[3].LeftoversOf != nil {
+		t.Errorf("Expected the original meals to be left untouched")
+	}
+
+	scaled := ScaleMeals(linked, nil)
+	if scaled[1].Ingredients[0].Quantity != 2 {
+		t.Errorf("Expected chili with its leftovers planned to be bought in full, got %+v", scaled[1].Ingredients)
+	}
+
+	alone := ScaleMeals(LinkLeftovers(dates[:2], meals[:2]), nil)
+	if alone[1].LeftoverDays != 0 || alone[1].Ingredients[0].Quantity != 2 {
+		t.Errorf("Expected chili without leftovers to still be bought in full, got %+v", alone[1].Ingredients)
+	}
+}
+
+func TestSubtractPantry(t *testing.T) {
+	ingredients := []Ingredient{
+		{Name: "Rice", Quantity: 3, Unit: UnitCup, Aisle: AislePastaGlobalCanned},
+		{Name: "Chicken", Quantity: 2, Unit: UnitLb, Aisle: AisleMeatAndYogurt},
+		{Name: "Eggs", Quantity: 6, Unit: UnitCount, Aisle: AisleMeatAndYogurt},
+		{Name: "Salt", Quantity: 1, Unit: UnitTsp, Aisle: AisleBreakfastAndBaking},
+		{Name: "Tortilla", Quantity: 4, Unit: UnitCount, Aisle: AisleCheeseAndBakery},
+	}
+	pantry := []PantryItem{
+		{Name: "rice", Quantity: 1, Unit: UnitCup},
+		{Name: "Chicken", Quantity: 16, Unit: UnitOz},
+		{Name: "Eggs", Quantity: 2, Unit: UnitLb},
+		{Name: "Salt", Staple: true},
+	}
+
+	result := SubtractPantry(ingredients, pantry, nil)
+	byName := map[string]Ingredient{}
+	for _, ing := range result {
+		byName[ing.Name] = ing
+	}
+
+	if byName["Rice"].Quantity != 2 {
+		t.Errorf("Expected 2 cups of rice left to buy, got %+v", byName["Rice"])
+	}
+	if chicken, found := byName["Chicken"]; !found || chicken.Quantity != 1 || chicken.Unit != UnitLb {
+		t.Errorf("Expected 1 lb of chicken left to buy after converting oz, got %+v", chicken)
+	}
+	if byName["Eggs"].Quantity != 6 {
+		t.Errorf("Expected eggs with mismatched units to be untouched, got %+v", byName["Eggs"])
+	}
+	if salt := byName["Salt"]; !salt.CheckStock || salt.Quantity != 1 {
+		t.Errorf("Expected salt to be a check-stock staple, got %+v", salt)
+	}
+	if byName["Tortilla"].CheckStock || byName["Tortilla"].Quantity != 4 {
+		t.Errorf("Expected tortillas to be untouched, got %+v", byName["Tortilla"])
+	}
+
+	covered := SubtractPantry(ingredients[:1], []PantryItem{{Name: "Rice", Quantity: 5, Unit: UnitCup}}, nil)
+	if len(covered) != 0 {
+		t.Errorf("Expected fully stocked rice to be dropped, got %+v", covered)
+	}
+
+	// Quantity-less ingredients are never dropped, only checked when on hand
+	toTaste := []Ingredient{
+		{Raw: "garlic to taste", Name: "garlic", Aisle: AisleProduce},
+		{Raw: "cilantro to taste", Name: "cilantro", Aisle: AisleProduce},
+		{Raw: "basil to taste", Name: "basil", Aisle: AisleProduce},
+		{Raw: "pepper to taste", Name: "pepper", Aisle: AisleProduce},
+		{Raw: "oregano to taste", Name: "oregano", Aisle: AisleProduce},
+	}
+	toTastePantry := []PantryItem{
+		{Name: "Garlic", Quantity: 3, Unit: UnitCount},
+		{Name: "Cilantro"},
+		{Name: "Basil", Quantity: 0, Unit: UnitCount},
+		{Name: "Pepper", Quantity: 1},
+	}
+	checked := SubtractPantry(toTaste, toTastePantry, nil)
+	if len(checked) != len(toTaste) {
+		t.Fatalf("Expected every quantity-less ingredient to be kept, got %+v", checked)
+	}
+	for i, expected := range []bool{true, true, false, true, false} {
+		if checked[i].CheckStock != expected {
+			t.Errorf("Expected %s to have CheckStock %v, got %+v", checked[i].Name, expected, checked[i])
+		}
+	}
+
+	if err := (PantryItem{Name: "Flour", Quantity: 2}).Validate(); err == nil {
+		t.Errorf("Expected pantry quantity without a unit to be rejected")
+	}
+}
+
+func TestEstimateMeal(t *testing.T) {
+	infos := IngredientInfos{
+		{Name: "Chicken", Unit: UnitLb, Price: 4, Calories: 700, Protein: 100},
+		{Name: "rice", Unit: UnitCup, Price: 0.5, Calories: 200, Protein: 4},
+		{Name: "Rice", Unit: UnitOz, Price: 10, Calories: 10, Protein: 10},
+	}
+	meal := Meal{
+		Name: "Chicken and Rice",
+		Ingredients: []Ingredient{
+			{Name: "Chicken", Quantity: 8, Unit: UnitOz},
+			{Name: "Rice", Quantity: 2, Unit: UnitCup},
+			{Name: "Lime", Quantity: 1, Unit: UnitCount},
+			{Raw: "salt to taste", Name: "salt"},
+		},
+	}
+
+	estimate := infos.EstimateMeal(meal, nil)
+	if estimate.Cost != 3 || estimate.Calories != 750 || estimate.Protein != 58 {
+		t.Errorf("Expected $3, 750 kcal and 58 g protein, got %+v", estimate)
+	}
+	if len(estimate.Missing) != 1 || estimate.Missing[0] != "Lime" {
+		t.Errorf("Expected only lime to be missing, got %v", estimate.Missing)
+	}
+
+	// The same ingredient missing from both meals is only listed once
+	total := estimate.Add(infos.EstimateMeal(meal, nil))
+	if total.Cost != 6 || len(total.Missing) != 1 {
+		t.Errorf("Expected estimates to add up, got %+v", total)
+	}
+	total = total.Add(Estimate{Missing: []string{"lime", "Cilantro"}})
+	if !reflect.DeepEqual(total.Missing, []string{"Lime", "Cilantro"}) {
+		t.Errorf("Expected lime and cilantro to be missing, got %v", total.Missing)
+	}
+
+	formats := []struct {
+		estimate Estimate
+		expected string
+	}{
+		{Estimate{Cost: 3, Calories: 750, Protein: 58}, "$3.00 · 750 kcal · 58 g protein"},
+		{estimate, "$3.00 · 750 kcal · 58 g protein (1 ingredient unpriced)"},
+		{total, "$6.00 · 1500 kcal · 116 g protein (2 ingredients unpriced)"},
+	}
+	for _, f := range formats {
+		if f.estimate.String() != f.expected {
+			t.Errorf("Expected estimate format '%s', got '%s'", f.expected, f.estimate)
+		}
+	}
+
+	if err := (IngredientInfo{Name: "Rice", Unit: UnitCup, Price: -1}).Validate(); err == nil {
+		t.Errorf("Expected a negative price to be rejected")
+	}
+}
+
+func TestValidateRecipe(t *testing.T) {
+	catalog := IngredientCatalog{{Name: "onion", Aisle: AisleProduce}}
+
+	valid := Meal{
+		Name:        "Onion Soup",
+		Ingredients: []Ingredient{{Name: "Onion", Quantity: 4, Unit: UnitCount}},
+	}
+	if err := ValidateRecipe(valid, catalog); err != nil {
+		t.Errorf("Expected recipe with a catalog aisle to be valid, got %v", err)
+	}
+	if err := ValidateRecipe(valid, nil); err == nil {
+		t.Errorf("Expected recipe without an aisle to be rejected")
+	}
+
+	unnamed := valid
+	unnamed.Name = " "
+	if err := ValidateRecipe(unnamed, catalog); err == nil {
+		t.Errorf("Expected recipe without a name to be rejected")
+	}
+
+	marked := valid
+	marked.Name = "Onion Soup" + RESHUFFLED_SUFFIX
+	if err := ValidateRecipe(marked, catalog); err == nil {
+		t.Errorf("Expected recipe named with the reshuffle marker to be rejected")
+	}
+}
+
+func TestRecipeFileMarshalRoundTrip(t *testing.T) {
+	for _, path := range []string{MEALS_JSON, "../data/recipes_with_catalog.json"} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatalf("Error reading %s: %v", path, err)
+		}
+		recipeFile, err := decodeRecipeFile(data, true)
+		if err != nil {
+			log.Fatalf("Error decoding %s: %v", path, err)
+		}
+
+		exported, err := recipeFile.Marshal()
+		if err != nil {
+			t.Fatalf("Error marshalling %s: %v", path, err)
+		}
+		if strings.Contains(string(exported), "RelatedMeals") {
+			t.Errorf("Expected exported %s not to contain RelatedMeals", path)
+		}
+
+		original, err := ReadMealCollectionFromReader(io.NopCloser(bytes.NewReader(data)))
+		if err != nil {
+			log.Fatalf("Error reading %s: %v", path, err)
+		}
+		roundTripped, err := ReadMealCollectionFromReader(io.NopCloser(bytes.NewReader(exported)))
+		if err != nil {
+			t.Fatalf("Expected exported %s to be accepted, got %v", path, err)
+		}
+		if !reflect.DeepEqual(original, roundTripped) {
+			t.Errorf("Expected exported %s to read back the same meals", path)
+		}
+	}
+}
+
+func TestParseIngredientLine(t *testing.T) {
+	tests := []struct {
+		Line     string
+		Expected Ingredient
+	}{
+		{Line: "2 cups diced tomato", Expected: Ingredient{Name: "diced tomato", Quantity: 2, Unit: UnitCup}},
+		{Line: "1/2 lb. of ground beef", Expected: Ingredient{Name: "ground beef", Quantity: 0.5, Unit: UnitLb}},
+		{Line: "3 eggs", Expected: Ingredient{Name: "eggs", Quantity: 3, Unit: UnitCount}},
+		{Line: "1.5 tbsp soy sauce", Expected: Ingredient{Name: "soy sauce", Quantity: 1.5, Unit: UnitTbsp}},
+		{Line: "1 1/2 lb ground beef", Expected: Ingredient{Name: "ground beef", Quantity: 1.5, Unit: UnitLb}},
+		{Line: "1½ cups rice", Expected: Ingredient{Name: "rice", Quantity: 1.5, Unit: UnitCup}},
+		{Line: "¾ teaspoon cumin", Expected: Ingredient{Name: "cumin", Quantity: 0.75, Unit: UnitTsp}},
+		{Line: "2 Tablespoons olive oil", Expected: Ingredient{Name: "olive oil", Quantity: 2, Unit: UnitTbsp}},
+		{Line: "2 (15 oz) cans black beans", Expected: Ingredient{Name: "black beans", Quantity: 30, Unit: UnitOz}},
+		{Line: "1 onion, diced", Expected: Ingredient{Name: "onion", Quantity: 1, Unit: UnitCount}},
+		{Line: "1 tsp salt, or to taste", Expected: Ingredient{Name: "salt", Quantity: 1, Unit: UnitTsp}},
+		{Line: "salt to taste", Expected: Ingredient{Name: "salt"}},
+		{Line: "fresh parsley (optional)", Expected: Ingredient{Name: "fresh parsley"}},
+	}
+	for _, test := range tests {
+		ingredient, err := ParseIngredientLine(test.Line)
+		if err != nil {
+			t.Errorf("Expected '%s' to parse, got %v", test.Line, err)
+			continue
+		}
+		if !reflect.DeepEqual(ingredient, test.Expected) {
+			t.Errorf("Expected '%s' to parse as %+v, got %+v", test.Line, test.Expected, ingredient)
+		}
+	}
+
+	for _, line := range []string{"", "to taste", "2 cups"} {
+		if _, err := ParseIngredientLine(line); err == nil {
+			t.Errorf("Expected '%s' not to parse", line)
+		}
+	}
+}
+
+func TestImportRecipe(t *testing.T) {
+	mealData, err := OpenMealData(MEALS_JSON)
+	if err != nil {
+		log.Fatalf("Error fetching mealData: %v", err)
+	}
+	collection, err := ReadMealCollectionFromReader(mealData)
+	if err != nil {
+		log.Fatalf("Error reading meals: %v", err)
+	}
+	data, err := os.ReadFile("../data/recipe_import.html")
+	if err != nil {
+		log.Fatalf("Error reading recipe page: %v", err)
+	}
+	catalog := IngredientCatalog{{Name: "onion", Aliases: []string{"yellow onion"}, Aisle: AisleProduce}}
+
+	imported, err := ImportRecipe(data, collection, catalog)
+	if err != nil {
+		t.Fatalf("Expected recipe page to import, got %v", err)
+	}
+
+	recipe := imported.Recipe
+	if recipe.Name != "Weeknight Chili" || recipe.Servings != 6 {
+		t.Errorf("Expected Weeknight Chili serving 6, got '%s' serving %d", recipe.Name, recipe.Servings)
+	}
+	if recipe.URL == nil || *recipe.URL != "https://example.com/weeknight-chili" {
+		t.Errorf("Expected recipe URL to be imported, got %v", recipe.URL)
+	}
+	if recipe.Category == nil || *recipe.Category != "Soupy liquid" {
+		t.Errorf("Expected first recipe category to be imported, got %v", recipe.Category)
+	}
+
+	byName := map[string]Ingredient{}
+	for _, ing := range recipe.Ingredients {
+		byName[ing.Name] = ing
+	}
+	if len(recipe.Ingredients) != 6 {
+		t.Errorf("Expected 6 parsed ingredients, got %+v", recipe.Ingredients)
+	}
+	if cream := byName["heavy cream"]; cream.Quantity != 0.5 || cream.Unit != UnitCup {
+		t.Errorf("Expected 1/2 cup of heavy cream with markup removed, got %+v", cream)
+	}
+	if onion := byName["onion"]; onion.Quantity != 3 || onion.Aisle != AisleProduce {
+		t.Errorf("Expected yellow onion resolved through the catalog, got %+v", onion)
+	}
+
+	if love := byName["a pinch of love"]; !love.QuantityLess() {
+		t.Errorf("Expected a quantity-less ingredient, got %+v", love)
+	}
+	var unsorted bool
+	for _, warning := range imported.Warnings {
+		if strings.Contains(warning, "a pinch of love") {
+			unsorted = true
+		}
+	}
+	if !unsorted {
+		t.Errorf("Expected a warning for the ingredient without an aisle, got %v", imported.Warnings)
+	}
+
+	if aisle, ok := collection.GuessAisle("chopped onion"); !ok || aisle != AisleProduce {
+		t.Errorf("Expected chopped onion to be guessed into Produce, got '%s'", aisle)
+	}
+	if _, ok := collection.GuessAisle("dragon fruit"); ok {
+		t.Errorf("Expected no aisle guess for an unused ingredient")
+	}
+
+	if _, err := ImportRecipe([]byte("<html><body>No recipe</body></html>"), collection, nil); err == nil {
+		t.Errorf("Expected a page without JSON-LD to be rejected")
+	}
+}
+
+func TestFindSchemaRecipeSkipsBrokenBlocks(t *testing.T) {
+	broken := `<script type="application/ld+json">{"@type": "Organization",</script>`
+	recipe := `<script type="application/ld+json">{"@type": "Recipe", "name": "Chili"}</script>`
+	other := `<script type="application/ld+json">{"@type": "WebSite"}</script>`
+
+	found, err := findSchemaRecipe([]byte("<html>" + broken + recipe + "</html>"))
+	if err != nil {
+		t.Fatalf("Expected the recipe after a broken block to be found, got %v", err)
+	}
+	if found["name"] != "Chili" {
+		t.Errorf("Expected recipe 'Chili', got %v", found["name"])
+	}
+
+	_, err = findSchemaRecipe([]byte("<html>" + broken + other + "</html>"))
+	if err == nil || !strings.Contains(err.Error(), "no schema.org Recipe found") {
+		t.Errorf("Expected no Recipe to be found, got %v", err)
+	}
+
+	_, err = findSchemaRecipe([]byte("<html>" + broken + broken + "</html>"))
+	if err == nil || !strings.Contains(err.Error(), "unmarshalling") {
+		t.Errorf("Expected an unmarshalling error when no block parses, got %v", err)
+	}
+}
+
+func TestMealCollectionReadingRawIngredients(t *testing.T) {
+	data := `[
+		{
+			"name": "Chili",
+			"ingredients": [
+				{"raw": "1 1/2 lb ground beef", "aisle": "Meat & Yogurt"},
+				{"raw": "salt to taste", "aisle": "3-5 (Breakfast & Baking)"},
+				{"item": "Onion", "quantity": 1, "unit": "count", "aisle": "Produce", "raw": "1 onion, diced"}
+			]
+		}
+	]`
+
+	collection, err := ReadMealCollectionFromReader(io.NopCloser(strings.NewReader(data)))
+	if err != nil {
+		t.Fatalf("Expected raw ingredients to be accepted, got %v", err)
+	}
+
+	ingredients := collection[0].Ingredients
+	if ingredients[0].Name != "ground beef" || ingredients[0].Quantity != 1.5 || ingredients[0].Unit != UnitLb || ingredients[0].Aisle != AisleMeatAndYogurt {
+		t.Errorf("Expected raw beef line to be parsed, got %+v", ingredients[0])
+	}
+	if ingredients[1].Name != "salt" || !ingredients[1].QuantityLess() {
+		t.Errorf("Expected quantity-less salt, got %+v", ingredients[1])
+	}
+	if ingredients[2].Name != "Onion" {
+		t.Errorf("Expected explicit item to win over raw, got %+v", ingredients[2])
+	}
+
+	if _, err := UnmarshalRecipeFile([]byte(`[{"name": "Bad", "ingredients": [{"raw": "2 cups", "aisle": "Produce"}]}]`)); err == nil {
+		t.Errorf("Expected an unparseable raw line to be rejected")
+	}
+	if err := validateIngredient(Ingredient{Name: "salt", Aisle: AisleProduce}); err == nil {
+		t.Errorf("Expected quantity-less ingredient without a raw line to be rejected")
+	}
+}
+
+func TestIsUniqueViolation(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{"unique violation", &pgconn.PgError{Code: uniqueViolation}, true},
+		{"wrapped unique violation", fmt.Errorf("rename: %w", &pgconn.PgError{Code: uniqueViolation}), true},
+		{"other constraint", &pgconn.PgError{Code: "23503"}, false},
+		{"other error", errors.New("connection refused"), false},
+		{"no error", nil, false},
+	}
+	for _, tt := range tests {
+		if got := isUniqueViolation(tt.err); got != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.expected, got)
+		}
+	}
+}
+
+func TestSnapshotScheduleInDBKeepsSnapshottedDays(t *testing.T) {
+	dates := []time.Time{time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)}
+	schedule := Schedule{Snapshot: []PlannedMeal{{Date: dates[0], Meal: "A"}}}
+
+	// Every date is already snapshotted, so nothing is written and no database is needed
+	got, err := SnapshotScheduleInDB("", MealCollection{{Name: "A"}, {Name: "B"}}, dates, GenerateOptions{}, schedule)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !reflect.DeepEqual(got, schedule) {
+		t.Errorf("Expected schedule unchanged, got %+v", got)
+	}
+}
+
+func TestMapNameToMealWithTemplate(t *testing.T) {
+	category := "Pasta"
+	collection := MealCollection{{Name: "Spaghetti", Category: &category}}
+	template := WeeklyTemplate{
+		time.Monday:   {Meal: "Spaghetti"},
+		time.Thursday: {Meal: MEAL_LEFTOVERS.Name},
+		time.Friday:   {Meal: "Pizza night"},
+		time.Saturday: {Category: "Soup"},
+	}
+
+	mealMap := collection.MapNameToMealWithTemplate(template)
+	for _, name := range []string{"Spaghetti", MEAL_LEFTOVERS.Name, MEAL_OUT.Name, "Pizza night"} {
+		if _, ok := mealMap[name]; !ok {
+			t.Errorf("Expected %q in the map", name)
+		}
+	}
+	if meal := mealMap["Spaghetti"]; meal.Category == nil || *meal.Category != category {
+		t.Errorf("Expected the recipe for Spaghetti, got %+v", meal)
+	}
+	if _, ok := mealMap["Soup"]; ok {
+		t.Error("Expected category rules not to add meals")
+	}
+
+	// A nil template uses the default one
+	if _, ok := collection.MapNameToMealWithTemplate(nil)[MEAL_OUT.Name]; !ok {
+		t.Errorf("Expected %q in the map for the default template", MEAL_OUT.Name)
+	}
+}
+
+func TestWeightedCycle(t *testing.T) {
+	meals := MealCollection{{Name: "Favorite"}, {Name: "Plain"}, {Name: "Disliked"}, {Name: "Rejected"}}
+	weights := map[string]float64{"Favorite": 2.8, "Disliked": 0.25, "Rejected": 0.1}
+
+	const cycles = 100
+	counts := map[string]int{}
+	for n := 0; n < cycles; n++ {
+		for _, meal := range weightedCycle(meals, weights, n) {
+			counts[meal.Name]++
+		}
+	}
+
+	expected := map[string]int{"Favorite": 200, "Plain": 100, "Disliked": 25, "Rejected": 10}
+	if !reflect.DeepEqual(counts, expected) {
+		t.Errorf("Expected counts %v over %d cycles, got %v", expected, cycles, counts)
+	}
+
+	// Every meal appears once when the weights leave nothing enabled
+	disliked := MealCollection{{Name: "Disliked"}, {Name: "Disabled", Disabled: true}}
+	if cycle := weightedCycle(disliked, weights, 0); len(cycle) != len(disliked) {
+		t.Errorf("Expected every meal once, got %v", cycle)
+	}
+}
+
+func TestMealsForDatesLinksLeftoversAcrossDates(t *testing.T) {
+	chili := Meal{
+		Name:        "Chili",
+		Yield:       2,
+		Ingredients: []Ingredient{{Name: "Beans", Quantity: 2, Unit: UnitCount, Aisle: AislePastaGlobalCanned}},
+	}
+	collection := MealCollection{chili, {Name: "Tacos"}}
+	template := WeeklyTemplate{time.Monday: {Meal: MEAL_LEFTOVERS.Name}}
+	for day := time.Tuesday; day <= time.Saturday; day++ {
+		template[day] = DayRule{Meal: "Tacos"}
+	}
+	opts := GenerateOptions{Template: template}
+
+	// 2024-09-01 is a Sunday, followed by the Monday of leftovers
+	sunday := time.Date(2024, time.September, 1, 0, 0, 0, 0, time.UTC)
+	monday := sunday.AddDate(0, 0, 1)
+	schedule := Schedule{Plan: []PlannedMeal{{Date: sunday, Meal: "Chili"}}}
+
+	cooked := collection.MealsForDates([]time.Time{sunday}, opts, schedule)
+	if cooked[0].LeftoverDays != 1 {
+		t.Errorf("Expected the leftover day after dates to be counted, got %d", cooked[0].LeftoverDays)
+	}
+	if scaled := ScaleMeals(cooked, nil); scaled[0].Ingredients[0].Quantity != 2 {
+		t.Errorf("Expected chili to be bought in full for its leftovers, got %+v", scaled[0].Ingredients)
+	}
+
+	leftovers := collection.MealsForDates([]time.Time{monday}, opts, schedule)
+	if name := leftovers[0].DisplayName(); name != "Leftovers: Chili (from Sun)" {
+		t.Errorf("Expected leftovers tied to the chili before dates, got '%s'", name)
+	}
 }
@@ -0,0 +1,188 @@
+package meal_collection
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"golang.org/x/exp/rand"
+)
+
+// HistoryStatus records whether a meal was actually cooked.
+type HistoryStatus string
+
+const (
+	HistoryCooked  HistoryStatus = "cooked"
+	HistorySkipped HistoryStatus = "skipped"
+)
+
+func (s HistoryStatus) IsValid() error {
+	switch s {
+	case HistoryCooked, HistorySkipped:
+		return nil
+	}
+	return errors.New("invalid history status: " + string(s))
+}
+
+// HistoryEntry is a record of a meal on a date: whether it was cooked or skipped, and
+// optionally how much it was liked. A Rating of 0 means unrated.
+type HistoryEntry struct {
+	ID     int           `json:"id"`
+	Date   time.Time     `json:"date"`
+	Meal   string        `json:"meal"`
+	Status HistoryStatus `json:"status"`
+	Rating int           `json:"rating,omitempty"`
+	Notes  string        `json:"notes,omitempty"`
+}
+
+// Validate checks the entry has a date, a meal, a valid status and a rating of 1-5 if rated.
+func (h HistoryEntry) Validate() error {
+	if h.Date.IsZero() {
+		return errors.New("history date cannot be empty")
+	}
+	if h.Meal == "" {
+		return errors.New("history meal cannot be empty")
+	}
+	if err := h.Status.IsValid(); err != nil {
+		return fmt.Errorf("history of '%s': %v", h.Meal, err)
+	}
+	if h.Rating < 0 || h.Rating > 5 {
+		return fmt.Errorf("history of '%s': rating must be between 1 and 5", h.Meal)
+	}
+	return nil
+}
+
+// RATED_RECENT_DAYS is how many days after a meal was cooked GenerationModeRated avoids it.
+const RATED_RECENT_DAYS = 14
+
+// mealWeights scores every meal in history for GenerationModeRated. An unrated meal
+// weighs 1, the same as a 3 star average; the weight grows with the square of the
+// average rating, from about 0.1 at 1 star to about 2.8 at 5 stars. Each skip halves
+// the weight. Meals without history aren't in the map and weigh 1.
+func mealWeights(history []HistoryEntry) map[string]float64 {
+	type stats struct {
+		ratingSum, ratings, skips int
+	}
+
+	byMeal := map[string]*stats{}
+	for _, entry := range history {
+		s, ok := byMeal[entry.Meal]
+		if !ok {
+			s = &stats{}
+			byMeal[entry.Meal] = s
+		}
+		if entry.Status == HistorySkipped {
+			s.skips++
+		}
+		if entry.Rating > 0 {
+			s.ratingSum += entry.Rating
+			s.ratings++
+		}
+	}
+
+	weights := make(map[string]float64, len(byMeal))
+	for meal, s := range byMeal {
+		weight := 1.0
+		if s.ratings > 0 {
+			average := float64(s.ratingSum) / float64(s.ratings)
+			weight = (average / 3) * (average / 3)
+		}
+		weights[meal] = weight * math.Pow(0.5, float64(s.skips))
+	}
+	return weights
+}
+
+// weightOf returns the weight of meal, defaulting to 1.
+func weightOf(weights map[string]float64, meal Meal) float64 {
+	if weight, ok := weights[meal.BaseName()]; ok {
+		return weight
+	}
+	return 1
+}
+
+// weightedCycle returns the meals of generation cycle n. Meals weighing 2 or more, i.e.
+// favorites, appear twice. Meals weighing less than 1 only appear in that fraction of
+// cycles, spread evenly, so a meal weighing 0.25 appears every fourth cycle. If that
+// leaves no enabled meal, every meal appears once.
+func weightedCycle(meals []Meal, weights map[string]float64, n int) []Meal {
+	var cycle []Meal
+	hasEnabled := false
+	for _, meal := range meals {
+		copies := 1
+		switch weight := weightOf(weights, meal); {
+		case weight >= 2:
+			copies = 2
+		case weight < 1:
+			copies = int(math.Floor(float64(n+1)*weight) - math.Floor(float64(n)*weight))
+		}
+
+		for i := 0; i < copies; i++ {
+			cycle = append(cycle, meal)
+		}
+		if copies > 0 && !meal.Disabled {
+			hasEnabled = true
+		}
+	}
+
+	if !hasEnabled {
+		return append([]Meal(nil), meals...)
+	}
+	return cycle
+}
+
+// weightedShuffle orders meals by weighted random sampling without replacement, so heavy
+// meals tend to come early in the cycle and light ones sink to the end. Randomness comes
+// from the seeded rng, keeping the order deterministic.
+func weightedShuffle(rng *rand.Rand, meals []Meal, weights map[string]float64) {
+	keys := make([]float64, len(meals))
+	for i, meal := range meals {
+		keys[i] = math.Pow(rng.Float64(), 1/weightOf(weights, meal))
+	}
+
+	indices := make([]int, len(meals))
+	for i := range indices {
+		indices[i] = i
+	}
+	sort.SliceStable(indices, func(i, j int) bool {
+		return keys[indices[i]] > keys[indices[j]]
+	})
+
+	shuffled := make([]Meal, len(meals))
+	for i, index := range indices {
+		shuffled[i] = meals[index]
+	}
+	copy(meals, shuffled)
+}
+
+// recentlyCookedFilter rejects meals cooked, or served, within the last days days.
+type recentlyCookedFilter struct {
+	days int
+	// Dates each meal was cooked or served, keyed by BaseName
+	served map[string][]time.Time
+}
+
+func newRecentlyCookedFilter(days int, history []HistoryEntry) *recentlyCookedFilter {
+	f := &recentlyCookedFilter{days: days, served: map[string][]time.Time{}}
+	for _, entry := range history {
+		if entry.Status == HistoryCooked {
+			f.served[entry.Meal] = append(f.served[entry.Meal], entry.Date)
+		}
+	}
+	return f
+}
+
+func (f *recentlyCookedFilter) Accept(date time.Time, meal Meal) bool {
+	since := date.AddDate(0, 0, -f.days)
+	for _, served := range f.served[meal.BaseName()] {
+		if served.Before(date) && !served.Before(since) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *recentlyCookedFilter) Served(date time.Time, meal Meal) {
+	f.served[meal.BaseName()] = append(f.served[meal.BaseName()], date)
+}
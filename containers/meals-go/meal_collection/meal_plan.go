@@ -0,0 +1,143 @@
+package meal_collection
+
+import (
+	"time"
+
+	"github.com/andrewpollack/pi-infrastructure/containers/meals-go/calendar"
+)
+
+// PlannedMeal is a meal pinned to a date, overriding whatever would be generated for it.
+// A non-zero Servings overrides how many people the meal is cooked for on that date. An
+// empty Meal only overrides the servings, leaving the date's meal as generated.
+type PlannedMeal struct {
+	Date     time.Time `json:"date"`
+	Meal     string    `json:"meal"`
+	Servings int       `json:"servings,omitempty"`
+}
+
+// IsPinned returns whether p pins a meal, rather than only overriding the servings.
+func (p PlannedMeal) IsPinned() bool {
+	return p.Meal != ""
+}
+
+// Schedule is the stored state applied on top of generated meals.
+type Schedule struct {
+	// Plan holds meals pinned by hand, which win over everything else.
+	Plan []PlannedMeal
+	// Snapshot holds the meals frozen to their dates when GenerateOptions.Stable is set:
+	// the days that were emailed, and each day once it's served.
+	Snapshot []PlannedMeal
+	// History is the meal history snapshot, loaded for GenerationModeRated.
+	History []HistoryEntry
+}
+
+// ApplyMealPlan returns a copy of meals where every meal whose date in dates is pinned
+// in plan is replaced by the pinned meal. dates and meals must be the same length.
+func (m MealCollection) ApplyMealPlan(dates []time.Time, meals []Meal, plan []PlannedMeal) []Meal {
+	pinned := make(map[string]string, len(plan))
+	for _, planned := range plan {
+		if !planned.IsPinned() {
+			continue
+		}
+		pinned[planned.Date.Format(time.DateOnly)] = planned.Meal
+	}
+
+	mealMap := m.MapNameToMeal()
+	result := make([]Meal, len(meals))
+	copy(result, meals)
+	for i, date := range dates {
+		if name, ok := pinned[date.Format(time.DateOnly)]; ok {
+			result[i] = fixedMeal(mealMap, name)
+		}
+	}
+
+	return result
+}
+
+// SnapshotUpdates returns the snapshot entries to store for meals, the meals served on
+// dates. Dates that are already snapshotted are frozen and never updated.
+func (s Schedule) SnapshotUpdates(dates []time.Time, meals []Meal) []PlannedMeal {
+	snapshotted := make(map[string]bool, len(s.Snapshot))
+	for _, entry := range s.Snapshot {
+		snapshotted[entry.Date.Format(time.DateOnly)] = true
+	}
+
+	var updates []PlannedMeal
+	for i, date := range dates {
+		if snapshotted[date.Format(time.DateOnly)] {
+			continue
+		}
+		updates = append(updates, PlannedMeal{Date: date, Meal: meals[i].BaseName()})
+	}
+
+	return updates
+}
+
+// ServingsForDates returns how many servings to cook on each of dates: the override
+// pinned in plan, or defaultServings when the date has none.
+func ServingsForDates(plan []PlannedMeal, dates []time.Time, defaultServings int) []int {
+	overrides := make(map[string]int, len(plan))
+	for _, planned := range plan {
+		if planned.Servings > 0 {
+			overrides[planned.Date.Format(time.DateOnly)] = planned.Servings
+		}
+	}
+
+	servings := make([]int, len(dates))
+	for i, date := range dates {
+		if override, ok := overrides[date.Format(time.DateOnly)]; ok {
+			servings[i] = override
+		} else {
+			servings[i] = defaultServings
+		}
+	}
+	return servings
+}
+
+// MealsForDates generates the meal for each of dates, which may span several months.
+// Snapshotted dates are frozen to schedule.Snapshot. Meals pinned in schedule.Plan are
+// applied last, then leftover days are tied to their source meals, including ones
+// cooked up to LEFTOVER_MAX_DAYS outside dates, see leftoverWindow.
+// schedule.History, when loaded, is the history snapshot used for generation.
+func (m MealCollection) MealsForDates(dates []time.Time, opts GenerateOptions, schedule Schedule) []Meal {
+	window := leftoverWindow(dates)
+	linked := LinkLeftovers(window, m.unlinkedMealsForDates(window, opts, schedule))
+
+	byDate := make(map[string]Meal, len(window))
+	for i, date := range window {
+		byDate[date.Format(time.DateOnly)] = linked[i]
+	}
+
+	meals := make([]Meal, len(dates))
+	for i, date := range dates {
+		meals[i] = byDate[date.Format(time.DateOnly)]
+	}
+	return meals
+}
+
+// unlinkedMealsForDates is MealsForDates without tying leftover days to their source meals.
+func (m MealCollection) unlinkedMealsForDates(dates []time.Time, opts GenerateOptions, schedule Schedule) []Meal {
+	if schedule.History != nil {
+		opts.History = schedule.History
+	}
+
+	type yearMonth struct {
+		Year  int
+		Month time.Month
+	}
+
+	calendars := make(map[yearMonth][]Meal)
+	meals := make([]Meal, 0, len(dates))
+	for _, date := range dates {
+		currYearMonth := yearMonth{Year: date.Year(), Month: date.Month()}
+		if _, exists := calendars[currYearMonth]; !exists {
+			// Generate all meals for the entire year/month if not already present
+			cal := calendar.NewCalendar(date.Year(), date.Month())
+			calendars[currYearMonth] = m.GenerateMealsWholeYear(*cal, opts)
+		}
+		meals = append(meals, calendars[currYearMonth][date.Day()-1])
+	}
+
+	meals = m.ApplyMealPlan(dates, meals, schedule.Snapshot)
+	return m.ApplyMealPlan(dates, meals, schedule.Plan)
+}
@@ -0,0 +1,139 @@
+package meal_collection
+
+import (
+	"errors"
+	"fmt"
+)
+
+// IngredientInfo holds the price and nutrition of one Unit of an ingredient, e.g. the
+// price of 1 lb of ground beef. An ingredient may have an entry for several units.
+type IngredientInfo struct {
+	Name     string  `json:"Name"`
+	Unit     Unit    `json:"Unit"`
+	Price    float64 `json:"Price"`
+	Calories float64 `json:"Calories"`
+	Protein  float64 `json:"Protein"`
+}
+
+// Validate checks that the entry is named, has a valid unit, and no negative values.
+func (i IngredientInfo) Validate() error {
+	if i.Name == "" {
+		return errors.New("ingredient info name cannot be empty")
+	}
+	if err := i.Unit.IsValid(); err != nil {
+		return fmt.Errorf("ingredient info '%s': %v", i.Name, err)
+	}
+	if i.Price < 0 || i.Calories < 0 || i.Protein < 0 {
+		return fmt.Errorf("ingredient info '%s' cannot have negative values", i.Name)
+	}
+	return nil
+}
+
+// IngredientInfos is the price and nutrition data of every known ingredient.
+type IngredientInfos []IngredientInfo
+
+// Estimate is the estimated cost and nutrition of one or more meals.
+type Estimate struct {
+	Cost     float64
+	Calories float64
+	Protein  float64
+	// Missing lists ingredients without price and nutrition data, which aren't counted,
+	// each once
+	Missing []string `json:",omitempty"`
+}
+
+// addMissing adds names to the estimate's missing ingredients, skipping ones already there.
+func (e *Estimate) addMissing(names ...string) {
+	for _, name := range names {
+		found := false
+		for _, missing := range e.Missing {
+			if NormalizeIngredientName(missing) == NormalizeIngredientName(name) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			e.Missing = append(e.Missing, name)
+		}
+	}
+}
+
+// Add returns the sum of both estimates.
+func (e Estimate) Add(other Estimate) Estimate {
+	sum := Estimate{
+		Cost:     e.Cost + other.Cost,
+		Calories: e.Calories + other.Calories,
+		Protein:  e.Protein + other.Protein,
+	}
+	sum.addMissing(e.Missing...)
+	sum.addMissing(other.Missing...)
+	return sum
+}
+
+// String formats the estimate for display, e.g. "$12.50 · 850 kcal · 40 g protein",
+// followed by how many ingredients weren't counted, e.g. "(2 ingredients unpriced)".
+func (e Estimate) String() string {
+	s := fmt.Sprintf("$%.2f · %.0f kcal · %.0f g protein", e.Cost, e.Calories, e.Protein)
+	switch len(e.Missing) {
+	case 0:
+		return s
+	case 1:
+		return s + " (1 ingredient unpriced)"
+	default:
+		return fmt.Sprintf("%s (%d ingredients unpriced)", s, len(e.Missing))
+	}
+}
+
+// find returns the entry for the ingredient whose unit the quantity converts to,
+// along with the converted quantity. An entry in the same unit is preferred.
+func (infos IngredientInfos) find(ingredient Ingredient, preferences UnitPreferences) (IngredientInfo, float64, bool) {
+	name := NormalizeIngredientName(ingredient.Name)
+
+	var candidates []IngredientInfo
+	for _, info := range infos {
+		if NormalizeIngredientName(info.Name) != name {
+			continue
+		}
+		if info.Unit == ingredient.Unit {
+			return info, ingredient.Quantity, true
+		}
+		candidates = append(candidates, info)
+	}
+
+	for _, info := range candidates {
+		if quantity, ok := preferences.get(ingredient.Name).Convert(ingredient.Quantity, ingredient.Unit, info.Unit); ok {
+			return info, quantity, true
+		}
+	}
+	return IngredientInfo{}, 0, false
+}
+
+// EstimateMeal estimates the cost and nutrition of a meal. Quantity-less ingredients,
+// like "salt to taste", are left out.
+func (infos IngredientInfos) EstimateMeal(meal Meal, preferences UnitPreferences) Estimate {
+	var estimate Estimate
+	for _, ingredient := range meal.Ingredients {
+		if ingredient.QuantityLess() {
+			continue
+		}
+
+		info, quantity, ok := infos.find(ingredient, preferences)
+		if !ok {
+			estimate.addMissing(ingredient.Name)
+			continue
+		}
+		estimate.Cost += quantity * info.Price
+		estimate.Calories += quantity * info.Calories
+		estimate.Protein += quantity * info.Protein
+	}
+	return estimate
+}
+
+// EstimateMeals estimates each of meals.
+func (infos IngredientInfos) EstimateMeals(meals []Meal, preferences UnitPreferences) []Estimate {
+	estimates := make([]Estimate, len(meals))
+	for i, meal := range meals {
+		estimates[i] = infos.EstimateMeal(meal, preferences)
+	}
+	return estimates
+}
@@ -0,0 +1,83 @@
+package meal_collection
+
+import (
+	"errors"
+	"fmt"
+)
+
+// PantryItem is an ingredient kept on hand. Staples are always assumed to be stocked,
+// so they're listed to check rather than bought.
+type PantryItem struct {
+	ID       int     `json:"ID"`
+	Name     string  `json:"Name"`
+	Quantity float64 `json:"Quantity"`
+	Unit     Unit    `json:"Unit"`
+	Staple   bool    `json:"Staple"`
+}
+
+// Validate checks that the pantry item is named, and that any quantity has a valid unit.
+func (p PantryItem) Validate() error {
+	if p.Name == "" {
+		return errors.New("pantry item name cannot be empty")
+	}
+	if p.Quantity < 0 {
+		return fmt.Errorf("quantity of pantry item '%s' cannot be negative", p.Name)
+	}
+	if p.Quantity > 0 || p.Unit != "" {
+		if err := p.Unit.IsValid(); err != nil {
+			return fmt.Errorf("pantry item '%s': %v", p.Name, err)
+		}
+	}
+	return nil
+}
+
+// SubtractPantry returns a copy of ingredients with the quantities on hand in pantry
+// subtracted, dropping ingredients that are fully covered. On-hand quantities are only
+// subtracted when their unit converts to the ingredient's. Staples are kept as-is and
+// marked CheckStock, as are quantity-less ingredients, like "garlic to taste", that are
+// on hand, since there's no telling whether there's enough.
+func SubtractPantry(ingredients []Ingredient, pantry []PantryItem, preferences UnitPreferences) []Ingredient {
+	onHand := make(map[string]*PantryItem, len(pantry))
+	for i := range pantry {
+		item := pantry[i]
+		onHand[NormalizeIngredientName(item.Name)] = &item
+	}
+
+	var result []Ingredient
+	for _, ingredient := range ingredients {
+		item, found := onHand[NormalizeIngredientName(ingredient.Name)]
+		if !found {
+			result = append(result, ingredient)
+			continue
+		}
+
+		if item.Staple {
+			ingredient.CheckStock = true
+			result = append(result, ingredient)
+			continue
+		}
+
+		if ingredient.Quantity <= 0 {
+			// On hand unless its tracked quantity has been used up
+			ingredient.CheckStock = item.Unit == "" || item.Quantity > 0
+			result = append(result, ingredient)
+			continue
+		}
+
+		available, ok := preferences.get(ingredient.Name).Convert(item.Quantity, item.Unit, ingredient.Unit)
+		if !ok || available <= 0 {
+			result = append(result, ingredient)
+			continue
+		}
+
+		used := min(available, ingredient.Quantity)
+		// Whatever wasn't used stays on hand for other entries of the same ingredient
+		item.Quantity *= (available - used) / available
+		ingredient.Quantity -= used
+		if ingredient.Quantity > 0 {
+			result = append(result, ingredient)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,229 @@
+package meal_collection
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ImportedRecipe is a recipe parsed from a schema.org Recipe, returned for review before
+// it's created. Warnings lists whatever couldn't be parsed or guessed.
+type ImportedRecipe struct {
+	Recipe   Meal     `json:"recipe"`
+	Warnings []string `json:"warnings"`
+}
+
+var jsonLDScript = regexp.MustCompile(`(?is)<script[^>]*type\s*=\s*["']application/ld\+json["'][^>]*>(.*?)</script>`)
+
+// ImportRecipe parses the schema.org Recipe in data, either a JSON-LD document or an
+// HTML page embedding one. Ingredient names are resolved through catalog, and aisles
+// are guessed from how collection assigns the same ingredients.
+func ImportRecipe(data []byte, collection MealCollection, catalog IngredientCatalog) (ImportedRecipe, error) {
+	schemaRecipe, err := findSchemaRecipe(data)
+	if err != nil {
+		return ImportedRecipe{}, err
+	}
+
+	var imported ImportedRecipe
+	recipe := Meal{
+		Name:     cleanSchemaText(schemaString(schemaRecipe["name"])),
+		Servings: schemaServings(schemaRecipe["recipeYield"]),
+	}
+	if recipe.Name == "" {
+		return ImportedRecipe{}, errors.New("recipe has no name")
+	}
+	if url := schemaString(schemaRecipe["url"]); url != "" {
+		recipe.URL = &url
+	}
+	if category := cleanSchemaText(schemaString(schemaRecipe["recipeCategory"])); category != "" {
+		recipe.Category = &category
+	}
+
+	if _, exists := collection.MapNameToMeal()[recipe.Name]; exists {
+		imported.Warnings = append(imported.Warnings, fmt.Sprintf("a recipe named '%s' already exists", recipe.Name))
+	}
+
+	lines := schemaStrings(schemaRecipe["recipeIngredient"])
+	if len(lines) == 0 {
+		// Older documents use the deprecated ingredients property
+		lines = schemaStrings(schemaRecipe["ingredients"])
+	}
+	index := catalog.Index()
+	for _, line := range lines {
+		line = cleanSchemaText(line)
+		ingredient, err := ParseIngredientLine(line)
+		if err != nil {
+			imported.Warnings = append(imported.Warnings, fmt.Sprintf("could not parse ingredient: %v", err))
+			continue
+		}
+
+		ingredient.Raw = line
+
+		if entry, ok := index.Lookup(ingredient.Name); ok {
+			ingredient.Name = entry.Name
+			ingredient.Aisle = entry.Aisle
+		}
+		if ingredient.Aisle == "" {
+			if aisle, ok := collection.GuessAisle(ingredient.Name); ok {
+				ingredient.Aisle = aisle
+			} else {
+				imported.Warnings = append(imported.Warnings, fmt.Sprintf("no aisle found for '%s'", ingredient.Name))
+			}
+		}
+
+		recipe.Ingredients = append(recipe.Ingredients, ingredient)
+	}
+
+	imported.Recipe = recipe
+	return imported, nil
+}
+
+// GuessAisle returns the aisle the collection most often puts an ingredient with this
+// name in. When no recipe uses the name, leading words are dropped one at a time so
+// that "diced tomato" matches "tomato".
+func (m MealCollection) GuessAisle(name string) (Aisle, bool) {
+	counts := make(map[string]map[Aisle]int)
+	for _, meal := range m {
+		for _, ingredient := range meal.Ingredients {
+			if ingredient.Aisle == "" {
+				continue
+			}
+			key := NormalizeIngredientName(ingredient.Name)
+			if counts[key] == nil {
+				counts[key] = make(map[Aisle]int)
+			}
+			counts[key][ingredient.Aisle]++
+		}
+	}
+
+	words := strings.Fields(NormalizeIngredientName(name))
+	for i := range words {
+		aisles, found := counts[strings.Join(words[i:], " ")]
+		if !found {
+			continue
+		}
+
+		candidates := make([]Aisle, 0, len(aisles))
+		for aisle := range aisles {
+			candidates = append(candidates, aisle)
+		}
+		// Most used first, ties broken by name to stay deterministic
+		sort.Slice(candidates, func(a, b int) bool {
+			if aisles[candidates[a]] != aisles[candidates[b]] {
+				return aisles[candidates[a]] > aisles[candidates[b]]
+			}
+			return candidates[a] < candidates[b]
+		})
+		return candidates[0], true
+	}
+
+	return "", false
+}
+
+// findSchemaRecipe returns the first object typed Recipe in data. JSON-LD blocks that
+// don't parse are skipped, as pages often carry unrelated broken ones.
+func findSchemaRecipe(data []byte) (map[string]any, error) {
+	var documents [][]byte
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') {
+		documents = append(documents, trimmed)
+	} else {
+		for _, match := range jsonLDScript.FindAllSubmatch(data, -1) {
+			documents = append(documents, match[1])
+		}
+	}
+	if len(documents) == 0 {
+		return nil, errors.New("no JSON-LD found")
+	}
+
+	var unmarshalErr error
+	parsed := 0
+	for _, document := range documents {
+		var node any
+		if err := json.Unmarshal(document, &node); err != nil {
+			unmarshalErr = err
+			continue
+		}
+		parsed++
+		if recipe := findSchemaNode(node, "Recipe"); recipe != nil {
+			return recipe, nil
+		}
+	}
+	if parsed == 0 {
+		return nil, fmt.Errorf("error unmarshalling JSON-LD: %v", unmarshalErr)
+	}
+	return nil, errors.New("no schema.org Recipe found")
+}
+
+// findSchemaNode searches node, its array elements and its @graph for an object whose
+// @type is, or includes, schemaType.
+func findSchemaNode(node any, schemaType string) map[string]any {
+	switch v := node.(type) {
+	case []any:
+		for _, element := range v {
+			if found := findSchemaNode(element, schemaType); found != nil {
+				return found
+			}
+		}
+	case map[string]any:
+		for _, t := range schemaStrings(v["@type"]) {
+			if t == schemaType {
+				return v
+			}
+		}
+		if graph, ok := v["@graph"]; ok {
+			return findSchemaNode(graph, schemaType)
+		}
+	}
+	return nil
+}
+
+// schemaStrings returns a property that may be a single value or a list as strings.
+func schemaStrings(value any) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case float64:
+		return []string{strconv.FormatFloat(v, 'f', -1, 64)}
+	case []any:
+		var values []string
+		for _, element := range v {
+			values = append(values, schemaStrings(element)...)
+		}
+		return values
+	}
+	return nil
+}
+
+// schemaString returns the first string of a property.
+func schemaString(value any) string {
+	if values := schemaStrings(value); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// schemaServings returns the first whole number of a recipeYield, e.g. 4 for "4 servings".
+func schemaServings(value any) int {
+	for _, yield := range schemaStrings(value) {
+		for _, field := range strings.Fields(yield) {
+			if servings, err := strconv.Atoi(field); err == nil && servings > 0 {
+				return servings
+			}
+		}
+	}
+	return 0
+}
+
+var htmlTag = regexp.MustCompile(`<[^>]*>`)
+
+// cleanSchemaText strips HTML tags and entities that sites leave in JSON-LD text.
+func cleanSchemaText(text string) string {
+	text = html.UnescapeString(htmlTag.ReplaceAllString(text, ""))
+	return strings.Join(strings.Fields(text), " ")
+}
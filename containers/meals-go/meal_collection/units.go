@@ -0,0 +1,117 @@
+package meal_collection
+
+// Dimension groups units that can be converted into each other.
+type Dimension string
+
+const (
+	DimensionMass   Dimension = "mass"
+	DimensionVolume Dimension = "volume"
+	// DimensionMeasure merges mass and volume for ingredients with a known density.
+	DimensionMeasure Dimension = "measure"
+)
+
+// unitSizes is the size of each convertible unit in the base unit of its dimension,
+// grams for mass and teaspoons for volume. Larger units come first.
+var unitSizes = []struct {
+	Unit      Unit
+	Dimension Dimension
+	Size      float64
+}{
+	{UnitLb, DimensionMass, 453.59237},
+	{UnitOz, DimensionMass, 28.349523125},
+	{UnitGram, DimensionMass, 1},
+	{UnitCup, DimensionVolume, 48},
+	{UnitTbsp, DimensionVolume, 3},
+	{UnitTsp, DimensionVolume, 1},
+}
+
+// TSP_PER_CUP is used to convert volume to mass through UnitPreference.GramsPerCup.
+const TSP_PER_CUP = 48
+
+// Dimension returns the dimension of the unit. Units that can't be converted, like
+// UnitCount, are their own dimension.
+func (u Unit) Dimension() Dimension {
+	for _, size := range unitSizes {
+		if size.Unit == u {
+			return size.Dimension
+		}
+	}
+	return Dimension(u)
+}
+
+// size returns the size of the unit in its dimension's base unit, or 0 if the unit
+// can't be converted.
+func (u Unit) size() float64 {
+	for _, size := range unitSizes {
+		if size.Unit == u {
+			return size.Size
+		}
+	}
+	return 0
+}
+
+// UnitPreference configures how quantities of an ingredient are merged.
+type UnitPreference struct {
+	// Preferred is the unit compatible quantities are converted into. When empty, or
+	// not compatible, the largest unit used for the ingredient is chosen.
+	Preferred Unit
+	// GramsPerCup, when set, allows converting between volume and mass.
+	GramsPerCup float64
+}
+
+// UnitPreferences holds the UnitPreference of each ingredient, keyed by lowercase name.
+type UnitPreferences map[string]UnitPreference
+
+func (p UnitPreferences) get(name string) UnitPreference {
+	return p[NormalizeIngredientName(name)]
+}
+
+// dimension returns the dimension that unit is merged in for this ingredient.
+func (p UnitPreference) dimension(unit Unit) Dimension {
+	dimension := unit.Dimension()
+	if p.GramsPerCup > 0 && (dimension == DimensionMass || dimension == DimensionVolume) {
+		return DimensionMeasure
+	}
+	return dimension
+}
+
+// targetUnit picks the unit that quantities in units, all merged in the same
+// dimension, are converted into.
+func (p UnitPreference) targetUnit(units []Unit) Unit {
+	if p.Preferred != "" && p.dimension(p.Preferred) == p.dimension(units[0]) {
+		return p.Preferred
+	}
+
+	used := map[Unit]bool{}
+	for _, unit := range units {
+		used[unit] = true
+	}
+	for _, size := range unitSizes {
+		if used[size.Unit] {
+			return size.Unit
+		}
+	}
+	return units[0]
+}
+
+// Convert converts quantity from one unit to another, returning false when the units
+// aren't compatible for this ingredient.
+func (p UnitPreference) Convert(quantity float64, from Unit, to Unit) (float64, bool) {
+	if from == to {
+		return quantity, true
+	}
+	if from.size() == 0 || to.size() == 0 || p.dimension(from) != p.dimension(to) {
+		return 0, false
+	}
+
+	base := quantity * from.size()
+	fromDimension, toDimension := from.Dimension(), to.Dimension()
+	switch {
+	case fromDimension == DimensionVolume && toDimension == DimensionMass:
+		base = base / TSP_PER_CUP * p.GramsPerCup
+	case fromDimension == DimensionMass && toDimension == DimensionVolume:
+		base = base / p.GramsPerCup * TSP_PER_CUP
+	}
+
+	return base / to.size(), true
+}
@@ -0,0 +1,148 @@
+package meal_collection
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DayRule describes how meals are planned on a weekday. A rule with neither Meal nor
+// Category set generates a meal from the whole collection.
+type DayRule struct {
+	// Meal is served every week on this weekday instead of a generated meal. It can name
+	// a recipe, or anything else such as "Leftovers" or "Pizza night".
+	Meal string
+	// Category restricts generated meals on this weekday to the given category.
+	Category string
+	// MaxPrepMinutes restricts generated meals on this weekday to ones that take at most
+	// this long. Meals without a prep time are always allowed. 0 means no limit.
+	MaxPrepMinutes int
+}
+
+// WeeklyTemplate maps each weekday to its DayRule. Weekdays without a rule are generated.
+type WeeklyTemplate map[time.Weekday]DayRule
+
+// DEFAULT_WEEKLY_TEMPLATE serves leftovers on Thursdays and eats out on Fridays.
+var DEFAULT_WEEKLY_TEMPLATE = WeeklyTemplate{
+	time.Thursday: {Meal: MEAL_LEFTOVERS.Name},
+	time.Friday:   {Meal: MEAL_OUT.Name},
+}
+
+func (r DayRule) IsValid() error {
+	if r.Meal != "" && r.Category != "" {
+		return errors.New("day rule cannot set both a meal and a category")
+	}
+	if r.MaxPrepMinutes < 0 {
+		return errors.New("day rule max prep minutes cannot be negative")
+	}
+	if r.Meal != "" && r.MaxPrepMinutes != 0 {
+		return errors.New("day rule cannot set both a meal and a max prep time")
+	}
+	return nil
+}
+
+// ParseWeekday parses a weekday name such as "monday" or "Mon".
+func ParseWeekday(name string) (time.Weekday, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		fullName := strings.ToLower(day.String())
+		if name == fullName || (len(name) >= 3 && strings.HasPrefix(fullName, name)) {
+			return day, nil
+		}
+	}
+	return time.Sunday, fmt.Errorf("invalid weekday: %s", name)
+}
+
+// NewWeeklyTemplate builds a WeeklyTemplate from rules keyed by weekday name.
+func NewWeeklyTemplate(rules map[string]DayRule) (WeeklyTemplate, error) {
+	template := WeeklyTemplate{}
+	for name, rule := range rules {
+		day, err := ParseWeekday(name)
+		if err != nil {
+			return nil, err
+		}
+		if _, exists := template[day]; exists {
+			return nil, fmt.Errorf("duplicate rule for %s", day)
+		}
+		if err := rule.IsValid(); err != nil {
+			return nil, fmt.Errorf("invalid rule for %s: %v", day, err)
+		}
+		template[day] = rule
+	}
+
+	return template, nil
+}
+
+// UnknownCategories returns the weekdays whose rule restricts meals to a category that no
+// meal in m is in. Those days are served whatever meal is left in the cycle.
+func (t WeeklyTemplate) UnknownCategories(m MealCollection) []time.Weekday {
+	categories := map[string]bool{}
+	for _, meal := range m {
+		if meal.Category != nil {
+			categories[strings.ToLower(*meal.Category)] = true
+		}
+	}
+
+	var unknown []time.Weekday
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if category := t[day].Category; category != "" && !categories[strings.ToLower(category)] {
+			unknown = append(unknown, day)
+		}
+	}
+	return unknown
+}
+
+// fixedMeal returns the meal named by a template rule, falling back to a meal without
+// ingredients when the name isn't in the collection.
+func fixedMeal(mealMap map[string]Meal, name string) Meal {
+	if meal, ok := mealMap[name]; ok {
+		return meal
+	}
+	return Meal{Name: name}
+}
+
+// MapNameToMealWithTemplate is MapNameToMeal, also including the meals fixed by
+// template that aren't recipes, such as "Pizza night". DEFAULT_WEEKLY_TEMPLATE is used
+// when template is nil.
+func (m MealCollection) MapNameToMealWithTemplate(template WeeklyTemplate) map[string]Meal {
+	if template == nil {
+		template = DEFAULT_WEEKLY_TEMPLATE
+	}
+
+	mealMap := m.MapNameToMeal()
+	for _, rule := range template {
+		if rule.Meal != "" {
+			mealMap[rule.Meal] = fixedMeal(mealMap, rule.Meal)
+		}
+	}
+
+	return mealMap
+}
+
+// templateCategoryFilter only accepts meals matching the category of the weekday's rule.
+type templateCategoryFilter struct {
+	template WeeklyTemplate
+}
+
+func (f templateCategoryFilter) Accept(date time.Time, meal Meal) bool {
+	category := f.template[date.Weekday()].Category
+	if category == "" {
+		return true
+	}
+	return meal.Category != nil && strings.EqualFold(*meal.Category, category)
+}
+
+func (f templateCategoryFilter) Served(date time.Time, meal Meal) {}
+
+// templatePrepTimeFilter only accepts meals within the max prep time of the weekday's rule.
+type templatePrepTimeFilter struct {
+	template WeeklyTemplate
+}
+
+func (f templatePrepTimeFilter) Accept(date time.Time, meal Meal) bool {
+	maxPrepMinutes := f.template[date.Weekday()].MaxPrepMinutes
+	return maxPrepMinutes == 0 || meal.PrepMinutes <= maxPrepMinutes
+}
+
+func (f templatePrepTimeFilter) Served(date time.Time, meal Meal) {}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"os"
 	"time"
 
 	"github.com/andrewpollack/pi-infrastructure/containers/meals-go/meal_collection"
@@ -19,6 +20,11 @@ type Config struct {
 	PostgresURL string
 	CleanTable  bool
 	LongLive    bool
+
+	// Export configuration: ExportPath is written to, or stdout when empty, and the
+	// export is uploaded to BucketName/BucketKey when ExportUpload is set
+	ExportPath   string
+	ExportUpload bool
 }
 
 func (c Config) SyncMeals() error {
@@ -40,10 +46,23 @@ func (c Config) SyncMeals() error {
 		return fmt.Errorf("error reading file: %w", err)
 	}
 
-	var mealCollection meal_collection.MealCollection
-	if err := json.Unmarshal(jsonFile, &mealCollection); err != nil {
+	recipeFile, err := meal_collection.UnmarshalRecipeFile(jsonFile)
+	if err != nil {
 		return fmt.Errorf("error unmarshaling JSON: %v", err)
 	}
+	mealCollection := recipeFile.Recipes
+
+	// Unknown aisles are validated as Unsorted, but stored as written, so they're sorted
+	// again once their aisle is configured
+	unsorted := recipeFile.UnsortUnknownAisles()
+	if err := unsorted.Ingredients.Validate(); err != nil {
+		return fmt.Errorf("invalid ingredient catalog: %v", err)
+	}
+
+	// Aisles may come from the catalog, so validate recipes as they will be read
+	if err := unsorted.Recipes.ApplyCatalog(unsorted.Ingredients).Validate(); err != nil {
+		return fmt.Errorf("invalid recipes: %v", err)
+	}
 
 	if c.PostgresURL == "" {
 		return fmt.Errorf("POSTGRES_URL is not set")
@@ -59,22 +78,48 @@ func (c Config) SyncMeals() error {
 		}
 	}()
 
+	// Recipes edited or deleted through the API are left alone, even if they're in the file
+	apiRecipes, err := readAPIRecipeNames(conn)
+	if err != nil {
+		return err
+	}
+
 	upsertQuery := `
-        INSERT INTO recipes (name, category, url, ingredients)
-        VALUES ($1, $2, $3, $4)
+        INSERT INTO recipes (name, category, url, ingredients, servings, available_from, available_to, tags,
+                             prep_minutes, active_minutes, yield)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, COALESCE($8::text[], '{}'), $9, $10, $11)
         ON CONFLICT (name) DO UPDATE
-          SET category      = EXCLUDED.category,
-              url           = EXCLUDED.url,
-              ingredients   = EXCLUDED.ingredients,
-              date_modified = now()
+          SET category       = EXCLUDED.category,
+              url            = EXCLUDED.url,
+              ingredients    = EXCLUDED.ingredients,
+              servings       = EXCLUDED.servings,
+              available_from = EXCLUDED.available_from,
+              available_to   = EXCLUDED.available_to,
+              tags           = EXCLUDED.tags,
+              prep_minutes   = EXCLUDED.prep_minutes,
+              active_minutes = EXCLUDED.active_minutes,
+              yield          = EXCLUDED.yield,
+              date_modified  = now()
           WHERE (
             recipes.category    	IS DISTINCT FROM EXCLUDED.category
             OR recipes.url      	IS DISTINCT FROM EXCLUDED.url
             OR recipes.ingredients 	IS DISTINCT FROM EXCLUDED.ingredients
+            OR recipes.servings 	IS DISTINCT FROM EXCLUDED.servings
+            OR recipes.available_from	IS DISTINCT FROM EXCLUDED.available_from
+            OR recipes.available_to	IS DISTINCT FROM EXCLUDED.available_to
+            OR recipes.tags     	IS DISTINCT FROM EXCLUDED.tags
+            OR recipes.prep_minutes	IS DISTINCT FROM EXCLUDED.prep_minutes
+            OR recipes.active_minutes	IS DISTINCT FROM EXCLUDED.active_minutes
+            OR recipes.yield    	IS DISTINCT FROM EXCLUDED.yield
           )
     `
 
 	for _, item := range mealCollection {
+		if apiRecipes[item.Name] {
+			log.Printf("Skipping recipe '%s' edited or deleted through the API\n", item.Name)
+			continue
+		}
+
 		ingJSON, err := json.Marshal(item.Ingredients)
 		if err != nil {
 			return fmt.Errorf("error marshaling ingredients: %w", err)
@@ -95,6 +140,13 @@ func (c Config) SyncMeals() error {
 			item.Category,
 			item.URL,
 			ingJSON,
+			item.Servings,
+			item.AvailableFrom,
+			item.AvailableTo,
+			item.Tags,
+			item.PrepMinutes,
+			item.ActiveMinutes,
+			item.Yield,
 		)
 		if err != nil {
 			return fmt.Errorf("upsert failed for recipe '%s': %v", item.Name, err)
@@ -111,19 +163,108 @@ func (c Config) SyncMeals() error {
 		}
 	}
 
+	if err := c.syncIngredientCatalog(conn, recipeFile.Ingredients); err != nil {
+		return err
+	}
+
 	if c.CleanTable {
 		var names []string
 		for _, item := range mealCollection {
 			names = append(names, item.Name)
 		}
 
-		// Delete rows that do not match any of the meal names
-		deleteQuery := "DELETE FROM recipes WHERE NOT (name = ANY($1))"
-		deleteRes, err := conn.Exec(ctx, deleteQuery, names)
+		// Delete synced rows that do not match any of the meal names
+		deleteQuery := "DELETE FROM recipes WHERE source = $1 AND NOT (name = ANY($2))"
+		deleteRes, err := conn.Exec(ctx, deleteQuery, meal_collection.RECIPE_SOURCE_SYNC, names)
 		if err != nil {
 			return fmt.Errorf("error deleting recipes not in sync: %w", err)
 		}
 		log.Printf("Deleted %d recipes that are not in the current meal collection\n", deleteRes.RowsAffected())
+
+		// Recipes no longer in the file don't need to be kept from coming back
+		if _, err := conn.Exec(ctx, "DELETE FROM deleted_recipes WHERE NOT (name = ANY($1))", names); err != nil {
+			return fmt.Errorf("error deleting deleted recipes not in sync: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// readAPIRecipeNames returns the names of recipes edited, deleted or renamed away from
+// through the API.
+func readAPIRecipeNames(conn *pgx.Conn) (map[string]bool, error) {
+	rows, err := conn.Query(context.Background(), `
+		SELECT name
+		FROM recipes
+		WHERE source = $1
+		UNION
+		SELECT name
+		FROM deleted_recipes
+	`, meal_collection.RECIPE_SOURCE_UI)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %v", err)
+	}
+	defer rows.Close()
+
+	names := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("scan failed: %v", err)
+		}
+		names[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %v", err)
+	}
+
+	return names, nil
+}
+
+// syncIngredientCatalog upserts the catalog section of the recipe file. Files without
+// a catalog section leave the ingredient_catalog table untouched.
+func (c Config) syncIngredientCatalog(conn *pgx.Conn, catalog meal_collection.IngredientCatalog) error {
+	if len(catalog) == 0 {
+		return nil
+	}
+
+	upsertQuery := `
+        INSERT INTO ingredient_catalog (name, aliases, aisle)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (name) DO UPDATE
+          SET aliases       = EXCLUDED.aliases,
+              aisle         = EXCLUDED.aisle,
+              date_modified = now()
+          WHERE (
+            ingredient_catalog.aliases IS DISTINCT FROM EXCLUDED.aliases
+            OR ingredient_catalog.aisle IS DISTINCT FROM EXCLUDED.aisle
+          )
+    `
+
+	var names []string
+	for _, entry := range catalog {
+		aliases := entry.Aliases
+		if aliases == nil {
+			aliases = []string{}
+		}
+
+		res, err := conn.Exec(context.Background(), upsertQuery, entry.Name, aliases, string(entry.Aisle))
+		if err != nil {
+			return fmt.Errorf("upsert failed for catalog ingredient '%s': %v", entry.Name, err)
+		}
+		if res.RowsAffected() == 1 {
+			log.Printf("Upserted catalog ingredient: [%s]\n", entry.Name)
+		}
+
+		names = append(names, entry.Name)
+	}
+
+	if c.CleanTable {
+		deleteRes, err := conn.Exec(context.Background(), "DELETE FROM ingredient_catalog WHERE NOT (name = ANY($1))", names)
+		if err != nil {
+			return fmt.Errorf("error deleting catalog ingredients not in sync: %w", err)
+		}
+		log.Printf("Deleted %d catalog ingredients that are not in the current catalog\n", deleteRes.RowsAffected())
 	}
 
 	return nil
@@ -145,3 +286,31 @@ func (c Config) SyncMealsWrapper() error {
 
 	return nil
 }
+
+// ExportMeals dumps the recipes table as a recipe file, the reverse of SyncMeals.
+func (c Config) ExportMeals() error {
+	data, err := meal_collection.ExportRecipesFromDB(c.PostgresURL)
+	if err != nil {
+		return fmt.Errorf("error exporting recipes: %w", err)
+	}
+
+	if c.ExportPath == "" {
+		if _, err := os.Stdout.Write(data); err != nil {
+			return fmt.Errorf("error writing export: %w", err)
+		}
+	} else {
+		if err := os.WriteFile(c.ExportPath, data, 0o644); err != nil {
+			return fmt.Errorf("error writing export: %w", err)
+		}
+		log.Printf("Exported recipes to %s\n", c.ExportPath)
+	}
+
+	if c.ExportUpload {
+		if err := meal_collection.UploadToS3(c.BucketName, c.BucketKey, data); err != nil {
+			return fmt.Errorf("error uploading export: %w", err)
+		}
+		log.Printf("Uploaded recipes to s3://%s/%s\n", c.BucketName, c.BucketKey)
+	}
+
+	return nil
+}
@@ -2,10 +2,11 @@ package meal_email
 
 import (
 	"fmt"
+	"log"
+	"slices"
 	"strings"
 	"time"
 
-	"github.com/andrewpollack/pi-infrastructure/containers/meals-go/calendar"
 	"github.com/andrewpollack/pi-infrastructure/containers/meals-go/config"
 	"github.com/andrewpollack/pi-infrastructure/containers/meals-go/meal_collection"
 )
@@ -23,12 +24,15 @@ type Date struct {
 }
 
 type Config struct {
-	PostgresURL    string
-	EmailService   EmailService
-	Sender         string
-	Receivers      []string
-	HardcodedMeals []string
-	ExtraItems     []string
+	PostgresURL     string
+	EmailService    EmailService
+	Sender          string
+	Receivers       []string
+	HardcodedMeals  []string
+	ExtraItems      []string
+	GenerateOptions meal_collection.GenerateOptions
+	// Store names a profile from app.stores; empty uses the default store
+	Store string
 }
 
 func (d Date) ToTime() time.Time {
@@ -62,11 +66,6 @@ func GetDaysOfNextWeek(date Date) []Date {
 	return GetDaysOfCurrentWeek(FromTime(nextWeekStart))
 }
 
-type YearMonth struct {
-	Year  int
-	Month int
-}
-
 func generateHeader() string {
 	return `<!DOCTYPE html>
 <html>
@@ -78,7 +77,21 @@ func generateHeader() string {
 `
 }
 
-func generateTable(meals []meal_collection.Meal) string {
+// formatPrepTime formats how long a meal takes, e.g. "45 min (20 active)", or returns
+// "" when unknown.
+func formatPrepTime(meal meal_collection.Meal) string {
+	if meal.PrepMinutes == 0 {
+		return ""
+	}
+	if meal.ActiveMinutes == 0 {
+		return fmt.Sprintf("%d min", meal.PrepMinutes)
+	}
+	return fmt.Sprintf("%d min (%d active)", meal.PrepMinutes, meal.ActiveMinutes)
+}
+
+// generateTable renders the week's meals. When estimates is non-nil, each meal's cost and
+// nutrition is shown below it, followed by the week's total.
+func generateTable(meals []meal_collection.Meal, estimates []meal_collection.Estimate) string {
 	var sb strings.Builder
 	sb.WriteString(`<table border='1'>
 <thead>
@@ -98,36 +111,93 @@ func generateTable(meals []meal_collection.Meal) string {
 	for i := range fullDaysOfWeek {
 		currMeal := meals[i]
 
+		name := currMeal.DisplayName()
 		if currMeal.URL != nil && *currMeal.URL != "" {
-			sb.WriteString(fmt.Sprintf("            <td><a href='%s'>%s</a></td>\n", *currMeal.URL, currMeal.Name))
-			continue
-		} else {
-			sb.WriteString(fmt.Sprintf("            <td>%s</td>\n", currMeal.Name))
+			name = fmt.Sprintf("<a href='%s'>%s</a>", *currMeal.URL, name)
+		}
+		if prepTime := formatPrepTime(currMeal); prepTime != "" {
+			name = fmt.Sprintf("%s<br><small>%s</small>", name, prepTime)
 		}
+		sb.WriteString(fmt.Sprintf("            <td>%s</td>\n", name))
 	}
 
-	sb.WriteString(`        </tr>
-    </tbody>
+	sb.WriteString("        </tr>\n")
+
+	if estimates != nil {
+		var total meal_collection.Estimate
+		sb.WriteString("        <tr>\n")
+		for _, estimate := range estimates {
+			total = total.Add(estimate)
+			sb.WriteString(fmt.Sprintf("            <td><small>%s</small></td>\n", estimate))
+		}
+		sb.WriteString("        </tr>\n")
+		sb.WriteString(fmt.Sprintf("        <tr><td colspan='%d'><b>Week total:</b> %s</td></tr>\n", len(fullDaysOfWeek), total))
+	}
+
+	sb.WriteString(`    </tbody>
 </table>
 
 `)
 	return sb.String()
 }
 
-func GenerateGroceryList(ingredients []meal_collection.Ingredient) string {
+// groceryAisles returns the configured aisles in order, followed by an "Unsorted" aisle
+// when any ingredient is in an aisle that isn't configured, and a "Check Stock" aisle
+// when any ingredient is a pantry staple.
+func groceryAisles(aisles []string, ingredients []meal_collection.Ingredient) []meal_collection.Aisle {
+	result := make([]meal_collection.Aisle, 0, len(aisles)+2)
+	for _, aisle := range aisles {
+		result = append(result, meal_collection.Aisle(aisle))
+	}
+
+	var unsorted, checkStock bool
+	for _, ing := range ingredients {
+		if ing.CheckStock {
+			checkStock = true
+		} else if !slices.Contains(aisles, string(ing.Aisle)) {
+			unsorted = true
+		}
+	}
+	if unsorted {
+		result = append(result, meal_collection.AisleUnsorted)
+	}
+	if checkStock {
+		result = append(result, meal_collection.AisleCheckStock)
+	}
+	return result
+}
+
+// ingredientsForAisle collects the ingredients for the given aisle. The "Unsorted" aisle
+// collects every ingredient whose aisle isn't configured, and the "Check Stock" aisle
+// collects every pantry staple.
+func ingredientsForAisle(aisle meal_collection.Aisle, aisles []string, ingredients []meal_collection.Ingredient) []meal_collection.Ingredient {
+	var itemsForAisle []meal_collection.Ingredient
+	for _, ing := range ingredients {
+		if ing.CheckStock {
+			if aisle == meal_collection.AisleCheckStock {
+				itemsForAisle = append(itemsForAisle, ing)
+			}
+			continue
+		}
+		if ing.Aisle == aisle ||
+			(aisle == meal_collection.AisleUnsorted && !slices.Contains(aisles, string(ing.Aisle))) {
+			itemsForAisle = append(itemsForAisle, ing)
+		}
+	}
+	return itemsForAisle
+}
+
+func GenerateGroceryList(store config.Store, ingredients []meal_collection.Ingredient) string {
 	var sb strings.Builder
 
-	for _, aisle := range config.Cfg.App.Aisles {
+	ingredients = applyAisleMap(store, ingredients)
+	aisles := store.Aisles
+	for _, aisle := range groceryAisles(aisles, ingredients) {
 		// Write a header for the aisle
 		fmt.Fprintf(&sb, "<h4>%s</h4>\n", aisle)
 
 		// Collect all items for this aisle
-		var itemsForAisle []meal_collection.Ingredient
-		for _, ing := range ingredients {
-			if ing.Aisle == meal_collection.Aisle(aisle) {
-				itemsForAisle = append(itemsForAisle, ing)
-			}
-		}
+		itemsForAisle := ingredientsForAisle(aisle, aisles, ingredients)
 
 		// If no items for this aisle, show "NONE"
 		if len(itemsForAisle) == 0 {
@@ -152,16 +222,91 @@ func generateCloser() string {
 </html>`
 }
 
-func (c Config) GenerateEmailContentHTML(date Date, collection meal_collection.MealCollection, meals []meal_collection.Meal, ingredients []meal_collection.Ingredient) (string, error) {
+// GenerateEmailContentHTML renders the email for the week's meals, showing estimates
+// below them when non-nil, see estimateMeals.
+func (c Config) GenerateEmailContentHTML(date Date, collection meal_collection.MealCollection, meals []meal_collection.Meal, estimates []meal_collection.Estimate, ingredients []meal_collection.Ingredient) (string, error) {
+	store, err := FindStore(c.Store)
+	if err != nil {
+		return "", err
+	}
+
 	var sb strings.Builder
 	sb.WriteString(generateHeader())
-	sb.WriteString(generateTable(meals))
-	sb.WriteString(GenerateGroceryList(ingredients))
+	sb.WriteString(generateTable(meals, estimates))
+	sb.WriteString(GenerateGroceryList(store, ingredients))
 	sb.WriteString(generateCloser())
 
 	return sb.String(), nil
 }
 
+// UnitPreferences builds the unit preferences configured under app.ingredient_units.
+func UnitPreferences() (meal_collection.UnitPreferences, error) {
+	preferences := meal_collection.UnitPreferences{}
+	for name, ingredientUnit := range config.Cfg.App.IngredientUnits {
+		preferred := meal_collection.Unit(ingredientUnit.Preferred)
+		if preferred != "" {
+			if err := preferred.IsValid(); err != nil {
+				return nil, fmt.Errorf("ingredient '%s': %v", name, err)
+			}
+		}
+		if ingredientUnit.GramsPerCup < 0 {
+			return nil, fmt.Errorf("ingredient '%s': grams_per_cup cannot be negative", name)
+		}
+
+		preferences[strings.ToLower(name)] = meal_collection.UnitPreference{
+			Preferred:   preferred,
+			GramsPerCup: ingredientUnit.GramsPerCup,
+		}
+	}
+
+	return preferences, nil
+}
+
+// estimateMeals estimates the cost and nutrition of next week's meals at the servings
+// being shopped for. It returns nil when there's no ingredient price or nutrition data.
+func (c Config) estimateMeals(date Date, meals []meal_collection.Meal) ([]meal_collection.Estimate, error) {
+	infos, err := meal_collection.ReadIngredientInfoFromDB(c.PostgresURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ingredient info: %v", err)
+	}
+	if len(infos) == 0 {
+		return nil, nil
+	}
+
+	preferences, err := UnitPreferences()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read unit preferences: %v", err)
+	}
+
+	servings, err := c.servingsForNextWeek(date)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read servings: %v", err)
+	}
+
+	return infos.EstimateMeals(meal_collection.ScaleMeals(meals, servings), preferences), nil
+}
+
+// servingsForNextWeek returns how many servings to shop for on each day of next week:
+// the per-day override from the meal plan, or app.household_size.
+func (c Config) servingsForNextWeek(date Date) ([]int, error) {
+	householdSize := config.Cfg.App.HouseholdSize
+	if householdSize < 0 {
+		return nil, fmt.Errorf("household_size cannot be negative")
+	}
+
+	var dates []time.Time
+	for _, day := range GetDaysOfNextWeek(date) {
+		dates = append(dates, day.ToTime())
+	}
+
+	plan, err := meal_collection.ReadMealPlanFromDB(c.PostgresURL, dates[0], dates[len(dates)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	return meal_collection.ServingsForDates(plan, dates, householdSize), nil
+}
+
 func (c Config) GetIngredientsForNextWeek(date Date, collection meal_collection.MealCollection) ([]meal_collection.Ingredient, error) {
 	var ingredients []meal_collection.Ingredient
 
@@ -174,7 +319,24 @@ func (c Config) GetIngredientsForNextWeek(date Date, collection meal_collection.
 		return ingredients, fmt.Errorf("failed to get extra items: %v", err)
 	}
 
-	ingredients = meal_collection.MealsToIngredients(allMeals)
+	preferences, err := UnitPreferences()
+	if err != nil {
+		return ingredients, fmt.Errorf("failed to read unit preferences: %v", err)
+	}
+
+	servings, err := c.servingsForNextWeek(date)
+	if err != nil {
+		return ingredients, fmt.Errorf("failed to read servings: %v", err)
+	}
+
+	pantry, err := meal_collection.ReadPantryFromDB(c.PostgresURL)
+	if err != nil {
+		return ingredients, fmt.Errorf("failed to read pantry: %v", err)
+	}
+
+	scaledMeals := meal_collection.ScaleMeals(allMeals, servings)
+	ingredients = meal_collection.MealsToIngredientsWithUnits(scaledMeals, preferences)
+	ingredients = meal_collection.SubtractPantry(ingredients, pantry, preferences)
 	for _, extraItem := range allExtraItems {
 		ingredients = append(ingredients, meal_collection.ExtraItemToIngredient(extraItem))
 	}
@@ -185,34 +347,41 @@ func (c Config) GetIngredientsForNextWeek(date Date, collection meal_collection.
 func (c Config) GetMealsForNextWeek(date Date, collection meal_collection.MealCollection) ([]meal_collection.Meal, error) {
 	var allMeals []meal_collection.Meal
 
+	var dates []time.Time
+	for _, day := range GetDaysOfNextWeek(date) {
+		dates = append(dates, day.ToTime())
+	}
+
 	// Decide how to get meals: either hardcoded or generated
 	if len(c.HardcodedMeals) == 7 {
 		fullCollection, err := meal_collection.ReadMealCollectionFromDB(c.PostgresURL, time.Now().Unix())
 		if err != nil {
 			return nil, fmt.Errorf("something went wrong reading meals: %s", err)
 		}
-		mealMap := fullCollection.MapNameToMeal()
+		mealMap := fullCollection.MapNameToMealWithTemplate(c.GenerateOptions.Template)
 		for _, v := range c.HardcodedMeals {
-			meal, ok := mealMap[v]
-			if !ok {
+			if _, ok := mealMap[v]; !ok {
 				return nil, fmt.Errorf("meal not found: %s", v)
 			}
+		}
 
-			allMeals = append(allMeals, meal)
+		// Pin the chosen meals over the schedule, so their leftovers are linked with the
+		// days around the week like the calendar does
+		schedule, err := meal_collection.LoadScheduleFromDB(c.PostgresURL, dates, c.GenerateOptions)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load schedule: %v", err)
+		}
+		for i, date := range dates {
+			schedule.Plan = append(schedule.Plan, meal_collection.PlannedMeal{Date: date, Meal: c.HardcodedMeals[i]})
 		}
+		allMeals = fullCollection.MealsForDates(dates, c.GenerateOptions, schedule)
 	} else {
-		daysOfWeek := GetDaysOfNextWeek(date)
-		calendars := make(map[YearMonth][]meal_collection.Meal)
-
-		for _, day := range daysOfWeek {
-			currYearMonth := YearMonth{Year: day.Year, Month: day.Month}
-			if _, exists := calendars[currYearMonth]; !exists {
-				// Generate all meals for the entire year/month if not already present
-				c := calendar.NewCalendar(day.Year, time.Month(day.Month))
-				calendars[currYearMonth] = collection.GenerateMealsWholeYearNoCategories(*c)
-			}
-			allMeals = append(allMeals, calendars[currYearMonth][day.Day-1])
+		schedule, err := meal_collection.LoadScheduleFromDB(c.PostgresURL, dates, c.GenerateOptions)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load schedule: %v", err)
 		}
+
+		allMeals = collection.MealsForDates(dates, c.GenerateOptions, schedule)
 	}
 
 	return allMeals, nil
@@ -255,6 +424,11 @@ func GenerateHeaderForNextWeek(date Date) string {
 func (c Config) CreateAndSendEmail() error {
 	now := time.Now()
 
+	store, err := FindStore(c.Store)
+	if err != nil {
+		return err
+	}
+
 	// 1) Read the meal collection
 	collection, err := meal_collection.ReadMealCollectionFromDB(c.PostgresURL, now.Unix())
 	if err != nil {
@@ -277,15 +451,20 @@ func (c Config) CreateAndSendEmail() error {
 		return fmt.Errorf("failed to get ingredients for next week: %w", err)
 	}
 
+	estimates, err := c.estimateMeals(currDate, meals)
+	if err != nil {
+		return fmt.Errorf("failed to estimate meals for next week: %w", err)
+	}
+
 	// 3) Build email subject and HTML body
 	subject := GenerateHeaderForNextWeek(currDate)
-	bodyHTML, err := c.GenerateEmailContentHTML(currDate, collection, meals, ingredients)
+	bodyHTML, err := c.GenerateEmailContentHTML(currDate, collection, meals, estimates, ingredients)
 	if err != nil {
 		return fmt.Errorf("failed to generate email HTML: %w", err)
 	}
 
 	// 4) Generate PDF attachment
-	pdfBytes, err := DefaultPDFGenerator{}.GenerateIngredientsPDF(ingredients)
+	pdfBytes, err := DefaultPDFGenerator{Store: store}.GenerateIngredientsPDF(ingredients)
 	if err != nil {
 		return fmt.Errorf("failed to generate ingredients PDF: %w", err)
 	}
@@ -314,5 +493,22 @@ func (c Config) CreateAndSendEmail() error {
 		return fmt.Errorf("failed to send email: %w", err)
 	}
 
+	// 6) Freeze the emailed meals, so the calendar keeps showing what was shopped for.
+	// The email is already sent, so failing here would only invite sending it again.
+	if c.GenerateOptions.Stable {
+		if err := meal_collection.SnapshotMealsInDB(c.PostgresURL, emailedMeals(nextWeekDays, meals)); err != nil {
+			log.Printf("Error: email sent, but failed to snapshot the emailed meals: %v\n", err)
+		}
+	}
+
 	return nil
 }
+
+// emailedMeals returns the snapshot entries freezing each of days to the meal emailed for it.
+func emailedMeals(days []Date, meals []meal_collection.Meal) []meal_collection.PlannedMeal {
+	emailed := make([]meal_collection.PlannedMeal, 0, len(days))
+	for i, day := range days {
+		emailed = append(emailed, meal_collection.PlannedMeal{Date: day.ToTime(), Meal: meals[i].BaseName()})
+	}
+	return emailed
+}
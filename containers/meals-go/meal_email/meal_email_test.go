@@ -1,7 +1,13 @@
 package meal_email
 
 import (
+	"fmt"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/andrewpollack/pi-infrastructure/containers/meals-go/config"
+	"github.com/andrewpollack/pi-infrastructure/containers/meals-go/meal_collection"
 )
 
 const MEALS_JSON = "../data/recipes.json"
@@ -157,3 +163,170 @@ func TestNextWeekGeneration(t *testing.T) {
 		}
 	}
 }
+
+func TestGroceryListUnsortedAisle(t *testing.T) {
+	original := config.Cfg.App.Aisles
+	defer func() { config.Cfg.App.Aisles = original }()
+	config.Cfg.App.Aisles = []string{"Produce"}
+
+	ingredients := []meal_collection.Ingredient{
+		{Name: "Onion", Quantity: 1, Unit: meal_collection.UnitCount, Aisle: "Produce"},
+		{Name: "Napkins", Quantity: 1, Unit: meal_collection.UnitCount, Aisle: "Paper"},
+	}
+
+	list := GenerateGroceryList(DefaultStore(), ingredients)
+	unsorted := strings.Index(list, "<h4>Unsorted</h4>")
+	if unsorted == -1 {
+		t.Fatalf("Expected an Unsorted section, got %s", list)
+	}
+	if napkins := strings.Index(list, "Napkins"); napkins < unsorted {
+		t.Errorf("Expected Napkins under the Unsorted section, got %s", list)
+	}
+
+	if list := GenerateGroceryList(DefaultStore(), ingredients[:1]); strings.Contains(list, "Unsorted") {
+		t.Errorf("Expected no Unsorted section when every aisle is configured, got %s", list)
+	}
+}
+
+func TestStoreAisleMap(t *testing.T) {
+	original := config.Cfg.App.Stores
+	defer func() { config.Cfg.App.Stores = original }()
+	config.Cfg.App.Stores = []config.Store{
+		{
+			Name:         "Corner",
+			Aisles:       []string{"Front", "Back"},
+			PdfLayout:    []int{2},
+			AisleRenames: map[string]string{"Produce": "Front"},
+			AisleMap:     map[string]string{"onion": "Back"},
+		},
+		{
+			Name:     "Broken",
+			Aisles:   []string{"Front"},
+			AisleMap: map[string]string{"onion": "Missing"},
+		},
+		{
+			Name:         "Misnamed",
+			Aisles:       []string{"Front"},
+			AisleRenames: map[string]string{"Produce": "Missing"},
+		},
+	}
+
+	store, err := FindStore("Corner")
+	if err != nil {
+		t.Fatalf("Expected store to be found, got %v", err)
+	}
+	if _, err := FindStore("Broken"); err == nil {
+		t.Errorf("Expected store mapping to an unknown aisle to be rejected")
+	}
+	if _, err := FindStore("Misnamed"); err == nil {
+		t.Errorf("Expected store renaming to an unknown aisle to be rejected")
+	}
+	if _, err := FindStore("Nowhere"); err == nil {
+		t.Errorf("Expected unknown store to be rejected")
+	}
+
+	ingredients := []meal_collection.Ingredient{
+		{Name: "Onion", Quantity: 1, Unit: meal_collection.UnitCount, Aisle: "Produce"},
+		{Name: "Garlic", Quantity: 2, Unit: meal_collection.UnitCount, Aisle: "Produce"},
+	}
+	list := GenerateGroceryList(store, ingredients)
+	back := strings.Index(list, "<h4>Back</h4>")
+	if back == -1 || strings.Index(list, "Onion") < back {
+		t.Errorf("Expected Onion under the store's Back aisle, got %s", list)
+	}
+	front := strings.Index(list, "<h4>Front</h4>")
+	if garlic := strings.Index(list, "Garlic"); front == -1 || garlic < front || garlic > back {
+		t.Errorf("Expected Garlic under the store's renamed Front aisle, got %s", list)
+	}
+	if strings.Contains(list, "Unsorted") {
+		t.Errorf("Expected mapped ingredient not to be unsorted, got %s", list)
+	}
+}
+
+func TestGroceryListCheckStock(t *testing.T) {
+	original := config.Cfg.App.Aisles
+	defer func() { config.Cfg.App.Aisles = original }()
+	config.Cfg.App.Aisles = []string{"Produce"}
+
+	ingredients := []meal_collection.Ingredient{
+		{Name: "Onion", Quantity: 1, Unit: meal_collection.UnitCount, Aisle: "Produce"},
+		{Name: "Garlic", Quantity: 2, Unit: meal_collection.UnitCount, Aisle: "Produce", CheckStock: true},
+	}
+
+	list := GenerateGroceryList(DefaultStore(), ingredients)
+	checkStock := strings.Index(list, "<h4>Check Stock</h4>")
+	if checkStock == -1 {
+		t.Fatalf("Expected a Check Stock section, got %s", list)
+	}
+	if garlic := strings.Index(list, "Garlic"); garlic < checkStock {
+		t.Errorf("Expected Garlic only under the Check Stock section, got %s", list)
+	}
+}
+
+func TestTableShowsPrepTimeAndLeftovers(t *testing.T) {
+	meals := make([]meal_collection.Meal, 7)
+	for i := range meals {
+		meals[i] = meal_collection.Meal{Name: fmt.Sprintf("Meal %d", i)}
+	}
+	meals[1].PrepMinutes, meals[1].ActiveMinutes = 45, 20
+	meals[2].PrepMinutes = 30
+	meals[3] = meal_collection.Meal{
+		Name:        meal_collection.MEAL_LEFTOVERS.Name,
+		LeftoversOf: &meal_collection.LeftoverSource{Meal: "Chili", Date: time.Date(2024, time.September, 2, 0, 0, 0, 0, time.UTC)},
+	}
+
+	table := generateTable(meals, nil)
+	if !strings.Contains(table, "Meal 1<br><small>45 min (20 active)</small>") {
+		t.Errorf("Expected prep and active time under Meal 1, got %s", table)
+	}
+	if !strings.Contains(table, "Meal 2<br><small>30 min</small>") {
+		t.Errorf("Expected prep time under Meal 2, got %s", table)
+	}
+	if !strings.Contains(table, "<td>Leftovers: Chili (from Mon)</td>") {
+		t.Errorf("Expected leftovers to show their source meal, got %s", table)
+	}
+	if strings.Contains(table, "Meal 0<br>") {
+		t.Errorf("Expected no time under a meal without one, got %s", table)
+	}
+}
+
+func TestGenerateEmailContentHTMLShowsEstimates(t *testing.T) {
+	original := config.Cfg.App.Aisles
+	defer func() { config.Cfg.App.Aisles = original }()
+	config.Cfg.App.Aisles = []string{"Produce"}
+
+	meals := make([]meal_collection.Meal, 7)
+	estimates := make([]meal_collection.Estimate, 7)
+	for i := range meals {
+		meals[i] = meal_collection.Meal{Name: fmt.Sprintf("Meal %d", i)}
+		estimates[i] = meal_collection.Estimate{Cost: 2}
+	}
+
+	// No database is configured, so the estimates can only come from the caller
+	html, err := Config{}.GenerateEmailContentHTML(Date{Year: 2024, Month: 10, Day: 7}, nil, meals, estimates, nil)
+	if err != nil {
+		t.Fatalf("Expected the email to render, got %v", err)
+	}
+	if total := fmt.Sprintf("<b>Week total:</b> %s", meal_collection.Estimate{Cost: 14}); !strings.Contains(html, total) {
+		t.Errorf("Expected the week's total estimate, got %s", html)
+	}
+}
+
+func TestEmailedMeals(t *testing.T) {
+	days := GetDaysOfNextWeek(Date{Year: 2024, Month: 10, Day: 7})
+	meals := make([]meal_collection.Meal, len(days))
+	for i := range meals {
+		meals[i] = meal_collection.Meal{Name: fmt.Sprintf("Meal %d", i)}
+	}
+	meals[2].Name += meal_collection.RESHUFFLED_SUFFIX
+
+	emailed := emailedMeals(days, meals)
+	if len(emailed) != len(days) {
+		t.Fatalf("Expected every emailed day to be frozen, got %v", emailed)
+	}
+	for i, entry := range emailed {
+		if !entry.Date.Equal(days[i].ToTime()) || entry.Meal != fmt.Sprintf("Meal %d", i) {
+			t.Errorf("Expected day %d frozen to 'Meal %d', got %+v", i, i, entry)
+		}
+	}
+}
@@ -14,10 +14,16 @@ type PDFGenerator interface {
 	GenerateIngredientsPDF(ingredients []meal_collection.Ingredient) ([]byte, error)
 }
 
-type DefaultPDFGenerator struct{}
+// DEFAULT_PDF_COLUMNS is used for rows beyond the configured pdf_layout.
+const DEFAULT_PDF_COLUMNS = 3
+
+// DefaultPDFGenerator lays out the grocery list by the aisles of Store.
+type DefaultPDFGenerator struct {
+	Store config.Store
+}
 
 func (d DefaultPDFGenerator) GenerateIngredientsPDF(ingredients []meal_collection.Ingredient) ([]byte, error) {
-	htmlContent := buildHTMLContent(ingredients)
+	htmlContent := buildHTMLContent(d.Store, ingredients)
 	pdfBytes, err := convertHTMLToPDF(htmlContent)
 	if err != nil {
 		return nil, fmt.Errorf("error converting HTML to PDF: %w", err)
@@ -47,7 +53,7 @@ func convertHTMLToPDF(html string) ([]byte, error) {
 	return pdfg.Bytes(), nil
 }
 
-func buildHTMLContent(ingredients []meal_collection.Ingredient) string {
+func buildHTMLContent(store config.Store, ingredients []meal_collection.Ingredient) string {
 	const FONT_SIZE = 16
 	const CHECKBOX_SIZE = FONT_SIZE - 2
 	const LARGER_FONT_SIZE = 18
@@ -145,23 +151,33 @@ func buildHTMLContent(ingredients []meal_collection.Ingredient) string {
 		sb.WriteString("</table>\n")
 	}
 
-	pdfLayout := config.Cfg.App.PdfLayout
+	ingredients = applyAisleMap(store, ingredients)
+
+	pdfLayout := store.PdfLayout
+	// Pop the next value from pdfLayout, falling back once it runs out
+	nextLayout := func() int {
+		if len(pdfLayout) == 0 {
+			return DEFAULT_PDF_COLUMNS
+		}
+		layout := pdfLayout[0]
+		pdfLayout = pdfLayout[1:]
+		return layout
+	}
+
 	currCol := 1
-	// Pop the first value from pdfLayout
-	currLayout := pdfLayout[0]
-	pdfLayout = pdfLayout[1:]
+	currLayout := nextLayout()
 	totalRows := 0
 
 	// Generate table cells.
-	for _, aisle := range config.Cfg.App.Aisles {
+	aisles := store.Aisles
+	for _, aisle := range groceryAisles(aisles, ingredients) {
 		// If we've exceeded the current layout, close the table and start a new one.
 		if currCol > currLayout {
 			sb.WriteString("  </tr>\n")
 			closeTable()
 			totalRows += 1
 			currCol = 1
-			currLayout = pdfLayout[0]
-			pdfLayout = pdfLayout[1:]
+			currLayout = nextLayout()
 
 			if totalRows%2 == 0 {
 				sb.WriteString(`<div class="page-break"></div>` + "\n")
@@ -174,12 +190,13 @@ func buildHTMLContent(ingredients []meal_collection.Ingredient) string {
 			sb.WriteString("  <tr>\n")
 		}
 
+		itemsForAisle := ingredientsForAisle(aisle, aisles, ingredients)
 		var aisleHTML string
 		switch currLayout {
 		case 3:
-			aisleHTML = buildAisleCellHTML(meal_collection.Aisle(aisle), ingredients, "cell-three")
+			aisleHTML = buildAisleCellHTML(aisle, itemsForAisle, "cell-three")
 		case 2:
-			aisleHTML = buildAisleCellHTML(meal_collection.Aisle(aisle), ingredients, "cell-two")
+			aisleHTML = buildAisleCellHTML(aisle, itemsForAisle, "cell-two")
 		}
 		sb.WriteString(aisleHTML)
 
@@ -193,19 +210,11 @@ func buildHTMLContent(ingredients []meal_collection.Ingredient) string {
 	return sb.String()
 }
 
-func buildAisleCellHTML(aisle meal_collection.Aisle, ingredients []meal_collection.Ingredient, cellClass string) string {
+func buildAisleCellHTML(aisle meal_collection.Aisle, itemsForAisle []meal_collection.Ingredient, cellClass string) string {
 	var sb strings.Builder
 	// Use the provided cellClass in the td element.
 	sb.WriteString(fmt.Sprintf("    <td class=\"%s\">\n      <h3>%s</h3>\n", cellClass, aisle))
 
-	// Filter ingredients for the current aisle.
-	var itemsForAisle []meal_collection.Ingredient
-	for _, ing := range ingredients {
-		if ing.Aisle == aisle {
-			itemsForAisle = append(itemsForAisle, ing)
-		}
-	}
-
 	sb.WriteString("      <div class=\"checkbox-group\">\n")
 	totalCheckboxes := 28
 	if cellClass == "cell-three" {
@@ -0,0 +1,91 @@
+package meal_email
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/andrewpollack/pi-infrastructure/containers/meals-go/config"
+	"github.com/andrewpollack/pi-infrastructure/containers/meals-go/meal_collection"
+)
+
+// DefaultStore is the store described by app.aisles and app.pdf_layout.
+func DefaultStore() config.Store {
+	return config.Store{
+		Aisles:    config.Cfg.App.Aisles,
+		PdfLayout: config.Cfg.App.PdfLayout,
+	}
+}
+
+// FindStore returns the store profile with the given name from app.stores, or the
+// default store when the name is empty.
+func FindStore(name string) (config.Store, error) {
+	if name == "" {
+		return DefaultStore(), nil
+	}
+
+	for _, store := range config.Cfg.App.Stores {
+		if store.Name == name {
+			if err := validateStore(store); err != nil {
+				return config.Store{}, fmt.Errorf("invalid store '%s': %v", name, err)
+			}
+			return store, nil
+		}
+	}
+	return config.Store{}, fmt.Errorf("store not found: %s", name)
+}
+
+// StoreNames lists the names of the configured store profiles.
+func StoreNames() []string {
+	names := make([]string, 0, len(config.Cfg.App.Stores))
+	for _, store := range config.Cfg.App.Stores {
+		names = append(names, store.Name)
+	}
+	return names
+}
+
+func validateStore(store config.Store) error {
+	if len(store.Aisles) == 0 {
+		return fmt.Errorf("no aisles configured")
+	}
+	for _, columns := range store.PdfLayout {
+		if columns != 2 && columns != 3 {
+			return fmt.Errorf("pdf_layout rows must have 2 or 3 columns, got %d", columns)
+		}
+	}
+	for recipeAisle, aisle := range store.AisleRenames {
+		if !slices.Contains(store.Aisles, aisle) {
+			return fmt.Errorf("aisle '%s' is renamed to unknown aisle '%s'", recipeAisle, aisle)
+		}
+	}
+	for ingredient, aisle := range store.AisleMap {
+		if !slices.Contains(store.Aisles, aisle) {
+			return fmt.Errorf("ingredient '%s' is mapped to unknown aisle '%s'", ingredient, aisle)
+		}
+	}
+	return nil
+}
+
+// applyAisleMap moves ingredients to the aisle the store shelves them in: first by
+// renaming their recipe aisle, then by any per-ingredient override.
+func applyAisleMap(store config.Store, ingredients []meal_collection.Ingredient) []meal_collection.Ingredient {
+	if len(store.AisleRenames) == 0 && len(store.AisleMap) == 0 {
+		return ingredients
+	}
+
+	aisleMap := make(map[string]meal_collection.Aisle, len(store.AisleMap))
+	for ingredient, aisle := range store.AisleMap {
+		aisleMap[meal_collection.NormalizeIngredientName(ingredient)] = meal_collection.Aisle(aisle)
+	}
+
+	mapped := make([]meal_collection.Ingredient, len(ingredients))
+	for i, ing := range ingredients {
+		if aisle, found := store.AisleRenames[string(ing.Aisle)]; found {
+			ing.Aisle = meal_collection.Aisle(aisle)
+		}
+		if aisle, found := aisleMap[meal_collection.NormalizeIngredientName(ing.Name)]; found {
+			ing.Aisle = aisle
+		}
+		mapped[i] = ing
+	}
+	return mapped
+}
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS meal_snapshot (
+    date DATE PRIMARY KEY,
+    date_created TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    date_modified TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    meal VARCHAR(255) NOT NULL
+);
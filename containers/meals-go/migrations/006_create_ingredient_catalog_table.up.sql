@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS ingredient_catalog (
+    id SERIAL PRIMARY KEY,
+    date_created TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    date_modified TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    name VARCHAR(255) NOT NULL UNIQUE,
+    aliases TEXT[] NOT NULL DEFAULT '{}',
+    aisle VARCHAR(255) NOT NULL DEFAULT ''
+);
@@ -0,0 +1,4 @@
+ALTER TABLE meal_plan
+DROP COLUMN IF EXISTS servings;
+ALTER TABLE recipes
+DROP COLUMN IF EXISTS servings;
@@ -0,0 +1,4 @@
+ALTER TABLE recipes
+ADD COLUMN IF NOT EXISTS servings INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE meal_plan
+ADD COLUMN IF NOT EXISTS servings INTEGER NOT NULL DEFAULT 0;
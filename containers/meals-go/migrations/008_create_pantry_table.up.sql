@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS pantry (
+    id SERIAL PRIMARY KEY,
+    date_created TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    date_modified TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    name VARCHAR(255) NOT NULL UNIQUE,
+    quantity DOUBLE PRECISION NOT NULL DEFAULT 0,
+    unit VARCHAR(255) NOT NULL DEFAULT '',
+    staple BOOLEAN NOT NULL DEFAULT false
+);
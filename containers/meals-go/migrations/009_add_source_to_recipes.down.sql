@@ -0,0 +1,2 @@
+ALTER TABLE recipes
+DROP COLUMN IF EXISTS source;
@@ -0,0 +1,2 @@
+ALTER TABLE recipes
+ADD COLUMN IF NOT EXISTS source VARCHAR(255) NOT NULL DEFAULT 'sync';
@@ -0,0 +1,4 @@
+CREATE TABLE IF NOT EXISTS deleted_recipes (
+    name VARCHAR(255) PRIMARY KEY,
+    date_created TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
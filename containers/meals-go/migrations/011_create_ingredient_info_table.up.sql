@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS ingredient_info (
+    id SERIAL PRIMARY KEY,
+    date_created TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    date_modified TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    name VARCHAR(255) NOT NULL,
+    unit VARCHAR(255) NOT NULL,
+    price DOUBLE PRECISION NOT NULL DEFAULT 0,
+    calories DOUBLE PRECISION NOT NULL DEFAULT 0,
+    protein DOUBLE PRECISION NOT NULL DEFAULT 0,
+    UNIQUE (name, unit)
+);
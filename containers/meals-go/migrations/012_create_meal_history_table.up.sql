@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS meal_history (
+    id SERIAL PRIMARY KEY,
+    date_created TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    date_modified TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    date DATE NOT NULL,
+    meal VARCHAR(255) NOT NULL,
+    status VARCHAR(32) NOT NULL,
+    rating INTEGER NOT NULL DEFAULT 0 CHECK (rating BETWEEN 0 AND 5),
+    notes TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS meal_history_date_idx ON meal_history (date);
@@ -0,0 +1,4 @@
+ALTER TABLE recipes
+DROP COLUMN IF EXISTS available_to;
+ALTER TABLE recipes
+DROP COLUMN IF EXISTS available_from;
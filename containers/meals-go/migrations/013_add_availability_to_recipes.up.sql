@@ -0,0 +1,3 @@
+ALTER TABLE recipes
+ADD COLUMN IF NOT EXISTS available_from VARCHAR(5) NOT NULL DEFAULT '',
+ADD COLUMN IF NOT EXISTS available_to VARCHAR(5) NOT NULL DEFAULT '';
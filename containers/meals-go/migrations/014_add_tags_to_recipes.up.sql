@@ -0,0 +1,2 @@
+ALTER TABLE recipes
+ADD COLUMN IF NOT EXISTS tags TEXT[] NOT NULL DEFAULT '{}';
@@ -0,0 +1,4 @@
+ALTER TABLE recipes
+DROP COLUMN IF EXISTS active_minutes;
+ALTER TABLE recipes
+DROP COLUMN IF EXISTS prep_minutes;
@@ -0,0 +1,3 @@
+ALTER TABLE recipes
+ADD COLUMN IF NOT EXISTS prep_minutes INTEGER NOT NULL DEFAULT 0,
+ADD COLUMN IF NOT EXISTS active_minutes INTEGER NOT NULL DEFAULT 0;
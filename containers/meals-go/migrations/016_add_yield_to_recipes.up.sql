@@ -0,0 +1,2 @@
+ALTER TABLE recipes
+ADD COLUMN IF NOT EXISTS yield INTEGER NOT NULL DEFAULT 0;
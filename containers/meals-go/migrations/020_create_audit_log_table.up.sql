@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS audit_log (
+    id SERIAL PRIMARY KEY,
+    date_created TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    actor VARCHAR(255) NOT NULL,
+    ip VARCHAR(64) NOT NULL,
+    action VARCHAR(32) NOT NULL,
+    details TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_date_created_idx ON audit_log (date_created);
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS api_tokens (
+    id SERIAL PRIMARY KEY,
+    date_created TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    username VARCHAR(255) NOT NULL REFERENCES users (username) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    scopes TEXT[] NOT NULL,
+    session_version INTEGER NOT NULL,
+    last_used TIMESTAMP WITH TIME ZONE,
+    UNIQUE (username, name)
+);
@@ -27,6 +27,7 @@ import (
 	_ "github.com/knadh/koanf/providers/file"
 	_ "github.com/knadh/koanf/v2"
 	_ "github.com/lib/pq"
+	_ "golang.org/x/crypto/bcrypt"
 	_ "golang.org/x/exp/slices"
 )
 